@@ -1,20 +1,28 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/yourusername/backyardBackup/config"
 	"github.com/yourusername/backyardBackup/internal/backup"
+	"github.com/yourusername/backyardBackup/internal/catalog"
 	"github.com/yourusername/backyardBackup/internal/database"
+	"github.com/yourusername/backyardBackup/internal/encryption"
 	"github.com/yourusername/backyardBackup/internal/logging"
+	"github.com/yourusername/backyardBackup/internal/notification"
+	"github.com/yourusername/backyardBackup/internal/restore"
+	"github.com/yourusername/backyardBackup/internal/retention"
+	"github.com/yourusername/backyardBackup/internal/scheduler"
 	"github.com/yourusername/backyardBackup/internal/storage"
 )
 
@@ -31,6 +39,14 @@ var (
 	outputDir      string
 	includeTables  string
 	excludeTables  string
+	allBackups     bool
+	dryRun         bool
+	explain        bool
+	assumeYes      bool
+	pointInTime    string
+	targetLSN      string
+	targetDB       string
+	noSnapshot     bool
 )
 
 func init() {
@@ -45,16 +61,24 @@ func init() {
 	// Backup/restore options
 	flag.StringVar(&dbName, "db", "", "Database name from configuration")
 	flag.StringVar(&storeName, "storage", "", "Storage name from configuration")
-	flag.StringVar(&backupType, "type", "full", "Backup type (full, incremental, differential)")
+	flag.StringVar(&backupType, "type", "full", "Backup type (full, incremental, differential, physical)")
 	flag.StringVar(&backupID, "id", "", "Backup ID for restore")
 	flag.BoolVar(&compress, "compress", true, "Compress backup")
 	flag.StringVar(&outputDir, "output", "", "Output directory for restore")
 	flag.StringVar(&includeTables, "include", "", "Tables to include (comma-separated)")
 	flag.StringVar(&excludeTables, "exclude", "", "Tables to exclude (comma-separated)")
+	flag.BoolVar(&allBackups, "all", false, "Apply the command to all backups (verify)")
+	flag.BoolVar(&dryRun, "dry-run", false, "Show what would be deleted without deleting (prune)")
+	flag.BoolVar(&explain, "explain", false, "Show the retention rule that kept or deleted each backup (prune)")
+	flag.BoolVar(&assumeYes, "yes", false, "Overwrite the database without asking for confirmation (restore)")
+	flag.StringVar(&pointInTime, "time", "", "Point in time to recover to, e.g. 2024-05-01T12:00:00Z (restore)")
+	flag.StringVar(&targetLSN, "lsn", "", "PostgreSQL WAL position to recover to (restore)")
+	flag.StringVar(&targetDB, "target", "", "Database, SQLite file or new server database to restore into instead of -db (restore)")
+	flag.BoolVar(&noSnapshot, "no-snapshot", false, "Restore without a snapshot, a full copy of the restored tables, to roll back to on failure (restore)")
 }
 
 func main() {
-	flag.Parse()
+	command := parseCommand()
 	
 	// Load configuration
 	cfg, err := loadConfig()
@@ -80,6 +104,8 @@ func main() {
 	
 	// Determine which command to run
 	switch {
+	case len(command) > 0:
+		err = runCommand(ctx, cfg, logger, command)
 	case backupCmd:
 		err = runBackup(ctx, cfg, logger)
 	case restoreCmd:
@@ -133,6 +159,10 @@ func loadConfig() (*config.Config, error) {
 	return config.DefaultConfig(), nil
 }
 
+// setupSignalHandler cancels the context on the first interrupt, so the
+// running command can finish cleaning up (the daemon waits for running
+// backups, a restore rolls back) and return. A second interrupt kills the
+// process, unless the command handles it itself like the daemon does.
 func setupSignalHandler(cancel context.CancelFunc) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -140,10 +170,8 @@ func setupSignalHandler(cancel context.CancelFunc) {
 	go func() {
 		<-c
 		fmt.Println("\nReceived signal, shutting down...")
+		signal.Stop(c)
 		cancel()
-		// Give operations a moment to clean up
-		time.Sleep(500 * time.Millisecond)
-		os.Exit(0)
 	}()
 }
 
@@ -156,85 +184,6 @@ func runBackup(ctx context.Context, cfg *config.Config, logger *logging.Logger)
 		return fmt.Errorf("storage name is required for backup")
 	}
 	
-	// Check if database configuration exists
-	dbConfig, ok := cfg.Databases[dbName]
-	if !ok {
-		return fmt.Errorf("database %q not found in configuration", dbName)
-	}
-	
-	// Check if storage configuration exists
-	storeConfig, ok := cfg.Storage[storeName]
-	if !ok {
-		return fmt.Errorf("storage %q not found in configuration", storeName)
-	}
-	
-	// Initialize database connector
-	var db database.Connector
-	switch dbConfig.Type {
-	case database.SQLite:
-		db = &database.SQLiteConnector{}
-	case database.MySQL:
-		db = &database.MySQLConnector{}
-	case database.PostgreSQL:
-		db = &database.PostgreSQLConnector{}
-	case database.MongoDB:
-		db = &database.MongoDBConnector{}
-	default:
-		return fmt.Errorf("unsupported database type: %s", dbConfig.Type)
-	}
-	
-	// Connect to database
-	dbConnConfig := database.ConnectConfig{
-		Type:     dbConfig.Type,
-		Host:     dbConfig.Host,
-		Port:     dbConfig.Port,
-		User:     dbConfig.User,
-		Password: dbConfig.Password,
-		Database: dbConfig.Database,
-		SSLMode:  dbConfig.SSLMode,
-		FilePath: dbConfig.FilePath,
-		Options:  dbConfig.Options,
-	}
-	
-	logger.Info("Connecting to database %s", dbName)
-	if err := db.Connect(ctx, dbConnConfig); err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
-	}
-	defer db.Close()
-	
-	// Initialize storage provider
-	var store storage.Provider
-	switch storeConfig.Type {
-	case storage.Local:
-		store = &storage.LocalProvider{}
-	case storage.S3:
-		store = &storage.S3Provider{}
-	case storage.GCS:
-		// Implement GCS provider
-		return fmt.Errorf("GCS storage provider not implemented yet")
-	case storage.Azure:
-		store = &storage.AzureProvider{}
-	default:
-		return fmt.Errorf("unsupported storage type: %s", storeConfig.Type)
-	}
-	
-	// Initialize storage
-	storeProvConfig := storage.ProviderConfig{
-		Type:      storeConfig.Type,
-		BasePath:  storeConfig.BasePath,
-		Bucket:    storeConfig.Bucket,
-		Region:    storeConfig.Region,
-		Endpoint:  storeConfig.Endpoint,
-		AccessKey: storeConfig.AccessKey,
-		SecretKey: storeConfig.SecretKey,
-		Options:   storeConfig.Options,
-	}
-	
-	logger.Info("Initializing storage %s", storeName)
-	if err := store.Initialize(ctx, storeProvConfig); err != nil {
-		return fmt.Errorf("failed to initialize storage: %w", err)
-	}
-	
 	// Determine backup type
 	var backupTypeEnum backup.BackupType
 	switch strings.ToLower(backupType) {
@@ -244,6 +193,8 @@ func runBackup(ctx context.Context, cfg *config.Config, logger *logging.Logger)
 		backupTypeEnum = backup.Incremental
 	case "differential":
 		backupTypeEnum = backup.Differential
+	case "physical":
+		backupTypeEnum = backup.Physical
 	default:
 		return fmt.Errorf("unsupported backup type: %s", backupType)
 	}
@@ -258,40 +209,340 @@ func runBackup(ctx context.Context, cfg *config.Config, logger *logging.Logger)
 		ExcludeTables: parseTables(excludeTables),
 	}
 	
+	result, err := performBackup(ctx, cfg, logger, backupOpts)
+	if err != nil {
+		return err
+	}
+	
+	// Log result
+	logger.Info("Backup completed successfully:")
+	logger.Info("  ID:        %s", result.ID)
+	logger.Info("  Type:      %s", result.Type)
+	logger.Info("  Size:      %d bytes", result.Size)
+	logger.Info("  Duration:  %s", result.EndTime.Sub(result.StartTime))
+	logger.Info("  Path:      %s", result.StoragePath)
+	
+	return nil
+}
+
+// performBackup backs up opts.SourceDB to opts.DestStorage. Encryption and
+// storage compression settings are taken from the configuration; compression
+// settings already present in opts take precedence.
+func performBackup(ctx context.Context, cfg *config.Config, logger *logging.Logger, opts backup.BackupOptions) (*backup.BackupResult, error) {
+	db, err := openDatabase(ctx, cfg, logger, opts.SourceDB)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	
+	store, err := openStorage(ctx, cfg, logger, opts.DestStorage)
+	if err != nil {
+		return nil, err
+	}
+	
+	cat, err := catalog.Open(cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup catalog: %w", err)
+	}
+	defer cat.Close()
+	
+	if err := applyStorageOptions(cfg, &opts); err != nil {
+		return nil, err
+	}
+	
 	// Create backuper
 	var backuper backup.Backuper
-	switch backupTypeEnum {
+	switch opts.Type {
 	case backup.Full:
-		backuper = backup.NewFullBackup(db, store)
+		fullBackup := backup.NewFullBackup(db, store)
+		fullBackup.Catalog = cat
+		fullBackup.StorageName = opts.DestStorage
+		fullBackup.Logger = logger
+		backuper = fullBackup
 	case backup.Incremental:
-		// Implement incremental backup
-		return fmt.Errorf("incremental backup not implemented yet")
+		incBackup := backup.NewIncrementalBackup(db, store)
+		incBackup.Catalog = cat
+		incBackup.StorageName = opts.DestStorage
+		backuper = incBackup
 	case backup.Differential:
-		// Implement differential backup
-		return fmt.Errorf("differential backup not implemented yet")
+		diffBackup := backup.NewDifferentialBackup(db, store)
+		diffBackup.Catalog = cat
+		diffBackup.StorageName = opts.DestStorage
+		diffBackup.Logger = logger
+		backuper = diffBackup
+	case backup.Physical:
+		physBackup := backup.NewPhysicalBackup(db, store)
+		physBackup.Catalog = cat
+		physBackup.StorageName = opts.DestStorage
+		backuper = physBackup
+	default:
+		return nil, fmt.Errorf("unsupported backup type: %s", opts.Type)
 	}
 	
 	// Perform backup
-	logger.Info("Starting %s backup of database %s", backupType, dbName)
-	result, err := backuper.Backup(ctx, backupOpts)
+	logger.Info("Starting %s backup of database %s", opts.Type, opts.SourceDB)
+	result, err := backuper.Backup(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("backup failed: %w", err)
+	}
+	
+	return result, nil
+}
+
+// applyStorageOptions sets the encryption keys and compression settings of
+// the destination storage on opts. Compression settings already present in
+// opts take precedence.
+func applyStorageOptions(cfg *config.Config, opts *backup.BackupOptions) error {
+	keys, err := loadKeyring(cfg, opts.DestStorage)
+	if err != nil {
+		return err
+	}
+	opts.EncryptionKey = keys.Active()
+	opts.Keys = keys
+	
+	// Apply storage compression settings unless overridden
+	if compConfig := cfg.Storage[opts.DestStorage].Compression; compConfig != nil && opts.Compression == "" {
+		opts.Compression = compConfig.Type
+		opts.CompressionLevel = compConfig.Level
+		opts.LongDistance = compConfig.LongDistance
+		if opts.CompressionWorkers == 0 {
+			opts.CompressionWorkers = compConfig.Workers
+		}
+	}
+	if opts.CompressionWorkers == 0 {
+		opts.CompressionWorkers = cfg.Concurrency
+	}
+	
+	return nil
+}
+
+// runRestore restores a backup looked up in the catalog into its database,
+// or writes it to the output directory. Without -id, the newest backup the
+// recovery target given by -time or -lsn can be reached from is restored.
+func runRestore(ctx context.Context, cfg *config.Config, logger *logging.Logger) error {
+	if backupID == "" && pointInTime == "" && targetLSN == "" {
+		return fmt.Errorf("usage: dbbackup -restore -id <id> | -time <time> | -lsn <lsn> [-db name] [-storage name] [-target name] [-include tables] [-exclude tables] [-output dir] [-yes] [-no-snapshot]")
+	}
+	
+	cat, err := catalog.Open(cfg.DataDir)
 	if err != nil {
-		return fmt.Errorf("backup failed: %w", err)
+		return fmt.Errorf("failed to open backup catalog: %w", err)
+	}
+	defer cat.Close()
+	
+	// The backup names its database and storage
+	var info *backup.BackupResult
+	if backupID != "" {
+		info, err = cat.Get(ctx, backupID)
+		if err != nil {
+			return err
+		}
+		if !info.Success {
+			return fmt.Errorf("backup %s did not complete successfully", info.ID)
+		}
+		if dbName == "" {
+			dbName = info.SourceDB
+		}
+		if storeName == "" {
+			storeName = info.Storage
+		}
+		if info.SourceDB != dbName || info.Storage != storeName {
+			return fmt.Errorf("backup %s belongs to database %s in storage %s", info.ID, info.SourceDB, info.Storage)
+		}
+	}
+	if dbName == "" || storeName == "" {
+		return fmt.Errorf("database and storage names are required for point-in-time recovery")
+	}
+	
+	opts := restore.RestoreOptions{
+		BackupID:      backupID,
+		SourceDB:      dbName,
+		TargetLSN:     targetLSN,
+		IncludeTables: parseTables(includeTables),
+		ExcludeTables: parseTables(excludeTables),
+		NoSnapshot:    noSnapshot,
+	}
+	if pointInTime != "" {
+		if opts.PointInTime, err = parseTime(pointInTime); err != nil {
+			return err
+		}
+	}
+	filtered := len(opts.IncludeTables) > 0 || len(opts.ExcludeTables) > 0
+	if targetDB != "" && targetDB != dbName {
+		opts.TargetDB = targetDB
+	}
+	
+	store, err := openStorage(ctx, cfg, logger, storeName)
+	if err != nil {
+		return err
+	}
+	keys, err := loadKeyring(cfg, storeName)
+	if err != nil {
+		return err
+	}
+	
+	// Backups, including the parents of incremental and differential
+	// backups, are looked up through the catalog
+	backups := backup.NewFullBackup(nil, store)
+	backups.Catalog = cat
+	backups.StorageName = storeName
+	
+	restorer := restore.NewSelectiveRestorer(nil, store, backups)
+	restorer.Keys = keys
+	restorer.SnapshotDir = filepath.Join(cfg.DataDir, "snapshots")
+	restorer.RollbackTimeout = cfg.Timeout
+	
+	// Physical backups are unpacked into a data directory; a point-in-time
+	// recovery with an output directory uses one too
+	physical := targetLSN != "" || (info != nil && info.Type == backup.Physical) || (info == nil && outputDir != "")
+	
+	var result *restore.RestoreResult
+	switch {
+	case physical:
+		if outputDir == "" {
+			return fmt.Errorf("an output directory is required to restore a physical backup")
+		}
+		if filtered {
+			return fmt.Errorf("tables cannot be selected when restoring a physical backup")
+		}
+		if opts.TargetDB != "" {
+			return fmt.Errorf("physical backups are restored into the -output directory, not a target database")
+		}
+		opts.DataDir = outputDir
+		if !opts.PointInTime.IsZero() || opts.TargetLSN != "" {
+			if restorer.WALCommand, err = walFetchCommand(dbName, storeName); err != nil {
+				return err
+			}
+		}
+		logger.Info("Restoring database %s into data directory %s", dbName, outputDir)
+		result, err = restorer.Restore(ctx, opts)
+	case outputDir != "":
+		if filtered {
+			return fmt.Errorf("tables cannot be selected when writing a backup to a directory")
+		}
+		if opts.TargetDB != "" {
+			return fmt.Errorf("only one of -output and -target can be given")
+		}
+		logger.Info("Writing backup %s to %s", backupID, outputDir)
+		result, err = restorer.Extract(ctx, opts, outputDir)
+	default:
+		target := dbName
+		if opts.TargetDB != "" {
+			target = opts.TargetDB
+		}
+		if !assumeYes {
+			if err := confirmRestore(info, target, opts); err != nil {
+				return err
+			}
+		}
+		
+		// The source database is left alone when restoring into a target
+		if opts.TargetDB != "" {
+			restorer.OpenTarget = func(ctx context.Context, name string) (database.Connector, error) {
+				return openTarget(ctx, cfg, logger, dbName, name)
+			}
+		} else {
+			restorer.DB, err = openDatabase(ctx, cfg, logger, dbName)
+			if err != nil {
+				return err
+			}
+			defer restorer.DB.Close()
+		}
+		if !opts.PointInTime.IsZero() {
+			if restorer.Logs, err = openLogArchive(ctx, cfg, logger, dbName, storeName); err != nil {
+				return err
+			}
+		}
+		if opts.TargetDB != "" {
+			logger.Info("Restoring database %s into %s", dbName, target)
+		} else {
+			logger.Info("Restoring database %s", dbName)
+		}
+		result, err = restorer.Restore(ctx, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("restore failed: %w", err)
 	}
 	
 	// Log result
-	logger.Info("Backup completed successfully:")
+	logger.Info("Restore completed successfully:")
 	logger.Info("  ID:        %s", result.ID)
-	logger.Info("  Type:      %s", result.Type)
-	logger.Info("  Size:      %d bytes", result.Size)
+	logger.Info("  Backup:    %s", result.BackupID)
+	if opts.TargetDB != "" {
+		logger.Info("  Target:    %s", opts.TargetDB)
+	}
+	if len(result.Chain) > 1 {
+		logger.Info("  Chain:     %s", strings.Join(result.Chain, " -> "))
+	}
 	logger.Info("  Duration:  %s", result.EndTime.Sub(result.StartTime))
-	logger.Info("  Path:      %s", result.StoragePath)
+	if len(result.TablesRestored) > 0 {
+		logger.Info("  Tables:    %s", strings.Join(result.TablesRestored, ", "))
+	}
+	if result.OutputPath != "" {
+		logger.Info("  Output:    %s", result.OutputPath)
+	}
+	if physical {
+		logger.Info("Start PostgreSQL on %s to complete the recovery", outputDir)
+	}
 	
 	return nil
 }
 
-func runRestore(ctx context.Context, cfg *config.Config, logger *logging.Logger) error {
-	// Implement restore functionality
-	return fmt.Errorf("restore not implemented yet")
+// confirmRestore asks for confirmation before a restore overwrites the
+// current data of a database
+func confirmRestore(info *backup.BackupResult, db string, opts restore.RestoreOptions) error {
+	what := fmt.Sprintf("the newest backup before %s", opts.PointInTime.Format(time.RFC3339))
+	if info != nil {
+		what = fmt.Sprintf("%s backup %s from %s", info.Type, info.ID, info.StartTime.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Printf("Restore %s into database %s? Its current data will be overwritten. [y/N] ", what, db)
+	
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return fmt.Errorf("restore cancelled; use -yes to restore without confirmation")
+	}
+}
+
+// walFetchCommand returns the restore_command that makes PostgreSQL fetch
+// archived WAL with this program during recovery
+func walFetchCommand(db, store string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the dbbackup executable: %w", err)
+	}
+	
+	words := []string{shellQuote(exe)}
+	if configFile != "" {
+		path, err := filepath.Abs(configFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve configuration path: %w", err)
+		}
+		words = append(words, "-config", shellQuote(path))
+	}
+	words = append(words, "wal", "fetch", "-db", shellQuote(db), "-storage", shellQuote(store), "%f", "%p")
+	
+	return strings.Join(words, " "), nil
+}
+
+// shellQuote quotes a word for the shell PostgreSQL runs restore_command with
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// parseTime parses a recovery target time, either in RFC 3339 format or as
+// local time like "2006-01-02 15:04:05"
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339 or \"2006-01-02 15:04:05\"", value)
+	}
+	return t, nil
 }
 
 func runListBackups(ctx context.Context, cfg *config.Config, logger *logging.Logger) error {
@@ -303,18 +554,707 @@ func runListBackups(ctx context.Context, cfg *config.Config, logger *logging.Log
 		return fmt.Errorf("storage name is required for listing backups")
 	}
 	
+	// Check if storage configuration exists
+	if _, ok := cfg.Storage[storeName]; !ok {
+		return fmt.Errorf("storage %q not found in configuration", storeName)
+	}
+	
+	cat, err := catalog.Open(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open backup catalog: %w", err)
+	}
+	defer cat.Close()
+	
+	// List backups
+	logger.Info("Listing backups for database %s", dbName)
+	backups, err := cat.List(ctx, backup.CatalogFilter{
+		SourceDB: dbName,
+		Storage:  storeName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	
+	// Display results
+	if len(backups) == 0 {
+		fmt.Println("No backups found.")
+		fmt.Println("If the catalog was lost, run `dbbackup catalog rebuild -storage " + storeName + "`.")
+		return nil
+	}
+	
+	fmt.Println("Available backups:")
+	fmt.Println("ID                                     | Type     | Size       | Date                | Path")
+	fmt.Println("--------------------------------------- | -------- | ---------- | ------------------- | ----")
+	
+	for _, b := range backups {
+		fmt.Printf("%-38s | %-8s | %-10d | %-19s | %s\n",
+			b.ID,
+			b.Type,
+			b.Size,
+			b.StartTime.Format("2006-01-02 15:04:05"),
+			b.StoragePath,
+		)
+	}
+	
+	return nil
+}
+
+func runCommand(ctx context.Context, cfg *config.Config, logger *logging.Logger, command []string) error {
+	switch command[0] {
+	case "catalog":
+		return runCatalog(ctx, cfg, logger, command[1:])
+	case "verify":
+		return runVerify(ctx, cfg, logger)
+	case "daemon":
+		return runDaemon(ctx, cfg, logger)
+	case "prune":
+		return runPrune(ctx, cfg, logger)
+	case "logs", "wal":
+		return runLogs(ctx, cfg, logger, command[1:])
+	default:
+		return fmt.Errorf("unknown command %q", command[0])
+	}
+}
+
+func runCatalog(ctx context.Context, cfg *config.Config, logger *logging.Logger, args []string) error {
+	if len(args) == 0 || args[0] != "rebuild" {
+		return fmt.Errorf("usage: dbbackup catalog rebuild [-storage name]")
+	}
+	
+	// Rebuild a single storage, or every configured storage
+	var names []string
+	if storeName != "" {
+		names = append(names, storeName)
+	} else {
+		for name := range cfg.Storage {
+			names = append(names, name)
+		}
+	}
+	
+	cat, err := catalog.Open(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open backup catalog: %w", err)
+	}
+	defer cat.Close()
+	
+	for _, name := range names {
+		store, err := openStorage(ctx, cfg, logger, name)
+		if err != nil {
+			return err
+		}
+		
+		logger.Info("Rebuilding catalog from storage %s", name)
+		count, err := cat.Rebuild(ctx, store, name)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild catalog from storage %s: %w", name, err)
+		}
+		logger.Info("Indexed %d backups from storage %s", count, name)
+	}
+	
+	return nil
+}
+
+func runVerify(ctx context.Context, cfg *config.Config, logger *logging.Logger) error {
+	if backupID == "" && !allBackups {
+		return fmt.Errorf("usage: dbbackup verify -id <id> | -all [-db name] [-storage name]")
+	}
+	
+	cat, err := catalog.Open(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open backup catalog: %w", err)
+	}
+	defer cat.Close()
+	
+	// Collect the backups to verify
+	var backups []*backup.BackupResult
+	if backupID != "" {
+		b, err := cat.Get(ctx, backupID)
+		if err != nil {
+			return err
+		}
+		backups = append(backups, b)
+	} else {
+		backups, err = cat.List(ctx, backup.CatalogFilter{
+			SourceDB: dbName,
+			Storage:  storeName,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list backups: %w", err)
+		}
+	}
+	
+	// Group backups by storage so each provider is initialized once
+	byStorage := make(map[string][]*backup.BackupResult)
+	var storageNames []string
+	for _, b := range backups {
+		if _, ok := byStorage[b.Storage]; !ok {
+			storageNames = append(storageNames, b.Storage)
+		}
+		byStorage[b.Storage] = append(byStorage[b.Storage], b)
+	}
+	if allBackups && storeName != "" && len(byStorage) == 0 {
+		storageNames = append(storageNames, storeName)
+	}
+	
+	problems := 0
+	for _, name := range storageNames {
+		store, err := openStorage(ctx, cfg, logger, name)
+		if err != nil {
+			return err
+		}
+		
+		for _, b := range byStorage[name] {
+			result := backup.Verify(ctx, store, b)
+			switch result.Status {
+			case backup.VerifyOK:
+				fmt.Printf("%-38s | %-9s | %s\n", b.ID, result.Status, b.StoragePath)
+			case backup.VerifyUnchecked:
+				fmt.Printf("%-38s | %-9s | %s (no checksum recorded)\n", b.ID, result.Status, b.StoragePath)
+			default:
+				problems++
+				fmt.Printf("%-38s | %-9s | %s: %s\n", b.ID, result.Status, b.StoragePath, result.ErrorMessage)
+			}
+		}
+		
+		// Look for files left behind by deleted or incomplete backups
+		if allBackups {
+			orphans, err := backup.FindOrphans(ctx, store)
+			if err != nil {
+				return fmt.Errorf("failed to look for orphans in storage %s: %w", name, err)
+			}
+			for _, orphan := range orphans {
+				problems++
+				fmt.Printf("%-38s | %-9s | %s (%s)\n", "-", "orphaned", orphan.Path, orphan.Reason)
+			}
+		}
+	}
+	
+	if problems > 0 {
+		return fmt.Errorf("verification found %d problems", problems)
+	}
+	
+	logger.Info("Verified %d backups", len(backups))
+	return nil
+}
+
+// runDaemon runs the configured backup schedules until interrupted
+func runDaemon(ctx context.Context, cfg *config.Config, logger *logging.Logger) error {
+	sched := scheduler.NewScheduler(logger, func(ctx context.Context, task *scheduler.BackupTask) error {
+		if cfg.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+			defer cancel()
+		}
+		
+		result, err := performBackup(ctx, cfg, logger, task.Options)
+		if err != nil {
+			return err
+		}
+		logger.Info("Backup %s of database %s stored at %s", result.ID, task.DB, result.StoragePath)
+		
+		// Apply the retention policies now that a new backup exists
+		if _, err := pruneTarget(ctx, cfg, logger, task.DB, task.Storage, false); err != nil {
+			logger.Error("Failed to prune backups of database %s: %v", task.DB, err)
+		}
+		return nil
+	})
+	
+	if err := sched.LoadSchedules(cfg); err != nil {
+		return fmt.Errorf("failed to load schedules: %w", err)
+	}
+	
+	tasks := sched.ListTasks()
+	if len(tasks) == 0 {
+		return fmt.Errorf("no backup schedules configured")
+	}
+	for _, task := range tasks {
+		logger.Info("Scheduled %s (%s): next run at %s", task.Name, task.Schedule, task.NextRun.Format(time.RFC3339))
+	}
+	
+	if err := sched.Start(); err != nil {
+		return err
+	}
+	logger.Info("Scheduler started with %d tasks", len(tasks))
+	
+	<-ctx.Done()
+	
+	// Running backups are left to finish, up to the backup timeout; a second
+	// interrupt cancels them
+	stopCtx, cancelStop := context.WithCancel(context.Background())
+	defer cancelStop()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		stopCtx, cancel = context.WithTimeout(stopCtx, cfg.Timeout)
+		defer cancel()
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancelStop()
+		case <-stopCtx.Done():
+		}
+	}()
+	
+	logger.Info("Stopping scheduler; waiting for running backups (interrupt again to cancel them)")
+	sched.Stop(stopCtx)
+	
+	return nil
+}
+
+// runPrune deletes the backups that fall outside the retention policies of
+// the configured schedules
+func runPrune(ctx context.Context, cfg *config.Config, logger *logging.Logger) error {
+	// Prune schedules in a stable order
+	var names []string
+	for name := range cfg.Schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	
+	pruned := 0
+	failed := 0
+	seen := make(map[[2]string]bool)
+	for _, name := range names {
+		db, store, err := cfg.ScheduleTarget(name)
+		if err != nil {
+			return err
+		}
+		if (dbName != "" && db != dbName) || (storeName != "" && store != storeName) {
+			continue
+		}
+		// Schedules sharing a database and storage are pruned together
+		if seen[[2]string{db, store}] {
+			continue
+		}
+		seen[[2]string{db, store}] = true
+		
+		result, err := pruneTarget(ctx, cfg, logger, db, store, dryRun)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			continue
+		}
+		pruned++
+		
+		action := "deleted"
+		if dryRun {
+			action = "would delete"
+		}
+		if explain {
+			printDecisions(db, store, result.Decisions, action)
+		} else {
+			for _, b := range result.Deleted {
+				fmt.Printf("%-38s | %-12s | %-19s | %s\n",
+					b.ID,
+					action,
+					b.StartTime.Format("2006-01-02 15:04:05"),
+					b.StoragePath,
+				)
+			}
+			for _, segment := range result.Logs {
+				fmt.Printf("%-38s | %-12s | %-19s | %s\n",
+					segment.Name,
+					action,
+					segment.ArchivedAt.Format("2006-01-02 15:04:05"),
+					segment.StoragePath,
+				)
+			}
+		}
+		for _, err := range result.Errors {
+			fmt.Printf("error: %v\n", err)
+		}
+		failed += len(result.Errors)
+	}
+	
+	if pruned == 0 {
+		return fmt.Errorf("no schedules with a retention policy match the given database and storage")
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d backups", failed)
+	}
+	
+	return nil
+}
+
+// printDecisions prints every backup considered by the pruner together with
+// the rule that kept or deleted it
+func printDecisions(db, store string, decisions []*retention.Decision, action string) {
+	fmt.Printf("Database %s in storage %s:\n", db, store)
+	fmt.Println("ID                                     | Type         | Date                | Decision     | Reason")
+	fmt.Println("--------------------------------------- | ------------ | ------------------- | ------------ | ------")
+	
+	for _, d := range decisions {
+		decision := action
+		if d.Keep {
+			decision = "keep"
+		}
+		fmt.Printf("%-38s | %-12s | %-19s | %-12s | %s\n",
+			d.Backup.ID,
+			d.Backup.Type,
+			d.Backup.StartTime.Format("2006-01-02 15:04:05"),
+			decision,
+			d.Reason,
+		)
+	}
+	fmt.Println()
+}
+
+// pruneTarget applies the retention policies of every schedule that backs up
+// a database to a storage, keeping the backups any of them keeps, and sends a
+// notification about the backups removed. It returns nil if none of the
+// schedules has a retention policy.
+func pruneTarget(ctx context.Context, cfg *config.Config, logger *logging.Logger, db, storeName string, dryRun bool) (*retention.Result, error) {
+	policies := make(map[string]retention.Policy)
+	for name, schedule := range cfg.Schedules {
+		scheduleDB, scheduleStorage, err := cfg.ScheduleTarget(name)
+		if err != nil {
+			return nil, err
+		}
+		if scheduleDB != db || scheduleStorage != storeName {
+			continue
+		}
+		// A schedule without a policy keeps all of its backups, and so all
+		// backups of the database in the storage
+		policy := retention.PolicyFromSchedule(schedule)
+		if policy.IsEmpty() {
+			logger.Debug("Schedule %s of database %s keeps every backup", name, db)
+			return nil, nil
+		}
+		policies[name] = policy
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	
+	store, err := openStorage(ctx, cfg, logger, storeName)
+	if err != nil {
+		return nil, err
+	}
+	
+	cat, err := catalog.Open(cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup catalog: %w", err)
+	}
+	defer cat.Close()
+	
+	pruner := retention.NewPruner(store, cat, storeName, logger)
+	pruner.DryRun = dryRun
+	pruner.Logs = backup.NewLogArchive(store, cfg.Databases[db].Type, db, backup.BackupOptions{})
+	
+	logger.Info("Pruning backups of database %s in storage %s", db, storeName)
+	result, err := pruner.Prune(ctx, db, policies)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune backups of database %s: %w", db, err)
+	}
+	logger.Info("Pruned %d of %d backups of database %s", len(result.Deleted), len(result.Decisions), db)
+	
+	if dryRun {
+		return result, nil
+	}
+	
+	// Notify about what was removed
+	if len(result.Deleted) > 0 {
+		var ids []string
+		for _, b := range result.Deleted {
+			ids = append(ids, b.ID)
+		}
+		notify(ctx, cfg, logger, notification.NotificationEvent{
+			Type:       notification.Info,
+			Title:      fmt.Sprintf("Pruned %d backups of %s", len(result.Deleted), db),
+			Message:    fmt.Sprintf("Deleted from storage %s: %s", storeName, strings.Join(ids, ", ")),
+			OccurredAt: time.Now(),
+		})
+	}
+	if len(result.Errors) > 0 {
+		var messages []string
+		for _, err := range result.Errors {
+			messages = append(messages, err.Error())
+		}
+		notify(ctx, cfg, logger, notification.NotificationEvent{
+			Type:       notification.Failure,
+			Title:      fmt.Sprintf("Failed to prune %d backups of %s", len(result.Errors), db),
+			Message:    strings.Join(messages, "\n"),
+			OccurredAt: time.Now(),
+		})
+	}
+	
+	return result, nil
+}
+
+// logSpoolInterval is how often log files received by "logs receive" are uploaded
+const logSpoolInterval = 10 * time.Second
+
+// runLogs manages the transaction log archive of a database: PostgreSQL WAL
+// or MySQL binary logs. "wal" is accepted as an alias of "logs".
+func runLogs(ctx context.Context, cfg *config.Config, logger *logging.Logger, args []string) error {
+	usage := fmt.Errorf("usage: dbbackup logs archive <path> [name] | fetch <name> <path> | receive | list -db name -storage name")
+	if len(args) == 0 {
+		return usage
+	}
+	if dbName == "" || storeName == "" {
+		return fmt.Errorf("database and storage names are required for log commands")
+	}
+	
+	archive, err := openLogArchive(ctx, cfg, logger, dbName, storeName)
+	if err != nil {
+		return err
+	}
+	
+	switch args[0] {
+	case "archive":
+		// archive_command = 'dbbackup wal archive -db name -storage name %p %f'
+		if len(args) < 2 || len(args) > 3 {
+			return usage
+		}
+		name := filepath.Base(args[1])
+		if len(args) == 3 {
+			name = args[2]
+		}
+		
+		file, err := os.Open(args[1])
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		defer file.Close()
+		
+		segment, err := archive.Archive(ctx, name, file, nil, nil)
+		if err != nil {
+			return err
+		}
+		logger.Info("Archived log file %s to %s", segment.Name, segment.StoragePath)
+		return nil
+	case "fetch":
+		// restore_command = 'dbbackup wal fetch -db name -storage name %f %p'
+		if len(args) != 3 {
+			return usage
+		}
+		
+		// Write to a temporary file so PostgreSQL never sees a partial segment
+		tmp := args[2] + ".tmp"
+		file, err := os.Create(tmp)
+		if err != nil {
+			return fmt.Errorf("failed to create log file: %w", err)
+		}
+		if err := archive.Fetch(ctx, args[1], file); err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return err
+		}
+		if err := file.Close(); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to write log file: %w", err)
+		}
+		return os.Rename(tmp, args[2])
+	case "receive":
+		return receiveLogs(ctx, cfg, logger, archive)
+	case "list":
+		segments, err := archive.List(ctx)
+		if err != nil {
+			return err
+		}
+		
+		fmt.Println("Name                                     | Size       | Archived            | Last event")
+		fmt.Println("---------------------------------------- | ---------- | ------------------- | -------------------")
+		for _, segment := range segments {
+			lastEvent := ""
+			if segment.End != nil && !segment.End.Time.IsZero() {
+				lastEvent = segment.End.Time.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%-40s | %-10d | %s | %s\n",
+				segment.Name,
+				segment.Size,
+				segment.ArchivedAt.Format("2006-01-02 15:04:05"),
+				lastEvent,
+			)
+		}
+		return nil
+	default:
+		return usage
+	}
+}
+
+// receiveLogs streams the transaction log from the server and uploads every
+// completed file to the archive until the command is interrupted. Files are
+// spooled in the data directory, so files not uploaded yet survive a restart.
+func receiveLogs(ctx context.Context, cfg *config.Config, logger *logging.Logger, archive *backup.LogArchive) error {
+	db, err := openDatabase(ctx, cfg, logger, dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	
+	receiver, ok := db.(database.LogReceiver)
+	if !ok {
+		return fmt.Errorf("database %s does not support log streaming", dbName)
+	}
+	
+	spoolDir := filepath.Join(cfg.DataDir, "logs", dbName)
+	if err := os.MkdirAll(spoolDir, 0700); err != nil {
+		return fmt.Errorf("failed to create log spool directory: %w", err)
+	}
+	
+	// Resume after the newest archived file
+	from := ""
+	segments, err := archive.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 {
+		from = segments[len(segments)-1].Name
+	}
+	
+	upload := func() {
+		segments, err := archive.ArchiveSpool(ctx, spoolDir, receiver)
+		for _, segment := range segments {
+			logger.Info("Archived log file %s", segment.Name)
+		}
+		if err != nil {
+			logger.Error("Failed to archive logs: %v", err)
+		}
+	}
+	
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- receiver.ReceiveLogs(ctx, spoolDir, from)
+	}()
+	
+	logger.Info("Receiving logs of database %s into storage %s", dbName, storeName)
+	ticker := time.NewTicker(logSpoolInterval)
+	defer ticker.Stop()
+	for {
+		upload()
+		select {
+		case err := <-errCh:
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// openLogArchive opens the log archive of a database in a storage, using the
+// storage's compression and encryption settings
+func openLogArchive(ctx context.Context, cfg *config.Config, logger *logging.Logger, db, storeName string) (*backup.LogArchive, error) {
+	dbConfig, ok := cfg.Databases[db]
+	if !ok {
+		return nil, fmt.Errorf("database %q not found in configuration", db)
+	}
+	
+	store, err := openStorage(ctx, cfg, logger, storeName)
+	if err != nil {
+		return nil, err
+	}
+	
+	opts := backup.BackupOptions{
+		Compress:    compress,
+		SourceDB:    db,
+		DestStorage: storeName,
+	}
+	if err := applyStorageOptions(cfg, &opts); err != nil {
+		return nil, err
+	}
+	
+	return backup.NewLogArchive(store, dbConfig.Type, db, opts), nil
+}
+
+// notify sends a notification if a notification channel is configured.
+// Failures to notify are logged but do not fail the command.
+func notify(ctx context.Context, cfg *config.Config, logger *logging.Logger, event notification.NotificationEvent) {
+	if cfg.Notifications.SlackWebhookURL == "" {
+		return
+	}
+	if event.Type == notification.Failure && !cfg.Notifications.OnFailure {
+		return
+	}
+	if event.Type != notification.Failure && !cfg.Notifications.OnSuccess {
+		return
+	}
+	
+	notifier := notification.NewSlackNotifier(cfg.Notifications.SlackWebhookURL)
+	if err := notifier.Notify(ctx, event); err != nil {
+		logger.Warning("Failed to send notification: %v", err)
+	}
+}
+
+// openDatabase creates and connects the connector for a configured database
+func openDatabase(ctx context.Context, cfg *config.Config, logger *logging.Logger, name string) (database.Connector, error) {
 	// Check if database configuration exists
-	dbConfig, ok := cfg.Databases[dbName]
+	dbConfig, ok := cfg.Databases[name]
 	if !ok {
-		return fmt.Errorf("database %q not found in configuration", dbName)
+		return nil, fmt.Errorf("database %q not found in configuration", name)
 	}
 	
-	// Check if storage configuration exists
-	storeConfig, ok := cfg.Storage[storeName]
+	return connectDatabase(ctx, logger, name, dbConfig)
+}
+
+// openTarget connects to the database backups of the configured database
+// source are restored into: another configured database of the same type, a
+// new SQLite file, or a database on the server of source, which is created
+// if it does not exist yet
+func openTarget(ctx context.Context, cfg *config.Config, logger *logging.Logger, source, name string) (database.Connector, error) {
+	sourceConfig, ok := cfg.Databases[source]
 	if !ok {
-		return fmt.Errorf("storage %q not found in configuration", storeName)
+		return nil, fmt.Errorf("database %q not found in configuration", source)
+	}
+	
+	targetConfig, configured := cfg.Databases[name]
+	if configured {
+		if targetConfig.Type != sourceConfig.Type {
+			return nil, fmt.Errorf("cannot restore a %s backup into %s database %s", sourceConfig.Type, targetConfig.Type, name)
+		}
+	} else {
+		targetConfig = sourceConfig
+		switch sourceConfig.Type {
+		case database.SQLite:
+			path, err := filepath.Abs(name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve target path: %w", err)
+			}
+			if sourcePath, err := filepath.Abs(sourceConfig.FilePath); err == nil && sourcePath == path {
+				return nil, fmt.Errorf("target %s is the file of database %s", name, source)
+			}
+			targetConfig.FilePath = path
+		case database.MongoDB:
+			// MongoDB creates databases when they are first written to
+			targetConfig.Database = name
+		default:
+			server, err := openDatabase(ctx, cfg, logger, source)
+			if err != nil {
+				return nil, err
+			}
+			creator, ok := server.(database.DatabaseCreator)
+			if !ok {
+				server.Close()
+				return nil, fmt.Errorf("%s databases cannot be created", sourceConfig.Type)
+			}
+			logger.Info("Creating database %s", name)
+			err = creator.CreateDatabase(ctx, name)
+			server.Close()
+			if err != nil {
+				return nil, err
+			}
+			targetConfig.Database = name
+		}
 	}
 	
+	db, err := connectDatabase(ctx, logger, name, targetConfig)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Dumps of some databases name the database they were taken from
+	if retargeter, ok := db.(database.Retargeter); ok {
+		retargeter.RestoreFrom(sourceConfig.Database)
+	}
+	return db, nil
+}
+
+// connectDatabase creates a connector for a database configuration and
+// connects it
+func connectDatabase(ctx context.Context, logger *logging.Logger, name string, dbConfig config.DatabaseConfig) (database.Connector, error) {
 	// Initialize database connector
 	var db database.Connector
 	switch dbConfig.Type {
@@ -327,7 +1267,7 @@ func runListBackups(ctx context.Context, cfg *config.Config, logger *logging.Log
 	case database.MongoDB:
 		db = &database.MongoDBConnector{}
 	default:
-		return fmt.Errorf("unsupported database type: %s", dbConfig.Type)
+		return nil, fmt.Errorf("unsupported database type: %s", dbConfig.Type)
 	}
 	
 	// Connect to database
@@ -343,11 +1283,21 @@ func runListBackups(ctx context.Context, cfg *config.Config, logger *logging.Log
 		Options:  dbConfig.Options,
 	}
 	
-	logger.Info("Connecting to database %s", dbName)
+	logger.Info("Connecting to database %s", name)
 	if err := db.Connect(ctx, dbConnConfig); err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	
+	return db, nil
+}
+
+// openStorage creates and initializes the provider for a configured storage
+func openStorage(ctx context.Context, cfg *config.Config, logger *logging.Logger, name string) (storage.Provider, error) {
+	// Check if storage configuration exists
+	storeConfig, ok := cfg.Storage[name]
+	if !ok {
+		return nil, fmt.Errorf("storage %q not found in configuration", name)
 	}
-	defer db.Close()
 	
 	// Initialize storage provider
 	var store storage.Provider
@@ -355,16 +1305,14 @@ func runListBackups(ctx context.Context, cfg *config.Config, logger *logging.Log
 	case storage.Local:
 		store = &storage.LocalProvider{}
 	case storage.S3:
-		// Implement S3 provider
-		return fmt.Errorf("S3 storage provider not implemented yet")
+		store = &storage.S3Provider{}
 	case storage.GCS:
 		// Implement GCS provider
-		return fmt.Errorf("GCS storage provider not implemented yet")
+		return nil, fmt.Errorf("GCS storage provider not implemented yet")
 	case storage.Azure:
-		// Implement Azure provider
-		return fmt.Errorf("Azure storage provider not implemented yet")
+		store = &storage.AzureProvider{}
 	default:
-		return fmt.Errorf("unsupported storage type: %s", storeConfig.Type)
+		return nil, fmt.Errorf("unsupported storage type: %s", storeConfig.Type)
 	}
 	
 	// Initialize storage
@@ -379,42 +1327,58 @@ func runListBackups(ctx context.Context, cfg *config.Config, logger *logging.Log
 		Options:   storeConfig.Options,
 	}
 	
-	logger.Info("Initializing storage %s", storeName)
+	logger.Info("Initializing storage %s", name)
 	if err := store.Initialize(ctx, storeProvConfig); err != nil {
-		return fmt.Errorf("failed to initialize storage: %w", err)
+		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
 	
-	// Create backuper
-	backuper := backup.NewFullBackup(db, store)
+	return store, nil
+}
+
+// loadKeyring loads the encryption keys configured for a storage. It returns
+// nil if the storage does not use encryption.
+func loadKeyring(cfg *config.Config, name string) (*encryption.Keyring, error) {
+	encConfig := cfg.Storage[name].Encryption
+	if encConfig == nil {
+		return nil, nil
+	}
 	
-	// List backups
-	logger.Info("Listing backups for database %s", dbName)
-	backups, err := backuper.ListBackups(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to list backups: %w", err)
+	var keys []*encryption.Key
+	for _, keyConfig := range encConfig.Keys {
+		var key *encryption.Key
+		var err error
+		if keyConfig.KeyFile != "" {
+			key, err = encryption.LoadKeyFile(keyConfig.ID, keyConfig.KeyFile)
+		} else {
+			key, err = encryption.PassphraseKey(keyConfig.ID, keyConfig.Passphrase, encryption.KDF(keyConfig.KDF))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load encryption key for storage %s: %w", name, err)
+		}
+		keys = append(keys, key)
 	}
 	
-	// Display results
-	if len(backups) == 0 {
-		fmt.Println("No backups found.")
-		return nil
+	keyring, err := encryption.NewKeyring(keys, encConfig.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption configuration for storage %s: %w", name, err)
 	}
 	
-	fmt.Println("Available backups:")
-	fmt.Println("ID                                     | Type     | Size       | Date                | Path")
-	fmt.Println("--------------------------------------- | -------- | ---------- | ------------------- | ----")
+	return keyring, nil
+}
+
+// parseCommand parses the command line flags and returns the subcommand
+// words (e.g. "catalog rebuild"), allowing flags to follow them
+func parseCommand() []string {
+	flag.Parse()
 	
-	for _, b := range backups {
-		fmt.Printf("%-38s | %-8s | %-10d | %-19s | %s\n",
-			b.ID,
-			b.Type,
-			b.Size,
-			b.StartTime.Format("2006-01-02 15:04:05"),
-			b.StoragePath,
-		)
+	var command []string
+	for flag.NArg() > 0 {
+		args := flag.Args()
+		command = append(command, args[0])
+		flag.CommandLine.Parse(args[1:])
 	}
 	
-	return nil
+	return command
 }
 
 func parseTables(tablesStr string) []string {
@@ -2,10 +2,12 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/yourusername/backyardBackup/internal/compression"
 	"github.com/yourusername/backyardBackup/internal/database"
 	"github.com/yourusername/backyardBackup/internal/storage"
 )
@@ -24,6 +26,16 @@ const (
 	Error LogLevel = "error"
 )
 
+// CatchUpPolicy defines what happens to scheduled runs missed while the daemon was down
+type CatchUpPolicy string
+
+const (
+	// CatchUpRun runs a missed backup once when the daemon starts
+	CatchUpRun CatchUpPolicy = "run"
+	// CatchUpSkip skips missed backups and waits for the next scheduled run
+	CatchUpSkip CatchUpPolicy = "skip"
+)
+
 // DatabaseConfig contains database configuration
 type DatabaseConfig struct {
 	Type     database.DBType
@@ -47,15 +59,57 @@ type StorageConfig struct {
 	AccessKey string
 	SecretKey string
 	Options   map[string]string
+	Encryption *EncryptionConfig // Optional client-side encryption
+	Compression *CompressionConfig // Optional compression settings for backups in this storage
+}
+
+// CompressionConfig contains compression settings
+type CompressionConfig struct {
+	Type         compression.CompressionType // gzip, zstd, lz4, xz or none
+	Level        *int                        // Algorithm-specific level; the default when omitted
+	LongDistance bool                        // Large (128 MiB) zstd window for large dumps
+	Workers      int                         // Cores used for compression; defaults to Concurrency
+}
+
+// EncryptionConfig contains client-side encryption settings for a storage
+type EncryptionConfig struct {
+	KeyID string          // Key used to encrypt new backups
+	Keys  []EncryptionKey // All known keys; retired keys are kept to restore older backups
+}
+
+// EncryptionKey describes an encryption key
+type EncryptionKey struct {
+	ID         string
+	KeyFile    string // File containing a 32-byte key (raw, hex or base64)
+	Passphrase string // Passphrase to derive the key from, if no key file is given
+	KDF        string // Key derivation for passphrases: argon2id (default) or scrypt
 }
 
 // BackupSchedule defines when backups should occur
 type BackupSchedule struct {
+	Database          string // Database to back up; defaults to the schedule name
+	Storage           string // Storage to back up to; optional if only one storage is configured
 	FullBackup        string // Cron expression for full backups
 	IncrementalBackup string // Cron expression for incremental backups
 	DifferentialBackup string // Cron expression for differential backups
+	PhysicalBackup    string // Cron expression for physical backups (PostgreSQL point-in-time recovery)
 	RetentionDays     int    // Number of days to keep backups
 	MaxBackups        int    // Maximum number of backups to keep
+	Compression       *CompressionConfig // Overrides the storage compression settings
+	CatchUp           CatchUpPolicy // What to do with runs missed while the daemon was down; defaults to run
+	Jitter            time.Duration // Maximum random delay added to the start of each run
+	GFS               *GFSPolicy    // Optional grandfather-father-son retention
+}
+
+// GFSPolicy defines tiered grandfather-father-son retention. Each field is
+// the number of most recent hours, days, weeks, months or years for which
+// the newest backup is kept.
+type GFSPolicy struct {
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
 }
 
 // NotificationConfig contains notification settings
@@ -82,6 +136,38 @@ type Config struct {
 	Timeout       time.Duration
 }
 
+// ScheduleTarget returns the database and storage a schedule backs up to. The
+// database defaults to the schedule name, and the storage may be omitted when
+// only one storage is configured.
+func (c *Config) ScheduleTarget(name string) (string, string, error) {
+	schedule, ok := c.Schedules[name]
+	if !ok {
+		return "", "", fmt.Errorf("schedule %q not found in configuration", name)
+	}
+	
+	dbName := schedule.Database
+	if dbName == "" {
+		dbName = name
+	}
+	if _, ok := c.Databases[dbName]; !ok {
+		return "", "", fmt.Errorf("schedule %s: database %q not found in configuration", name, dbName)
+	}
+	
+	storeName := schedule.Storage
+	if storeName == "" {
+		if len(c.Storage) != 1 {
+			return "", "", fmt.Errorf("schedule %s: storage is required when more than one storage is configured", name)
+		}
+		for storeName = range c.Storage {
+		}
+	}
+	if _, ok := c.Storage[storeName]; !ok {
+		return "", "", fmt.Errorf("schedule %s: storage %q not found in configuration", name, storeName)
+	}
+	
+	return dbName, storeName, nil
+}
+
 // LoadConfig loads configuration from a file
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -1,8 +1,22 @@
 module github.com/yourusername/backyardBackup
 
-go 1.20
+go 1.22
 
 require (
+	github.com/go-sql-driver/mysql v1.7.1
 	github.com/google/uuid v1.3.0
+	github.com/jackc/pgx/v5 v5.4.3
+	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/pgzip v1.2.6
 	github.com/mattn/go-sqlite3 v1.14.17
-) 
\ No newline at end of file
+	github.com/pierrec/lz4/v4 v4.1.21
+	github.com/ulikunitz/xz v0.5.11
+	golang.org/x/crypto v0.9.0
+)
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	golang.org/x/sys v0.8.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+)
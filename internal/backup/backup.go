@@ -2,7 +2,15 @@ package backup
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/yourusername/backyardBackup/internal/compression"
+	"github.com/yourusername/backyardBackup/internal/encryption"
+	"github.com/yourusername/backyardBackup/internal/storage"
 )
 
 // BackupType represents the type of backup
@@ -15,31 +23,63 @@ const (
 	Incremental BackupType = "incremental"
 	// Differential backup type
 	Differential BackupType = "differential"
+	// Physical backup type, a copy of the data directory for point-in-time recovery
+	Physical BackupType = "physical"
 )
 
 // BackupResult contains information about a completed backup
 type BackupResult struct {
-	ID           string
-	Type         BackupType
-	StartTime    time.Time
-	EndTime      time.Time
-	Size         int64
-	FileCount    int
-	StoragePath  string
-	IsCompressed bool
-	Success      bool
-	ErrorMessage string
+	ID           string     `json:"id"`
+	Type         BackupType `json:"type"`
+	SourceDB     string     `json:"source_db"`
+	Storage      string     `json:"storage"`
+	BaseBackupID string     `json:"base_backup_id,omitempty"` // Parent backup for incremental and differential backups
+	Tables       []string   `json:"tables"`
+	StartTime    time.Time  `json:"start_time"`
+	EndTime      time.Time  `json:"end_time"`
+	Size         int64      `json:"size"`
+	Checksum     string     `json:"checksum,omitempty"`
+	FileCount    int        `json:"file_count"`
+	StoragePath  string     `json:"storage_path"`
+	IsCompressed bool       `json:"is_compressed"`
+	Success      bool       `json:"success"`
+	ErrorMessage string     `json:"error_message,omitempty"`
 }
 
 // BackupOptions contains configuration for a backup operation
 type BackupOptions struct {
 	Type         BackupType
 	Compress     bool
+	Compression  compression.CompressionType // Algorithm used when Compress is set; defaults to gzip
+	CompressionLevel *int                    // Algorithm-specific level; nil selects the default
+	LongDistance bool                        // Large (128 MiB) zstd window
+	CompressionWorkers int                   // Cores used for compression; values above 1 compress in parallel
 	SourceDB     string
 	DestStorage  string
 	ExcludeTables []string
 	IncludeTables []string
 	MaxSize      int64
+	EncryptionKey *encryption.Key // Encrypts the backup stream when set
+	Keys         *encryption.Keyring // Decrypts page maps of earlier encrypted backups
+}
+
+// compressor returns the compressor to apply to the backup stream
+func (o BackupOptions) compressor() *compression.Compressor {
+	if !o.Compress {
+		return compression.NewCompressor(compression.None)
+	}
+
+	compType := o.Compression
+	if compType == "" {
+		compType = compression.Gzip
+	}
+
+	return &compression.Compressor{
+		Type:         compType,
+		Level:        o.CompressionLevel,
+		LongDistance: o.LongDistance,
+		Workers:      o.CompressionWorkers,
+	}
 }
 
 // Backuper is the interface for database backup operations
@@ -55,4 +95,306 @@ type Backuper interface {
 	
 	// DeleteBackup removes a backup from storage
 	DeleteBackup(ctx context.Context, id string) error
-} 
\ No newline at end of file
+}
+
+// CatalogFilter narrows down a catalog listing. Empty fields match everything.
+type CatalogFilter struct {
+	SourceDB string
+	Storage  string
+	Type     BackupType
+}
+
+// Catalog is a persistent index of backups, used instead of listing storage
+type Catalog interface {
+	// Record adds or updates a backup in the catalog
+	Record(ctx context.Context, result *BackupResult) error
+	
+	// Get returns the backup with the given ID
+	Get(ctx context.Context, id string) (*BackupResult, error)
+	
+	// List returns all backups matching the filter, oldest first
+	List(ctx context.Context, filter CatalogFilter) ([]*BackupResult, error)
+	
+	// Delete removes a backup from the catalog
+	Delete(ctx context.Context, id string) error
+}
+
+// ScanStorage lists backups by reading the manifests stored next to them,
+// falling back to provider object metadata for backups without a manifest.
+// This is slow on cloud providers and is only used when no catalog is
+// available or when the catalog is being rebuilt.
+func ScanStorage(ctx context.Context, store storage.Provider) ([]*BackupResult, error) {
+	if store == nil {
+		return nil, fmt.Errorf("storage provider not initialized")
+	}
+
+	// List all files in storage
+	files, err := store.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	// Prefer manifests, as they are the same on every provider
+	found := make(map[string]bool)
+	var backups []*BackupResult
+	for _, file := range files {
+		if !isManifestFile(file.Path) {
+			continue
+		}
+
+		manifest, err := readManifestFile(ctx, store, file.Path)
+		if err != nil {
+			continue
+		}
+
+		found[manifest.Backup.StoragePath] = true
+		backups = append(backups, manifest.Backup)
+	}
+
+	for _, file := range files {
+		// Skip non-backup files and backups already described by a manifest
+		if !isBackupFile(file.Path) || found[file.Path] {
+			continue
+		}
+
+		// Get backup metadata
+		info, err := store.GetInfo(ctx, file.Path)
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, resultFromInfo(info))
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].StartTime.Before(backups[j].StartTime)
+	})
+
+	return backups, nil
+}
+
+// resultFromInfo reconstructs a backup result from stored object metadata.
+// The manifest is written once a backup is complete, so an object without
+// one may be a partial upload and is not treated as successful.
+func resultFromInfo(info *storage.FileInfo) *BackupResult {
+	startTime, _ := time.Parse(time.RFC3339, info.Metadata["start_time"])
+
+	var tables []string
+	if t := info.Metadata["tables"]; t != "" {
+		tables = strings.Split(t, ",")
+	}
+
+	return &BackupResult{
+		ID:           info.Metadata["backup_id"],
+		Type:         BackupType(info.Metadata["backup_type"]),
+		SourceDB:     info.Metadata["source_db"],
+		BaseBackupID: info.Metadata["base_backup"],
+		Tables:       tables,
+		StartTime:    startTime,
+		EndTime:      info.LastModified,
+		Size:         info.Size,
+		Checksum:     info.Metadata["checksum"],
+		StoragePath:  info.Path,
+		IsCompressed: info.Metadata["is_compressed"] == "true",
+		Success:      false,
+		ErrorMessage: "backup has no manifest and may be incomplete",
+	}
+}
+
+// listBackups lists backups from the catalog if one is configured, otherwise
+// by scanning storage
+func listBackups(ctx context.Context, store storage.Provider, catalog Catalog, filter CatalogFilter) ([]*BackupResult, error) {
+	if catalog != nil {
+		return catalog.List(ctx, filter)
+	}
+
+	backups, err := ScanStorage(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []*BackupResult
+	for _, b := range backups {
+		if filter.SourceDB != "" && b.SourceDB != filter.SourceDB {
+			continue
+		}
+		if filter.Type != "" && b.Type != filter.Type {
+			continue
+		}
+		filtered = append(filtered, b)
+	}
+
+	return filtered, nil
+}
+
+// getBackup looks up a single backup, using the catalog when available
+func getBackup(ctx context.Context, store storage.Provider, catalog Catalog, filter CatalogFilter, id string) (*BackupResult, error) {
+	if catalog != nil {
+		backup, err := catalog.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Storage != "" && backup.Storage != filter.Storage {
+			return nil, fmt.Errorf("backup %s is stored in %q, not %q", id, backup.Storage, filter.Storage)
+		}
+		return backup, nil
+	}
+
+	backups, err := listBackups(ctx, store, nil, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	for _, backup := range backups {
+		if backup.ID == id {
+			return backup, nil
+		}
+	}
+
+	return nil, fmt.Errorf("backup %s not found", id)
+}
+
+// deleteBackup removes a backup from storage and from the catalog
+func deleteBackup(ctx context.Context, store storage.Provider, catalog Catalog, filter CatalogFilter, id string) error {
+	backup, err := getBackup(ctx, store, catalog, filter, id)
+	if err != nil {
+		return fmt.Errorf("failed to get backup: %w", err)
+	}
+
+	return Remove(ctx, store, catalog, backup)
+}
+
+// Remove deletes a backup and its manifest from storage and removes it from
+// the catalog, if one is given. The backup stays in the catalog until all of
+// its files are deleted, so a failed removal can be retried.
+func Remove(ctx context.Context, store storage.Provider, catalog Catalog, backup *BackupResult) error {
+	var errs []error
+	if err := deleteIfExists(ctx, store, backup.StoragePath); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete backup: %w", err))
+	}
+	// Backups taken before manifests were introduced have none, and only
+	// page-level SQLite backups have a page map
+	if err := deleteIfExists(ctx, store, ManifestPath(backup.StoragePath)); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete manifest: %w", err))
+	}
+	if err := deleteIfExists(ctx, store, PageMapPath(backup.StoragePath)); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete page map: %w", err))
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if catalog != nil {
+		if err := catalog.Delete(ctx, backup.ID); err != nil {
+			return fmt.Errorf("failed to remove backup from catalog: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteIfExists deletes a file from storage unless it is already gone
+func deleteIfExists(ctx context.Context, store storage.Provider, path string) error {
+	if _, err := store.GetInfo(ctx, path); err != nil {
+		return nil
+	}
+	return store.Delete(ctx, path)
+}
+
+// findLatestFullBackup finds the most recent full backup of a database
+func findLatestFullBackup(ctx context.Context, store storage.Provider, catalog Catalog, filter CatalogFilter) (*BackupResult, error) {
+	filter.Type = Full
+	backups, err := listBackups(ctx, store, catalog, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var latest *BackupResult
+	for _, backup := range backups {
+		if backup.Type == Full {
+			if latest == nil || backup.StartTime.After(latest.StartTime) {
+				latest = backup
+			}
+		}
+	}
+
+	if latest == nil {
+		return nil, fmt.Errorf("no full backup found for database %s", filter.SourceDB)
+	}
+
+	return latest, nil
+}
+
+// findLatestChainBackup finds the most recent successful full or incremental
+// backup taken since the given full backup, the parent of the next incremental
+func findLatestChainBackup(ctx context.Context, store storage.Provider, catalog Catalog, filter CatalogFilter, base *BackupResult) (*BackupResult, error) {
+	filter.Type = ""
+	backups, err := listBackups(ctx, store, catalog, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	latest := base
+	for _, backup := range backups {
+		if backup.Type != Full && backup.Type != Incremental {
+			continue
+		}
+		if !backup.Success || backup.StartTime.Before(base.StartTime) {
+			continue
+		}
+		if backup.StartTime.After(latest.StartTime) {
+			latest = backup
+		}
+	}
+
+	return latest, nil
+}
+
+// Helper functions
+
+// isBackupFile reports whether a storage path is a backup data object: a
+// dump, or the archive of a physical backup
+func isBackupFile(path string) bool {
+	trimmed := compression.TrimExtension(path)
+	return strings.HasSuffix(trimmed, ".db") || strings.HasSuffix(trimmed, ".tar")
+}
+
+func filterTables(tables []string, include, exclude []string) []string {
+	if len(include) == 0 && len(exclude) == 0 {
+		return tables
+	}
+
+	// If include is specified, only keep those tables
+	if len(include) > 0 {
+		included := make(map[string]bool)
+		for _, t := range include {
+			included[t] = true
+		}
+
+		var filtered []string
+		for _, t := range tables {
+			if included[t] {
+				filtered = append(filtered, t)
+			}
+		}
+		tables = filtered
+	}
+
+	// If exclude is specified, remove those tables
+	if len(exclude) > 0 {
+		excluded := make(map[string]bool)
+		for _, t := range exclude {
+			excluded[t] = true
+		}
+
+		var filtered []string
+		for _, t := range tables {
+			if !excluded[t] {
+				filtered = append(filtered, t)
+			}
+		}
+		tables = filtered
+	}
+
+	return tables
+}
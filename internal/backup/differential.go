@@ -2,31 +2,45 @@ package backup
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/yourusername/backyardBackup/internal/compression"
 	"github.com/yourusername/backyardBackup/internal/database"
+	"github.com/yourusername/backyardBackup/internal/logging"
 	"github.com/yourusername/backyardBackup/internal/storage"
 )
 
 // DifferentialBackup implements differential database backup
 type DifferentialBackup struct {
-	DB      database.Connector
-	Storage storage.Provider
+	DB          database.Connector
+	Storage     storage.Provider
+	Catalog     Catalog         // Optional; backups are listed from storage when nil
+	StorageName string          // Name of the storage in the configuration
+	Logger      *logging.Logger // Optional; receives warnings that do not fail the backup
 }
 
 // DifferentialMetadata contains metadata about a differential backup
 type DifferentialMetadata struct {
-	BaseBackupID string            `json:"base_backup_id"`
-	TableStates  map[string]string `json:"table_states"` // table -> state hash
-	Timestamp    time.Time         `json:"timestamp"`
+	BaseBackupID  string            `json:"base_backup_id"`
+	TableStates   map[string]string `json:"table_states"` // table -> state hash
+	Timestamp     time.Time         `json:"timestamp"`
+	Format        string            `json:"format,omitempty"`         // "pages" or "tables"; empty for complete dumps
+	ChangedTables []string          `json:"changed_tables,omitempty"` // Tables that differ from the base backup
+	DroppedTables []string          `json:"dropped_tables,omitempty"` // Tables of the base backup that no longer exist
+	PageSize      int               `json:"page_size,omitempty"`
+	PageCount     int               `json:"page_count,omitempty"`
+	ChangedPages  int64             `json:"changed_pages,omitempty"`
 }
 
+// FormatTables marks differential backups that contain only changed tables
+const FormatTables = "tables"
+
 // NewDifferentialBackup creates a new differential backup instance
 func NewDifferentialBackup(db database.Connector, storage storage.Provider) *DifferentialBackup {
 	return &DifferentialBackup{
@@ -35,30 +49,6 @@ func NewDifferentialBackup(db database.Connector, storage storage.Provider) *Dif
 	}
 }
 
-// findLatestFullBackup finds the most recent full backup
-func (b *DifferentialBackup) findLatestFullBackup(ctx context.Context, dbName string) (*BackupResult, error) {
-	// List all backups
-	backups, err := b.ListBackups(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list backups: %w", err)
-	}
-
-	var latest *BackupResult
-	for _, backup := range backups {
-		if backup.Type == Full {
-			if latest == nil || backup.StartTime.After(latest.StartTime) {
-				latest = backup
-			}
-		}
-	}
-
-	if latest == nil {
-		return nil, fmt.Errorf("no full backup found for database %s", dbName)
-	}
-
-	return latest, nil
-}
-
 // Backup performs a differential database backup
 func (b *DifferentialBackup) Backup(ctx context.Context, opts BackupOptions) (*BackupResult, error) {
 	if b.DB == nil {
@@ -69,7 +59,10 @@ func (b *DifferentialBackup) Backup(ctx context.Context, opts BackupOptions) (*B
 	}
 
 	// Find latest full backup
-	baseBackup, err := b.findLatestFullBackup(ctx, opts.SourceDB)
+	baseBackup, err := findLatestFullBackup(ctx, b.Storage, b.Catalog, CatalogFilter{
+		SourceDB: opts.SourceDB,
+		Storage:  opts.DestStorage,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to find base backup: %w", err)
 	}
@@ -87,12 +80,76 @@ func (b *DifferentialBackup) Backup(ctx context.Context, opts BackupOptions) (*B
 	// Filter tables based on options
 	tables = filterTables(tables, opts.IncludeTables, opts.ExcludeTables)
 
+	// Get database info
+	dbInfo, err := b.DB.GetInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database info: %w", err)
+	}
+
+	// Compare table states with the base backup
+	tableStates, err := tableStates(ctx, b.DB, tables)
+	if err != nil {
+		// Without table states the backup is a complete dump
+		if b.Logger != nil {
+			b.Logger.Warning("Backup %s is stored without table states: %v", backupID, err)
+		}
+		tableStates = nil
+	}
+	var baseStates map[string]string
+	if baseManifest, err := ReadManifest(ctx, b.Storage, baseBackup.StoragePath); err == nil && baseManifest.Full != nil {
+		baseStates = baseManifest.Full.TableStates
+	}
+
 	// Create metadata
 	metadata := DifferentialMetadata{
 		BaseBackupID: baseBackup.ID,
-		TableStates:  make(map[string]string),
+		TableStates:  tableStates,
 		Timestamp:    startTime,
 	}
+	if tableStates != nil && baseStates != nil {
+		allTables, err := b.DB.ListTables(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tables: %w", err)
+		}
+		metadata.ChangedTables, metadata.DroppedTables = diffTableStates(baseStates, tableStates, allTables)
+	}
+
+	// Store only what changed since the base backup: pages for databases
+	// backed up page by page, tables for databases with table states, and a
+	// complete dump otherwise
+	dump := dumpDatabase(ctx, b.DB, tables)
+	if pages, ok := pageConnector(b.DB); ok {
+		baseMap, err := loadPageMap(ctx, b.Storage, baseBackup, opts.Keys)
+		if err != nil {
+			return nil, err
+		}
+		if baseMap == nil {
+			return nil, fmt.Errorf("backup %s has no page map; take a full backup first", baseBackup.ID)
+		}
+
+		metadata.Format = FormatPages
+		dump = func(w io.Writer) error {
+			return pages.ReadPages(ctx, func(r io.Reader, pageSize int) error {
+				pageMap, changed, err := writePageDiff(w, r, pageSize, baseMap)
+				if err != nil {
+					return err
+				}
+				metadata.PageSize = pageMap.PageSize
+				metadata.PageCount = len(pageMap.Hashes)
+				metadata.ChangedPages = changed
+				return nil
+			})
+		}
+	} else if tableStates != nil && baseStates != nil {
+		metadata.Format = FormatTables
+		changed := metadata.ChangedTables
+		dump = func(w io.Writer) error {
+			if len(changed) == 0 {
+				return nil
+			}
+			return b.DB.Backup(ctx, w, changed)
+		}
+	}
 
 	// Create backup path
 	backupPath := filepath.Join(
@@ -102,185 +159,107 @@ func (b *DifferentialBackup) Backup(ctx context.Context, opts BackupOptions) (*B
 		fmt.Sprintf("%s-%s.db", startTime.Format("20060102-150405"), backupID),
 	)
 
-	if opts.Compress {
-		backupPath += ".gz"
-	}
-
-	// Create a pipe for streaming backup data
-	pr, pw := io.Pipe()
-
-	// Start backup in a goroutine
-	errCh := make(chan error, 1)
-	go func() {
-		defer pw.Close()
-		err := b.DB.Backup(ctx, pw, tables)
-		if err != nil {
-			errCh <- fmt.Errorf("failed to create backup: %w", err)
-			return
-		}
-		errCh <- nil
-	}()
+	backupPath += compression.Extension(opts.compressor().Type)
 
 	// Store backup data
-	metadataStr, err := json.Marshal(metadata)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
-	}
-
-	err = b.Storage.Store(ctx, backupPath, pr, map[string]string{
+	size, checksum, err := streamBackup(ctx, b.Storage, backupPath, map[string]string{
 		"backup_type":   string(Differential),
 		"base_backup":   baseBackup.ID,
 		"backup_id":     backupID,
 		"source_db":     opts.SourceDB,
 		"start_time":    startTime.Format(time.RFC3339),
-		"metadata":      string(metadataStr),
+		"tables":        strings.Join(tables, ","),
 		"is_compressed": fmt.Sprintf("%v", opts.Compress),
-	})
+	}, opts, dump)
 	if err != nil {
-		return nil, fmt.Errorf("failed to store backup: %w", err)
-	}
-
-	// Wait for backup to complete
-	if err := <-errCh; err != nil {
 		return nil, err
 	}
 
-	// Get backup size
-	info, err := b.Storage.GetInfo(ctx, backupPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get backup info: %w", err)
-	}
-
 	result := &BackupResult{
 		ID:           backupID,
 		Type:         Differential,
+		SourceDB:     opts.SourceDB,
+		Storage:      opts.DestStorage,
+		BaseBackupID: baseBackup.ID,
+		Tables:       tables,
 		StartTime:    startTime,
 		EndTime:      time.Now(),
-		Size:         info.Size,
+		Size:         size,
+		Checksum:     checksum,
 		StoragePath:  backupPath,
 		IsCompressed: opts.Compress,
 		Success:      true,
 	}
 
+	// Write manifest next to the backup
+	manifest := newManifest(result, opts, b.DB.Type(), dbInfo)
+	manifest.Differential = &metadata
+	if err := WriteManifest(ctx, b.Storage, manifest); err != nil {
+		return nil, err
+	}
+
+	// Record backup in the catalog
+	if b.Catalog != nil {
+		if err := b.Catalog.Record(ctx, result); err != nil {
+			return nil, fmt.Errorf("failed to record backup in catalog: %w", err)
+		}
+	}
+
 	return result, nil
 }
 
-// ListBackups returns a list of all available backups
-func (b *DifferentialBackup) ListBackups(ctx context.Context) ([]*BackupResult, error) {
-	if b.Storage == nil {
-		return nil, fmt.Errorf("storage provider not initialized")
+// tableStates returns the state hash of each table, or nil if the database
+// cannot fingerprint its tables
+func tableStates(ctx context.Context, db database.Connector, tables []string) (map[string]string, error) {
+	hasher, ok := db.(database.TableStateConnector)
+	if !ok {
+		return nil, nil
 	}
 
-	// List all files in storage
-	files, err := b.Storage.List(ctx, "")
+	states, err := hasher.TableStates(ctx, tables)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list backups: %w", err)
+		return nil, fmt.Errorf("failed to get table states: %w", err)
 	}
+	return states, nil
+}
 
-	var backups []*BackupResult
-	for _, file := range files {
-		// Skip non-backup files
-		if !isBackupFile(file.Path) {
-			continue
-		}
-
-		// Get backup metadata
-		info, err := b.Storage.GetInfo(ctx, file.Path)
-		if err != nil {
-			continue
+// diffTableStates returns the tables whose state differs from the base
+// backup, including new tables, and the base tables that no longer exist
+func diffTableStates(base, current map[string]string, allTables []string) ([]string, []string) {
+	var changed []string
+	for table, state := range current {
+		if base[table] != state {
+			changed = append(changed, table)
 		}
+	}
 
-		startTime, _ := time.Parse(time.RFC3339, info.Metadata["start_time"])
-		isCompressed := info.Metadata["is_compressed"] == "true"
-
-		backup := &BackupResult{
-			ID:           info.Metadata["backup_id"],
-			Type:         BackupType(info.Metadata["backup_type"]),
-			StartTime:    startTime,
-			EndTime:      info.LastModified,
-			Size:         info.Size,
-			StoragePath:  file.Path,
-			IsCompressed: isCompressed,
-			Success:      true,
+	exists := make(map[string]bool, len(allTables))
+	for _, table := range allTables {
+		exists[table] = true
+	}
+	var dropped []string
+	for table := range base {
+		if !exists[table] {
+			dropped = append(dropped, table)
 		}
-		backups = append(backups, backup)
 	}
 
-	return backups, nil
+	sort.Strings(changed)
+	sort.Strings(dropped)
+	return changed, dropped
+}
+
+// ListBackups returns a list of all available backups
+func (b *DifferentialBackup) ListBackups(ctx context.Context) ([]*BackupResult, error) {
+	return listBackups(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName})
 }
 
 // GetBackup retrieves details about a specific backup
 func (b *DifferentialBackup) GetBackup(ctx context.Context, id string) (*BackupResult, error) {
-	backups, err := b.ListBackups(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list backups: %w", err)
-	}
-
-	for _, backup := range backups {
-		if backup.ID == id {
-			return backup, nil
-		}
-	}
-
-	return nil, fmt.Errorf("backup %s not found", id)
+	return getBackup(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName}, id)
 }
 
 // DeleteBackup removes a backup from storage
 func (b *DifferentialBackup) DeleteBackup(ctx context.Context, id string) error {
-	backup, err := b.GetBackup(ctx, id)
-	if err != nil {
-		return fmt.Errorf("failed to get backup: %w", err)
-	}
-
-	err = b.Storage.Delete(ctx, backup.StoragePath)
-	if err != nil {
-		return fmt.Errorf("failed to delete backup: %w", err)
-	}
-
-	return nil
-}
-
-// Helper functions
-func isBackupFile(path string) bool {
-	return strings.HasSuffix(path, ".db") || strings.HasSuffix(path, ".db.gz")
+	return deleteBackup(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName}, id)
 }
-
-func filterTables(tables []string, include, exclude []string) []string {
-	if len(include) == 0 && len(exclude) == 0 {
-		return tables
-	}
-
-	// If include is specified, only keep those tables
-	if len(include) > 0 {
-		included := make(map[string]bool)
-		for _, t := range include {
-			included[t] = true
-		}
-
-		var filtered []string
-		for _, t := range tables {
-			if included[t] {
-				filtered = append(filtered, t)
-			}
-		}
-		tables = filtered
-	}
-
-	// If exclude is specified, remove those tables
-	if len(exclude) > 0 {
-		excluded := make(map[string]bool)
-		for _, t := range exclude {
-			excluded[t] = true
-		}
-
-		var filtered []string
-		for _, t := range tables {
-			if !excluded[t] {
-				filtered = append(filtered, t)
-			}
-		}
-		tables = filtered
-	}
-
-	return tables
-} 
\ No newline at end of file
@@ -2,7 +2,6 @@ package backup
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"path/filepath"
@@ -10,21 +9,28 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/yourusername/backyardBackup/internal/compression"
 	"github.com/yourusername/backyardBackup/internal/database"
+	"github.com/yourusername/backyardBackup/internal/logging"
 	"github.com/yourusername/backyardBackup/internal/storage"
 )
 
 // FullBackup implements full database backup
 type FullBackup struct {
-	DB      database.Connector
-	Storage storage.Provider
+	DB          database.Connector
+	Storage     storage.Provider
+	Catalog     Catalog         // Optional; backups are listed from storage when nil
+	StorageName string          // Name of the storage in the configuration
+	Logger      *logging.Logger // Optional; receives warnings that do not fail the backup
 }
 
 // FullMetadata contains metadata about a full backup
 type FullMetadata struct {
-	Tables    []string          `json:"tables"`
-	DBInfo    map[string]string `json:"db_info"`
-	Timestamp time.Time         `json:"timestamp"`
+	Tables      []string              `json:"tables"`
+	DBInfo      map[string]string     `json:"db_info"`
+	Timestamp   time.Time             `json:"timestamp"`
+	TableStates map[string]string     `json:"table_states,omitempty"` // table -> state hash, compared by differential backups
+	LogStart    *database.LogPosition `json:"log_start,omitempty"`    // Log position the dump is consistent with
 }
 
 // NewFullBackup creates a new full backup instance
@@ -63,11 +69,23 @@ func (b *FullBackup) Backup(ctx context.Context, opts BackupOptions) (*BackupRes
 		return nil, fmt.Errorf("failed to get database info: %w", err)
 	}
 
+	// Record table states before dumping, so changes made during the dump
+	// are picked up by the next differential backup
+	tableStates, err := tableStates(ctx, b.DB, tables)
+	if err != nil {
+		// Differential backups of this backup then store complete dumps
+		if b.Logger != nil {
+			b.Logger.Warning("Backup %s is stored without table states: %v", backupID, err)
+		}
+		tableStates = nil
+	}
+
 	// Create metadata
 	metadata := FullMetadata{
-		Tables:    tables,
-		DBInfo:    dbInfo,
-		Timestamp: startTime,
+		Tables:      tables,
+		DBInfo:      dbInfo,
+		Timestamp:   startTime,
+		TableStates: tableStates,
 	}
 
 	// Create backup path
@@ -78,184 +96,96 @@ func (b *FullBackup) Backup(ctx context.Context, opts BackupOptions) (*BackupRes
 		fmt.Sprintf("%s-%s.db", startTime.Format("20060102-150405"), backupID),
 	)
 
-	if opts.Compress {
-		backupPath += ".gz"
+	backupPath += compression.Extension(opts.compressor().Type)
+
+	// Databases backed up page by page also get a page map, the starting
+	// point of page-level incremental backups. Filtered backups are not
+	// copies of the database file, so they cannot start a page chain.
+	dump := dumpDatabase(ctx, b.DB, tables)
+	if positioned, ok := b.DB.(database.LogPositionDumper); ok {
+		dump = func(w io.Writer) error {
+			var err error
+			metadata.LogStart, err = positioned.DumpWithPosition(ctx, w, tables)
+			return err
+		}
 	}
-
-	// Create a pipe for streaming backup data
-	pr, pw := io.Pipe()
-
-	// Start backup in a goroutine
-	errCh := make(chan error, 1)
-	go func() {
-		defer pw.Close()
-		err := b.DB.Backup(ctx, pw, tables)
-		if err != nil {
-			errCh <- fmt.Errorf("failed to create backup: %w", err)
-			return
+	var hasher *pageHasher
+	if pages, ok := pageConnector(b.DB); ok && len(opts.IncludeTables) == 0 && len(opts.ExcludeTables) == 0 {
+		dump = func(w io.Writer) error {
+			return pages.ReadPages(ctx, func(r io.Reader, pageSize int) error {
+				hasher = newPageHasher(pageSize)
+				_, err := io.Copy(io.MultiWriter(w, hasher), r)
+				return err
+			})
 		}
-		errCh <- nil
-	}()
-
-	// Store backup data
-	metadataStr, err := json.Marshal(metadata)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	err = b.Storage.Store(ctx, backupPath, pr, map[string]string{
+	// Store backup data
+	size, checksum, err := streamBackup(ctx, b.Storage, backupPath, map[string]string{
 		"backup_type":   string(Full),
 		"backup_id":     backupID,
 		"source_db":     opts.SourceDB,
 		"start_time":    startTime.Format(time.RFC3339),
-		"metadata":      string(metadataStr),
+		"tables":        strings.Join(tables, ","),
 		"is_compressed": fmt.Sprintf("%v", opts.Compress),
-	})
+	}, opts, dump)
 	if err != nil {
-		return nil, fmt.Errorf("failed to store backup: %w", err)
-	}
-
-	// Wait for backup to complete
-	if err := <-errCh; err != nil {
 		return nil, err
 	}
 
-	// Get backup size
-	info, err := b.Storage.GetInfo(ctx, backupPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get backup info: %w", err)
+	if hasher != nil {
+		pageMap, err := hasher.PageMap()
+		if err != nil {
+			return nil, err
+		}
+		if err := storePageMap(ctx, b.Storage, backupPath, pageMap, opts); err != nil {
+			return nil, err
+		}
 	}
 
 	result := &BackupResult{
 		ID:           backupID,
 		Type:         Full,
+		SourceDB:     opts.SourceDB,
+		Storage:      opts.DestStorage,
+		Tables:       tables,
 		StartTime:    startTime,
 		EndTime:      time.Now(),
-		Size:         info.Size,
+		Size:         size,
+		Checksum:     checksum,
 		StoragePath:  backupPath,
 		IsCompressed: opts.Compress,
 		Success:      true,
 	}
 
-	return result, nil
-}
-
-// ListBackups returns a list of all available backups
-func (b *FullBackup) ListBackups(ctx context.Context) ([]*BackupResult, error) {
-	if b.Storage == nil {
-		return nil, fmt.Errorf("storage provider not initialized")
-	}
-
-	// List all files in storage
-	files, err := b.Storage.List(ctx, "")
-	if err != nil {
-		return nil, fmt.Errorf("failed to list backups: %w", err)
+	// Write manifest next to the backup
+	manifest := newManifest(result, opts, b.DB.Type(), dbInfo)
+	manifest.Full = &metadata
+	if err := WriteManifest(ctx, b.Storage, manifest); err != nil {
+		return nil, err
 	}
 
-	var backups []*BackupResult
-	for _, file := range files {
-		// Skip non-backup files
-		if !isBackupFile(file.Path) {
-			continue
-		}
-
-		// Get backup metadata
-		info, err := b.Storage.GetInfo(ctx, file.Path)
-		if err != nil {
-			continue
+	// Record backup in the catalog
+	if b.Catalog != nil {
+		if err := b.Catalog.Record(ctx, result); err != nil {
+			return nil, fmt.Errorf("failed to record backup in catalog: %w", err)
 		}
-
-		startTime, _ := time.Parse(time.RFC3339, info.Metadata["start_time"])
-		isCompressed := info.Metadata["is_compressed"] == "true"
-
-		backup := &BackupResult{
-			ID:           info.Metadata["backup_id"],
-			Type:         BackupType(info.Metadata["backup_type"]),
-			StartTime:    startTime,
-			EndTime:      info.LastModified,
-			Size:         info.Size,
-			StoragePath:  file.Path,
-			IsCompressed: isCompressed,
-			Success:      true,
-		}
-		backups = append(backups, backup)
 	}
 
-	return backups, nil
+	return result, nil
+}
+
+// ListBackups returns a list of all available backups
+func (b *FullBackup) ListBackups(ctx context.Context) ([]*BackupResult, error) {
+	return listBackups(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName})
 }
 
 // GetBackup retrieves details about a specific backup
 func (b *FullBackup) GetBackup(ctx context.Context, id string) (*BackupResult, error) {
-	backups, err := b.ListBackups(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list backups: %w", err)
-	}
-
-	for _, backup := range backups {
-		if backup.ID == id {
-			return backup, nil
-		}
-	}
-
-	return nil, fmt.Errorf("backup %s not found", id)
+	return getBackup(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName}, id)
 }
 
 // DeleteBackup removes a backup from storage
 func (b *FullBackup) DeleteBackup(ctx context.Context, id string) error {
-	backup, err := b.GetBackup(ctx, id)
-	if err != nil {
-		return fmt.Errorf("failed to get backup: %w", err)
-	}
-
-	err = b.Storage.Delete(ctx, backup.StoragePath)
-	if err != nil {
-		return fmt.Errorf("failed to delete backup: %w", err)
-	}
-
-	return nil
-}
-
-// Helper functions
-func isBackupFile(path string) bool {
-	return strings.HasSuffix(path, ".db") || strings.HasSuffix(path, ".db.gz")
+	return deleteBackup(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName}, id)
 }
-
-func filterTables(tables []string, include, exclude []string) []string {
-	if len(include) == 0 && len(exclude) == 0 {
-		return tables
-	}
-
-	// If include is specified, only keep those tables
-	if len(include) > 0 {
-		included := make(map[string]bool)
-		for _, t := range include {
-			included[t] = true
-		}
-
-		var filtered []string
-		for _, t := range tables {
-			if included[t] {
-				filtered = append(filtered, t)
-			}
-		}
-		tables = filtered
-	}
-
-	// If exclude is specified, remove those tables
-	if len(exclude) > 0 {
-		excluded := make(map[string]bool)
-		for _, t := range exclude {
-			excluded[t] = true
-		}
-
-		var filtered []string
-		for _, t := range tables {
-			if !excluded[t] {
-				filtered = append(filtered, t)
-			}
-		}
-		tables = filtered
-	}
-
-	return tables
-} 
\ No newline at end of file
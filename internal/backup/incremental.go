@@ -2,7 +2,6 @@ package backup
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"path/filepath"
@@ -10,21 +9,31 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/yourusername/backyardBackup/internal/compression"
 	"github.com/yourusername/backyardBackup/internal/database"
 	"github.com/yourusername/backyardBackup/internal/storage"
 )
 
 // IncrementalBackup implements incremental database backup
 type IncrementalBackup struct {
-	DB      database.Connector
-	Storage storage.Provider
+	DB          database.Connector
+	Storage     storage.Provider
+	Catalog     Catalog // Optional; backups are listed from storage when nil
+	StorageName string  // Name of the storage in the configuration
 }
 
 // IncrementalMetadata contains metadata about an incremental backup
 type IncrementalMetadata struct {
-	BaseBackupID string            `json:"base_backup_id"`
-	Changes      map[string]string `json:"changes"` // table -> checksum
-	Timestamp    time.Time         `json:"timestamp"`
+	BaseBackupID   string                `json:"base_backup_id"`
+	Changes        map[string]string     `json:"changes"` // table -> checksum
+	Timestamp      time.Time             `json:"timestamp"`
+	Format         string                `json:"format,omitempty"`           // "pages" for page-level SQLite backups, "log" for log-based backups
+	ParentBackupID string                `json:"parent_backup_id,omitempty"` // Backup the changes are relative to
+	PageSize       int                   `json:"page_size,omitempty"`
+	PageCount      int                   `json:"page_count,omitempty"`
+	ChangedPages   int64                 `json:"changed_pages,omitempty"`
+	LogStart       *database.LogPosition `json:"log_start,omitempty"` // First log position in a log-based backup
+	LogEnd         *database.LogPosition `json:"log_end,omitempty"`   // Position the backup is consistent with
 }
 
 // NewIncrementalBackup creates a new incremental backup instance
@@ -35,30 +44,6 @@ func NewIncrementalBackup(db database.Connector, storage storage.Provider) *Incr
 	}
 }
 
-// findLatestFullBackup finds the most recent full backup
-func (b *IncrementalBackup) findLatestFullBackup(ctx context.Context, dbName string) (*BackupResult, error) {
-	// List all backups
-	backups, err := b.ListBackups(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list backups: %w", err)
-	}
-
-	var latest *BackupResult
-	for _, backup := range backups {
-		if backup.Type == Full {
-			if latest == nil || backup.StartTime.After(latest.StartTime) {
-				latest = backup
-			}
-		}
-	}
-
-	if latest == nil {
-		return nil, fmt.Errorf("no full backup found for database %s", dbName)
-	}
-
-	return latest, nil
-}
-
 // Backup performs an incremental database backup
 func (b *IncrementalBackup) Backup(ctx context.Context, opts BackupOptions) (*BackupResult, error) {
 	if b.DB == nil {
@@ -69,11 +54,49 @@ func (b *IncrementalBackup) Backup(ctx context.Context, opts BackupOptions) (*Ba
 	}
 
 	// Find latest full backup
-	baseBackup, err := b.findLatestFullBackup(ctx, opts.SourceDB)
+	baseBackup, err := findLatestFullBackup(ctx, b.Storage, b.Catalog, CatalogFilter{
+		SourceDB: opts.SourceDB,
+		Storage:  opts.DestStorage,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to find base backup: %w", err)
 	}
 
+	// Page-level and log-based backups only store the changes since the
+	// previous backup of the chain
+	parentBackup := baseBackup
+	pages, pageLevel := pageConnector(b.DB)
+	logs, logBased := b.DB.(database.LogDumper)
+	if pageLevel || logBased {
+		parentBackup, err = findLatestChainBackup(ctx, b.Storage, b.Catalog, CatalogFilter{
+			SourceDB: opts.SourceDB,
+			Storage:  opts.DestStorage,
+		}, baseBackup)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find parent backup: %w", err)
+		}
+	}
+	var parentMap *PageMap
+	if pageLevel {
+		parentMap, err = loadPageMap(ctx, b.Storage, parentBackup, opts.Keys)
+		if err != nil {
+			return nil, err
+		}
+		if parentMap == nil {
+			return nil, fmt.Errorf("backup %s has no page map; take a full backup first", parentBackup.ID)
+		}
+	}
+	var logStart *database.LogPosition
+	if logBased && !pageLevel {
+		manifest, err := ReadManifest(ctx, b.Storage, parentBackup.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		if logStart = manifest.LogEnd(); logStart == nil {
+			return nil, fmt.Errorf("backup %s has no log position; take a full backup first", parentBackup.ID)
+		}
+	}
+
 	// Start backup
 	startTime := time.Now()
 	backupID := uuid.New().String()
@@ -87,6 +110,12 @@ func (b *IncrementalBackup) Backup(ctx context.Context, opts BackupOptions) (*Ba
 	// Filter tables based on options
 	tables = filterTables(tables, opts.IncludeTables, opts.ExcludeTables)
 
+	// Get database info
+	dbInfo, err := b.DB.GetInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database info: %w", err)
+	}
+
 	// Create metadata
 	metadata := IncrementalMetadata{
 		BaseBackupID: baseBackup.ID,
@@ -102,185 +131,97 @@ func (b *IncrementalBackup) Backup(ctx context.Context, opts BackupOptions) (*Ba
 		fmt.Sprintf("%s-%s.db", startTime.Format("20060102-150405"), backupID),
 	)
 
-	if opts.Compress {
-		backupPath += ".gz"
-	}
-
-	// Create a pipe for streaming backup data
-	pr, pw := io.Pipe()
-
-	// Start backup in a goroutine
-	errCh := make(chan error, 1)
-	go func() {
-		defer pw.Close()
-		err := b.DB.Backup(ctx, pw, tables)
-		if err != nil {
-			errCh <- fmt.Errorf("failed to create backup: %w", err)
-			return
+	backupPath += compression.Extension(opts.compressor().Type)
+
+	dump := dumpDatabase(ctx, b.DB, tables)
+	var pageMap *PageMap
+	if pageLevel {
+		metadata.Format = FormatPages
+		metadata.ParentBackupID = parentBackup.ID
+		dump = func(w io.Writer) error {
+			return pages.ReadPages(ctx, func(r io.Reader, pageSize int) error {
+				var err error
+				pageMap, metadata.ChangedPages, err = writePageDiff(w, r, pageSize, parentMap)
+				return err
+			})
+		}
+	} else if logBased {
+		metadata.Format = FormatLog
+		metadata.ParentBackupID = parentBackup.ID
+		metadata.LogStart = logStart
+		dump = func(w io.Writer) error {
+			var err error
+			metadata.LogEnd, err = logs.DumpLogs(ctx, w, logStart)
+			return err
 		}
-		errCh <- nil
-	}()
-
-	// Store backup data
-	metadataStr, err := json.Marshal(metadata)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	err = b.Storage.Store(ctx, backupPath, pr, map[string]string{
+	// Store backup data
+	size, checksum, err := streamBackup(ctx, b.Storage, backupPath, map[string]string{
 		"backup_type":   string(Incremental),
-		"base_backup":   baseBackup.ID,
+		"base_backup":   parentBackup.ID,
 		"backup_id":     backupID,
 		"source_db":     opts.SourceDB,
 		"start_time":    startTime.Format(time.RFC3339),
-		"metadata":      string(metadataStr),
+		"tables":        strings.Join(tables, ","),
 		"is_compressed": fmt.Sprintf("%v", opts.Compress),
-	})
+	}, opts, dump)
 	if err != nil {
-		return nil, fmt.Errorf("failed to store backup: %w", err)
-	}
-
-	// Wait for backup to complete
-	if err := <-errCh; err != nil {
 		return nil, err
 	}
 
-	// Get backup size
-	info, err := b.Storage.GetInfo(ctx, backupPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get backup info: %w", err)
+	if pageMap != nil {
+		metadata.PageSize = pageMap.PageSize
+		metadata.PageCount = len(pageMap.Hashes)
+		if err := storePageMap(ctx, b.Storage, backupPath, pageMap, opts); err != nil {
+			return nil, err
+		}
 	}
 
 	result := &BackupResult{
 		ID:           backupID,
 		Type:         Incremental,
+		SourceDB:     opts.SourceDB,
+		Storage:      opts.DestStorage,
+		BaseBackupID: parentBackup.ID,
+		Tables:       tables,
 		StartTime:    startTime,
 		EndTime:      time.Now(),
-		Size:         info.Size,
+		Size:         size,
+		Checksum:     checksum,
 		StoragePath:  backupPath,
 		IsCompressed: opts.Compress,
 		Success:      true,
 	}
 
-	return result, nil
-}
-
-// ListBackups returns a list of all available backups
-func (b *IncrementalBackup) ListBackups(ctx context.Context) ([]*BackupResult, error) {
-	if b.Storage == nil {
-		return nil, fmt.Errorf("storage provider not initialized")
-	}
-
-	// List all files in storage
-	files, err := b.Storage.List(ctx, "")
-	if err != nil {
-		return nil, fmt.Errorf("failed to list backups: %w", err)
+	// Write manifest next to the backup
+	manifest := newManifest(result, opts, b.DB.Type(), dbInfo)
+	manifest.Incremental = &metadata
+	if err := WriteManifest(ctx, b.Storage, manifest); err != nil {
+		return nil, err
 	}
 
-	var backups []*BackupResult
-	for _, file := range files {
-		// Skip non-backup files
-		if !isBackupFile(file.Path) {
-			continue
-		}
-
-		// Get backup metadata
-		info, err := b.Storage.GetInfo(ctx, file.Path)
-		if err != nil {
-			continue
-		}
-
-		startTime, _ := time.Parse(time.RFC3339, info.Metadata["start_time"])
-		isCompressed := info.Metadata["is_compressed"] == "true"
-
-		backup := &BackupResult{
-			ID:           info.Metadata["backup_id"],
-			Type:         BackupType(info.Metadata["backup_type"]),
-			StartTime:    startTime,
-			EndTime:      info.LastModified,
-			Size:         info.Size,
-			StoragePath:  file.Path,
-			IsCompressed: isCompressed,
-			Success:      true,
+	// Record backup in the catalog
+	if b.Catalog != nil {
+		if err := b.Catalog.Record(ctx, result); err != nil {
+			return nil, fmt.Errorf("failed to record backup in catalog: %w", err)
 		}
-		backups = append(backups, backup)
 	}
 
-	return backups, nil
+	return result, nil
+}
+
+// ListBackups returns a list of all available backups
+func (b *IncrementalBackup) ListBackups(ctx context.Context) ([]*BackupResult, error) {
+	return listBackups(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName})
 }
 
 // GetBackup retrieves details about a specific backup
 func (b *IncrementalBackup) GetBackup(ctx context.Context, id string) (*BackupResult, error) {
-	backups, err := b.ListBackups(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list backups: %w", err)
-	}
-
-	for _, backup := range backups {
-		if backup.ID == id {
-			return backup, nil
-		}
-	}
-
-	return nil, fmt.Errorf("backup %s not found", id)
+	return getBackup(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName}, id)
 }
 
 // DeleteBackup removes a backup from storage
 func (b *IncrementalBackup) DeleteBackup(ctx context.Context, id string) error {
-	backup, err := b.GetBackup(ctx, id)
-	if err != nil {
-		return fmt.Errorf("failed to get backup: %w", err)
-	}
-
-	err = b.Storage.Delete(ctx, backup.StoragePath)
-	if err != nil {
-		return fmt.Errorf("failed to delete backup: %w", err)
-	}
-
-	return nil
-}
-
-// Helper functions
-func isBackupFile(path string) bool {
-	return strings.HasSuffix(path, ".db") || strings.HasSuffix(path, ".db.gz")
+	return deleteBackup(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName}, id)
 }
-
-func filterTables(tables []string, include, exclude []string) []string {
-	if len(include) == 0 && len(exclude) == 0 {
-		return tables
-	}
-
-	// If include is specified, only keep those tables
-	if len(include) > 0 {
-		included := make(map[string]bool)
-		for _, t := range include {
-			included[t] = true
-		}
-
-		var filtered []string
-		for _, t := range tables {
-			if included[t] {
-				filtered = append(filtered, t)
-			}
-		}
-		tables = filtered
-	}
-
-	// If exclude is specified, remove those tables
-	if len(exclude) > 0 {
-		excluded := make(map[string]bool)
-		for _, t := range exclude {
-			excluded[t] = true
-		}
-
-		var filtered []string
-		for _, t := range tables {
-			if !excluded[t] {
-				filtered = append(filtered, t)
-			}
-		}
-		tables = filtered
-	}
-
-	return tables
-} 
\ No newline at end of file
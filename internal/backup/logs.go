@@ -0,0 +1,296 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yourusername/backyardBackup/internal/compression"
+	"github.com/yourusername/backyardBackup/internal/database"
+	"github.com/yourusername/backyardBackup/internal/encryption"
+	"github.com/yourusername/backyardBackup/internal/storage"
+)
+
+// LogSegmentSuffix is appended to the storage path of an archived log file
+// to form the path of its description
+const LogSegmentSuffix = ".segment.json"
+
+// FormatLog marks incremental backups that contain the transaction log
+// since their parent backup, replayed on top of it
+const FormatLog = "log"
+
+// LogSegment describes an archived transaction log file
+type LogSegment struct {
+	Name        string                      `json:"name"` // File name given by the database
+	DBType      database.DBType             `json:"db_type"`
+	SourceDB    string                      `json:"source_db"`
+	StoragePath string                      `json:"storage_path"`
+	Size        int64                       `json:"size"`
+	Checksum    string                      `json:"checksum"`
+	ArchivedAt  time.Time                   `json:"archived_at"`
+	Start       *database.LogPosition       `json:"start,omitempty"` // First position in the file, when known
+	End         *database.LogPosition       `json:"end,omitempty"`   // Last position in the file, when known
+	Compression compression.CompressionType `json:"compression"`
+	Encryption  *ManifestEncryption         `json:"encryption,omitempty"`
+}
+
+// NeededAfter reports whether the file may hold events after a log position.
+// Positions that name a file are compared by file name, others by time.
+func (s *LogSegment) NeededAfter(pos *database.LogPosition) bool {
+	if pos.File != "" {
+		return s.Name >= pos.File
+	}
+	return s.End == nil || !s.End.Time.Before(pos.Time)
+}
+
+// LogArchive stores the transaction log of a database next to its backups,
+// for point-in-time recovery. Log files are compressed and encrypted like
+// backups and described by a JSON file each.
+type LogArchive struct {
+	Storage  storage.Provider
+	DBType   database.DBType
+	SourceDB string
+	Options  BackupOptions // Compression and encryption of archived files; Keys decrypts them
+}
+
+// NewLogArchive creates a log archive for a database
+func NewLogArchive(store storage.Provider, dbType database.DBType, sourceDB string, opts BackupOptions) *LogArchive {
+	return &LogArchive{
+		Storage:  store,
+		DBType:   dbType,
+		SourceDB: sourceDB,
+		Options:  opts,
+	}
+}
+
+// Dir returns the storage directory of the archive
+func (a *LogArchive) Dir() string {
+	return filepath.Join(string(a.DBType), a.SourceDB, "logs")
+}
+
+// segmentPath returns the storage path of the description of a log file
+func (a *LogArchive) segmentPath(name string) string {
+	return filepath.Join(a.Dir(), name+LogSegmentSuffix)
+}
+
+// Archive stores a log file. Archiving a file that is already in the archive
+// does nothing, so archive commands can safely be retried.
+func (a *LogArchive) Archive(ctx context.Context, name string, r io.Reader, start, end *database.LogPosition) (*LogSegment, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid log file name %q", name)
+	}
+	if existing, err := a.Get(ctx, name); err == nil {
+		return existing, nil
+	}
+
+	path := filepath.Join(a.Dir(), name) + compression.Extension(a.Options.compressor().Type)
+	size, checksum, err := streamBackup(ctx, a.Storage, path, map[string]string{
+		"log_name":  name,
+		"source_db": a.SourceDB,
+	}, a.Options, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	segment := &LogSegment{
+		Name:        name,
+		DBType:      a.DBType,
+		SourceDB:    a.SourceDB,
+		StoragePath: path,
+		Size:        size,
+		Checksum:    checksum,
+		ArchivedAt:  time.Now(),
+		Start:       start,
+		End:         end,
+		Compression: a.Options.compressor().Type,
+	}
+	if key := a.Options.EncryptionKey; key != nil {
+		segment.Encryption = &ManifestEncryption{
+			Algorithm: encryption.Algorithm,
+			KeyID:     key.ID,
+		}
+	}
+
+	// The description is written last, so a file is only listed once it is complete
+	data, err := json.MarshalIndent(segment, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal log segment: %w", err)
+	}
+	if err := a.Storage.Store(ctx, a.segmentPath(name), bytes.NewReader(data), nil); err != nil {
+		return nil, fmt.Errorf("failed to store log segment: %w", err)
+	}
+
+	return segment, nil
+}
+
+// Get returns the description of an archived log file
+func (a *LogArchive) Get(ctx context.Context, name string) (*LogSegment, error) {
+	return a.readSegment(ctx, a.segmentPath(name))
+}
+
+// readSegment reads the description of a log file from storage
+func (a *LogArchive) readSegment(ctx context.Context, path string) (*LogSegment, error) {
+	var buf bytes.Buffer
+	if err := a.Storage.Retrieve(ctx, path, &buf); err != nil {
+		return nil, fmt.Errorf("failed to retrieve log segment: %w", err)
+	}
+
+	var segment LogSegment
+	if err := json.Unmarshal(buf.Bytes(), &segment); err != nil {
+		return nil, fmt.Errorf("failed to parse log segment %s: %w", path, err)
+	}
+	return &segment, nil
+}
+
+// Fetch writes the contents of an archived log file to w
+func (a *LogArchive) Fetch(ctx context.Context, name string, w io.Writer) error {
+	segment, err := a.Get(ctx, name)
+	if err != nil {
+		return fmt.Errorf("log file %s is not archived: %w", name, err)
+	}
+
+	// Retrieve the file in the background and decode it as it arrives
+	pr, pw := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		err := a.Storage.Retrieve(ctx, segment.StoragePath, pw)
+		pw.CloseWithError(err)
+		errCh <- err
+	}()
+
+	var stream io.Reader = pr
+	if segment.Encryption != nil {
+		stream, err = encryption.NewReader(stream, a.Options.Keys)
+		if err != nil {
+			pr.CloseWithError(err)
+			<-errCh
+			return fmt.Errorf("failed to decrypt log file %s: %w", name, err)
+		}
+	}
+	decompressor, err := compression.NewCompressor(segment.Compression).NewReader(stream)
+	if err != nil {
+		pr.CloseWithError(err)
+		<-errCh
+		return fmt.Errorf("failed to decompress log file %s: %w", name, err)
+	}
+	defer decompressor.Close()
+
+	if _, err := io.Copy(w, decompressor); err != nil {
+		pr.CloseWithError(err)
+		<-errCh
+		return fmt.Errorf("failed to read log file %s: %w", name, err)
+	}
+	io.Copy(io.Discard, pr)
+
+	if err := <-errCh; err != nil {
+		return fmt.Errorf("failed to retrieve log file %s: %w", name, err)
+	}
+	return nil
+}
+
+// List returns the archived log files, ordered by name
+func (a *LogArchive) List(ctx context.Context) ([]*LogSegment, error) {
+	files, err := a.Storage.List(ctx, a.Dir()+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log files: %w", err)
+	}
+
+	var segments []*LogSegment
+	for _, file := range files {
+		if !strings.HasSuffix(file.Path, LogSegmentSuffix) {
+			continue
+		}
+		segment, err := a.readSegment(ctx, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Name < segments[j].Name
+	})
+	return segments, nil
+}
+
+// ArchiveSpool archives the completed log files that receiver has written to
+// a local directory and removes them once they are stored. Files still being
+// written are left for a later call. Receivers that can describe their log
+// files have the position range of each file recorded.
+func (a *LogArchive) ArchiveSpool(ctx context.Context, dir string, receiver database.LogReceiver) ([]*LogSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	var archived []*LogSegment
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !receiver.LogComplete(dir, entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		var start, end *database.LogPosition
+		if describer, ok := receiver.(database.LogDescriber); ok {
+			if start, end, err = describer.DescribeLog(path); err != nil {
+				return archived, fmt.Errorf("failed to read log file %s: %w", entry.Name(), err)
+			}
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return archived, fmt.Errorf("failed to open log file: %w", err)
+		}
+		segment, err := a.Archive(ctx, entry.Name(), file, start, end)
+		file.Close()
+		if err != nil {
+			return archived, fmt.Errorf("failed to archive log file %s: %w", entry.Name(), err)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return archived, fmt.Errorf("failed to remove archived log file: %w", err)
+		}
+		archived = append(archived, segment)
+	}
+
+	return archived, nil
+}
+
+// Prune deletes the archived log files that are not needed after keepFrom,
+// the log start of the oldest backup, and returns them. PostgreSQL timeline
+// history files are always kept, as every recovery reads them.
+func (a *LogArchive) Prune(ctx context.Context, keepFrom *database.LogPosition, dryRun bool) ([]*LogSegment, error) {
+	segments, err := a.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []*LogSegment
+	for _, segment := range segments {
+		if segment.NeededAfter(keepFrom) || strings.HasSuffix(segment.Name, ".history") {
+			continue
+		}
+
+		if !dryRun {
+			if err := a.Storage.Delete(ctx, segment.StoragePath); err != nil {
+				return pruned, fmt.Errorf("failed to delete log file %s: %w", segment.Name, err)
+			}
+			if err := a.Storage.Delete(ctx, a.segmentPath(segment.Name)); err != nil {
+				return pruned, fmt.Errorf("failed to delete log segment %s: %w", segment.Name, err)
+			}
+		}
+		pruned = append(pruned, segment)
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,184 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/yourusername/backyardBackup/internal/compression"
+	"github.com/yourusername/backyardBackup/internal/database"
+	"github.com/yourusername/backyardBackup/internal/encryption"
+	"github.com/yourusername/backyardBackup/internal/storage"
+)
+
+// ManifestVersion is the version of the manifest format written by this build
+const ManifestVersion = 1
+
+// ManifestSuffix is appended to a backup's storage path to form the path of its manifest
+const ManifestSuffix = ".manifest.json"
+
+// Manifest is a self-describing record of a backup, stored next to the dump.
+// It holds everything needed to understand and restore the backup without
+// access to the catalog or to provider-specific object metadata.
+type Manifest struct {
+	Version      int                   `json:"version"`
+	Backup       *BackupResult         `json:"backup"`
+	Engine       ManifestEngine        `json:"engine"`
+	Tables       []string              `json:"tables"`
+	Full         *FullMetadata         `json:"full,omitempty"`
+	Incremental  *IncrementalMetadata  `json:"incremental,omitempty"`
+	Differential *DifferentialMetadata `json:"differential,omitempty"`
+	Physical     *PhysicalMetadata     `json:"physical,omitempty"`
+	Compression  ManifestCompression   `json:"compression"`
+	Encryption   *ManifestEncryption   `json:"encryption,omitempty"`
+}
+
+// ManifestEngine describes the database engine the backup was taken from
+type ManifestEngine struct {
+	Type    database.DBType   `json:"type"`
+	Version string            `json:"version"`
+	Info    map[string]string `json:"info,omitempty"`
+}
+
+// ManifestCompression describes how the backup stream was compressed
+type ManifestCompression struct {
+	Algorithm    compression.CompressionType `json:"algorithm"`
+	Level        *int                        `json:"level,omitempty"`
+	LongDistance bool                        `json:"long_distance,omitempty"`
+}
+
+// ManifestEncryption describes how the backup stream was encrypted
+type ManifestEncryption struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+}
+
+// ManifestPath returns the storage path of the manifest for a backup artifact
+func ManifestPath(backupPath string) string {
+	return backupPath + ManifestSuffix
+}
+
+// IsPageDiff reports whether the backup only contains the pages that changed
+// since its parent backup
+func (m *Manifest) IsPageDiff() bool {
+	switch {
+	case m.Incremental != nil:
+		return m.Incremental.Format == FormatPages
+	case m.Differential != nil:
+		return m.Differential.Format == FormatPages
+	default:
+		return false
+	}
+}
+
+// LogStart returns the first transaction log position needed to recover
+// from the backup, or nil if the backup does not use the log archive
+func (m *Manifest) LogStart() *database.LogPosition {
+	switch {
+	case m.Physical != nil:
+		return m.Physical.Start
+	case m.Full != nil:
+		return m.Full.LogStart
+	case m.Incremental != nil:
+		return m.Incremental.LogStart
+	default:
+		return nil
+	}
+}
+
+// LogEnd returns the log position a restored logical backup is consistent
+// with, from which archived logs are replayed, or nil if it is unknown
+func (m *Manifest) LogEnd() *database.LogPosition {
+	switch {
+	case m.Full != nil:
+		return m.Full.LogStart
+	case m.Incremental != nil:
+		return m.Incremental.LogEnd
+	default:
+		return nil
+	}
+}
+
+// isManifestFile checks whether a storage path is a backup manifest
+func isManifestFile(path string) bool {
+	return strings.HasSuffix(path, ManifestSuffix)
+}
+
+// newManifest creates a manifest for a backup result
+func newManifest(result *BackupResult, opts BackupOptions, dbType database.DBType, dbInfo map[string]string) *Manifest {
+	compressor := opts.compressor()
+
+	var enc *ManifestEncryption
+	if opts.EncryptionKey != nil {
+		enc = &ManifestEncryption{
+			Algorithm: encryption.Algorithm,
+			KeyID:     opts.EncryptionKey.ID,
+		}
+	}
+
+	return &Manifest{
+		Version: ManifestVersion,
+		Backup:  result,
+		Engine: ManifestEngine{
+			Type:    dbType,
+			Version: dbInfo["version"],
+			Info:    dbInfo,
+		},
+		Tables: result.Tables,
+		Compression: ManifestCompression{
+			Algorithm:    compressor.Type,
+			Level:        compressor.Level,
+			LongDistance: compressor.LongDistance,
+		},
+		Encryption: enc,
+	}
+}
+
+// WriteManifest stores a manifest next to its backup artifact
+func WriteManifest(ctx context.Context, store storage.Provider, manifest *Manifest) error {
+	if manifest.Backup == nil {
+		return fmt.Errorf("manifest has no backup")
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	path := ManifestPath(manifest.Backup.StoragePath)
+	if err := store.Store(ctx, path, bytes.NewReader(data), nil); err != nil {
+		return fmt.Errorf("failed to store manifest: %w", err)
+	}
+
+	return nil
+}
+
+// ReadManifest loads the manifest of the backup stored at backupPath
+func ReadManifest(ctx context.Context, store storage.Provider, backupPath string) (*Manifest, error) {
+	return readManifestFile(ctx, store, ManifestPath(backupPath))
+}
+
+// readManifestFile loads a manifest from its own storage path
+func readManifestFile(ctx context.Context, store storage.Provider, path string) (*Manifest, error) {
+	var buf bytes.Buffer
+	if err := store.Retrieve(ctx, path, &buf); err != nil {
+		return nil, fmt.Errorf("failed to retrieve manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(buf.Bytes(), &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+
+	if manifest.Version > ManifestVersion {
+		return nil, fmt.Errorf("manifest %s has version %d, newer than supported version %d",
+			path, manifest.Version, ManifestVersion)
+	}
+	if manifest.Backup == nil {
+		return nil, fmt.Errorf("manifest %s has no backup", path)
+	}
+
+	return &manifest, nil
+}
@@ -0,0 +1,302 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/yourusername/backyardBackup/internal/encryption"
+	"github.com/yourusername/backyardBackup/internal/storage"
+)
+
+// PageMapSuffix is appended to a backup's storage path to form the path of its page map
+const PageMapSuffix = ".pagemap"
+
+// FormatPages marks incremental backups that contain only changed pages
+const FormatPages = "pages"
+
+const (
+	pageFormatVersion = 1
+	endOfPages        = ^uint64(0)
+)
+
+var (
+	// pageMapMagic identifies a page map file
+	pageMapMagic = []byte("BYBPMAP")
+	// pageDiffMagic identifies a page diff stream
+	pageDiffMagic = []byte("BYBPDIF")
+)
+
+// Page diff layout:
+//
+//	header:  "BYBPDIF" | version | page size (uint32)
+//	pages:   page number (uint64) | page data, for every changed page
+//	trailer: 0xFFFFFFFFFFFFFFFF | page count of the database (uint64)
+//
+// Applying a diff writes every page at its offset and truncates the file to
+// the page count, which also handles databases that shrank.
+
+// PageMap holds the SHA-256 hash of every page of a database snapshot
+type PageMap struct {
+	PageSize int
+	Hashes   [][sha256.Size]byte
+}
+
+// PageMapPath returns the storage path of the page map for a backup artifact
+func PageMapPath(backupPath string) string {
+	return backupPath + PageMapSuffix
+}
+
+// pageHasher builds a page map from the bytes written to it
+type pageHasher struct {
+	buf    []byte
+	hashes [][sha256.Size]byte
+}
+
+// newPageHasher creates a page hasher for the given page size
+func newPageHasher(pageSize int) *pageHasher {
+	return &pageHasher{
+		buf: make([]byte, 0, pageSize),
+	}
+}
+
+// Write implements io.Writer
+func (h *pageHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := copy(h.buf[len(h.buf):cap(h.buf)], p)
+		h.buf = h.buf[:len(h.buf)+k]
+		p = p[k:]
+
+		if len(h.buf) == cap(h.buf) {
+			h.hashes = append(h.hashes, sha256.Sum256(h.buf))
+			h.buf = h.buf[:0]
+		}
+	}
+	return n, nil
+}
+
+// PageMap returns the page map of everything written
+func (h *pageHasher) PageMap() (*PageMap, error) {
+	if len(h.buf) > 0 {
+		return nil, fmt.Errorf("database file size is not a multiple of the page size %d", cap(h.buf))
+	}
+	return &PageMap{
+		PageSize: cap(h.buf),
+		Hashes:   h.hashes,
+	}, nil
+}
+
+// writePageDiff reads a database snapshot from r and writes the pages that
+// differ from the parent page map to w. It returns the page map of the
+// snapshot and the number of pages written. Every page is written if there
+// is no parent map or its page size differs.
+func writePageDiff(w io.Writer, r io.Reader, pageSize int, parent *PageMap) (*PageMap, int64, error) {
+	if parent != nil && parent.PageSize != pageSize {
+		parent = nil
+	}
+
+	bw := bufio.NewWriter(w)
+
+	// Header
+	bw.Write(pageDiffMagic)
+	bw.WriteByte(pageFormatVersion)
+	binary.Write(bw, binary.BigEndian, uint32(pageSize))
+
+	// Changed pages
+	hasher := newPageHasher(pageSize)
+	page := make([]byte, pageSize)
+	var changed int64
+	for pageNo := uint64(0); ; pageNo++ {
+		n, err := io.ReadFull(r, page)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, fmt.Errorf("database file size is not a multiple of the page size %d (%d trailing bytes)", pageSize, n)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read database page %d: %w", pageNo, err)
+		}
+
+		hasher.Write(page)
+		hash := hasher.hashes[pageNo]
+		if parent != nil && pageNo < uint64(len(parent.Hashes)) && parent.Hashes[pageNo] == hash {
+			continue
+		}
+
+		binary.Write(bw, binary.BigEndian, pageNo)
+		if _, err := bw.Write(page); err != nil {
+			return nil, 0, fmt.Errorf("failed to write page diff: %w", err)
+		}
+		changed++
+	}
+
+	pageMap, err := hasher.PageMap()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Trailer
+	binary.Write(bw, binary.BigEndian, endOfPages)
+	binary.Write(bw, binary.BigEndian, uint64(len(pageMap.Hashes)))
+	if err := bw.Flush(); err != nil {
+		return nil, 0, fmt.Errorf("failed to write page diff: %w", err)
+	}
+
+	return pageMap, changed, nil
+}
+
+// ApplyPageDiff applies a page diff written by a page-level incremental
+// backup to a database file restored from the diff's parent
+func ApplyPageDiff(f *os.File, r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	// Header
+	head := make([]byte, len(pageDiffMagic)+1)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return fmt.Errorf("failed to read page diff header: %w", err)
+	}
+	if !bytes.Equal(head[:len(pageDiffMagic)], pageDiffMagic) {
+		return fmt.Errorf("stream is not a page diff")
+	}
+	if head[len(pageDiffMagic)] != pageFormatVersion {
+		return fmt.Errorf("unsupported page diff version %d", head[len(pageDiffMagic)])
+	}
+	var pageSize uint32
+	if err := binary.Read(br, binary.BigEndian, &pageSize); err != nil {
+		return fmt.Errorf("failed to read page diff header: %w", err)
+	}
+
+	// Pages
+	page := make([]byte, pageSize)
+	for {
+		var pageNo uint64
+		if err := binary.Read(br, binary.BigEndian, &pageNo); err != nil {
+			return fmt.Errorf("page diff is truncated: %w", err)
+		}
+		if pageNo == endOfPages {
+			break
+		}
+
+		if _, err := io.ReadFull(br, page); err != nil {
+			return fmt.Errorf("page diff is truncated: %w", err)
+		}
+		if _, err := f.WriteAt(page, int64(pageNo)*int64(pageSize)); err != nil {
+			return fmt.Errorf("failed to write page %d: %w", pageNo, err)
+		}
+	}
+
+	// Trailer
+	var pageCount uint64
+	if err := binary.Read(br, binary.BigEndian, &pageCount); err != nil {
+		return fmt.Errorf("page diff is truncated: %w", err)
+	}
+	if err := f.Truncate(int64(pageCount) * int64(pageSize)); err != nil {
+		return fmt.Errorf("failed to resize database file: %w", err)
+	}
+
+	return nil
+}
+
+// storePageMap stores the page map of a backup next to it, encrypted with
+// the backup's key if the backup is encrypted
+func storePageMap(ctx context.Context, store storage.Provider, backupPath string, pageMap *PageMap, opts BackupOptions) error {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+
+	var encrypter io.WriteCloser
+	if opts.EncryptionKey != nil {
+		var err error
+		encrypter, err = encryption.NewWriter(&buf, opts.EncryptionKey)
+		if err != nil {
+			return fmt.Errorf("failed to start encryption: %w", err)
+		}
+		w = encrypter
+	}
+
+	// Header and hashes
+	w.Write(pageMapMagic)
+	w.Write([]byte{pageFormatVersion})
+	binary.Write(w, binary.BigEndian, uint32(pageMap.PageSize))
+	binary.Write(w, binary.BigEndian, uint64(len(pageMap.Hashes)))
+	for _, hash := range pageMap.Hashes {
+		w.Write(hash[:])
+	}
+
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return fmt.Errorf("failed to finish encryption: %w", err)
+		}
+	}
+
+	if err := store.Store(ctx, PageMapPath(backupPath), &buf, nil); err != nil {
+		return fmt.Errorf("failed to store page map: %w", err)
+	}
+
+	return nil
+}
+
+// loadPageMap loads the page map stored next to a backup. It returns nil if
+// the backup has no page map, such as backups taken before page maps were
+// introduced.
+func loadPageMap(ctx context.Context, store storage.Provider, b *BackupResult, keys *encryption.Keyring) (*PageMap, error) {
+	path := PageMapPath(b.StoragePath)
+	if _, err := store.GetInfo(ctx, path); err != nil {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := store.Retrieve(ctx, path, &buf); err != nil {
+		return nil, fmt.Errorf("failed to retrieve page map: %w", err)
+	}
+
+	var r io.Reader = &buf
+	if encryption.IsEncrypted(buf.Bytes()) {
+		var err error
+		r, err = encryption.NewReader(r, keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt page map: %w", err)
+		}
+	}
+	r = bufio.NewReader(r)
+
+	// Header
+	head := make([]byte, len(pageMapMagic)+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, fmt.Errorf("failed to read page map: %w", err)
+	}
+	if !bytes.Equal(head[:len(pageMapMagic)], pageMapMagic) {
+		return nil, fmt.Errorf("%s is not a page map", path)
+	}
+	if head[len(pageMapMagic)] != pageFormatVersion {
+		return nil, fmt.Errorf("unsupported page map version %d", head[len(pageMapMagic)])
+	}
+	var pageSize uint32
+	var pageCount uint64
+	if err := binary.Read(r, binary.BigEndian, &pageSize); err != nil {
+		return nil, fmt.Errorf("failed to read page map: %w", err)
+	}
+	if err := binary.Read(r, binary.BigEndian, &pageCount); err != nil {
+		return nil, fmt.Errorf("failed to read page map: %w", err)
+	}
+
+	// Hashes
+	pageMap := &PageMap{
+		PageSize: int(pageSize),
+	}
+	for i := uint64(0); i < pageCount; i++ {
+		var hash [sha256.Size]byte
+		if _, err := io.ReadFull(r, hash[:]); err != nil {
+			return nil, fmt.Errorf("page map is truncated: %w", err)
+		}
+		pageMap.Hashes = append(pageMap.Hashes, hash)
+	}
+
+	return pageMap, nil
+}
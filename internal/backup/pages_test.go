@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yourusername/backyardBackup/internal/encryption"
+	"github.com/yourusername/backyardBackup/internal/storage"
+)
+
+const testPageSize = 512
+
+// testDatabase returns a database file of random pages
+func testDatabase(t *testing.T, pages int) []byte {
+	t.Helper()
+	data := make([]byte, pages*testPageSize)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// changePage returns a copy of data with one byte of a page changed
+func changePage(data []byte, pageNo int) []byte {
+	data = bytes.Clone(data)
+	data[pageNo*testPageSize+7] ^= 0xff
+	return data
+}
+
+// diff writes a page diff of data against parent
+func diff(t *testing.T, data []byte, parent *PageMap) ([]byte, *PageMap, int64) {
+	t.Helper()
+	var buf bytes.Buffer
+	pageMap, changed, err := writePageDiff(&buf, bytes.NewReader(data), testPageSize, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), pageMap, changed
+}
+
+// apply applies a page diff to a file holding base and returns the result
+func apply(t *testing.T, base, pageDiff []byte) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "db")
+	if err := os.WriteFile(path, base, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ApplyPageDiff(f, bytes.NewReader(pageDiff)); err != nil {
+		t.Fatal(err)
+	}
+	result, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestPageDiffChain(t *testing.T) {
+	full := testDatabase(t, 8)
+	fullDiff, fullMap, changed := diff(t, full, nil)
+	if changed != 8 {
+		t.Errorf("full diff has %d pages, want 8", changed)
+	}
+	if len(fullMap.Hashes) != 8 || fullMap.PageSize != testPageSize {
+		t.Fatalf("page map has %d pages of %d bytes, want 8 of %d", len(fullMap.Hashes), fullMap.PageSize, testPageSize)
+	}
+	restored := apply(t, nil, fullDiff)
+	if !bytes.Equal(restored, full) {
+		t.Fatal("full diff applied to an empty file differs from the database")
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		changed int64
+	}{
+		{"unchanged", full, 0},
+		{"pages changed", changePage(changePage(full, 1), 6), 2},
+		{"grown", append(changePage(full, 0), testDatabase(t, 3)...), 4},
+		{"shrunk", changePage(full, 2)[:5*testPageSize], 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pageDiff, pageMap, changed := diff(t, tt.data, fullMap)
+			if changed != tt.changed {
+				t.Errorf("diff has %d pages, want %d", changed, tt.changed)
+			}
+			if len(pageMap.Hashes) != len(tt.data)/testPageSize {
+				t.Errorf("page map has %d pages, want %d", len(pageMap.Hashes), len(tt.data)/testPageSize)
+			}
+			if !bytes.Equal(apply(t, restored, pageDiff), tt.data) {
+				t.Error("applied diff differs from the database")
+			}
+		})
+	}
+}
+
+func TestPageDiffPageSizeChange(t *testing.T) {
+	full := testDatabase(t, 4)
+	_, fullMap, _ := diff(t, full, nil)
+
+	// A parent with another page size cannot be compared against
+	var buf bytes.Buffer
+	_, changed, err := writePageDiff(&buf, bytes.NewReader(full), 2*testPageSize, fullMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 2 {
+		t.Errorf("diff has %d pages, want every page (2)", changed)
+	}
+}
+
+func TestPageDiffErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if _, _, err := writePageDiff(&buf, bytes.NewReader(make([]byte, testPageSize+1)), testPageSize, nil); err == nil {
+		t.Error("database with a partial page was diffed")
+	}
+
+	full := testDatabase(t, 2)
+	pageDiff, _, _ := diff(t, full, nil)
+	path := filepath.Join(t.TempDir(), "db")
+	for name, data := range map[string][]byte{
+		"truncated page":    pageDiff[:len(pageDiff)-16-testPageSize/2],
+		"missing trailer":   pageDiff[:len(pageDiff)-16],
+		"truncated trailer": pageDiff[:len(pageDiff)-4],
+		"not a page diff":   append([]byte("NOTADIF"), pageDiff[len(pageDiffMagic):]...),
+		"empty":             nil,
+	} {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ApplyPageDiff(f, bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: diff was applied", name)
+		}
+		f.Close()
+	}
+}
+
+func TestPageMapStorage(t *testing.T) {
+	ctx := context.Background()
+	store := &storage.LocalProvider{}
+	if err := store.Initialize(ctx, storage.ProviderConfig{Type: storage.Local, BasePath: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encryption.NewKey("main", bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := encryption.NewKeyring([]*encryption.Key{key}, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, pageMap, _ := diff(t, testDatabase(t, 5), nil)
+	for name, opts := range map[string]BackupOptions{
+		"plain":     {},
+		"encrypted": {EncryptionKey: key},
+	} {
+		b := &BackupResult{StoragePath: "sqlite/db/incremental/" + name + ".db"}
+		if err := storePageMap(ctx, store, b.StoragePath, pageMap, opts); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		loaded, err := loadPageMap(ctx, store, b, keys)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if loaded == nil || loaded.PageSize != pageMap.PageSize || len(loaded.Hashes) != len(pageMap.Hashes) {
+			t.Fatalf("%s: loaded page map differs from the stored one", name)
+		}
+		for i := range pageMap.Hashes {
+			if loaded.Hashes[i] != pageMap.Hashes[i] {
+				t.Errorf("%s: hash of page %d differs", name, i)
+			}
+		}
+	}
+
+	// Backups without a page map have none
+	missing, err := loadPageMap(ctx, store, &BackupResult{StoragePath: "sqlite/db/full/old.db"}, keys)
+	if err != nil || missing != nil {
+		t.Errorf("backup without a page map: got %v, %v", missing, err)
+	}
+}
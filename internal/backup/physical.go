@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/backyardBackup/internal/compression"
+	"github.com/yourusername/backyardBackup/internal/database"
+	"github.com/yourusername/backyardBackup/internal/storage"
+)
+
+// PhysicalBackup implements physical backups of the data directory, which
+// are restored together with the archived transaction log
+type PhysicalBackup struct {
+	DB          database.Connector
+	Storage     storage.Provider
+	Catalog     Catalog // Optional; backups are listed from storage when nil
+	StorageName string  // Name of the storage in the configuration
+}
+
+// PhysicalMetadata contains metadata about a physical backup
+type PhysicalMetadata struct {
+	Start     *database.LogPosition `json:"start"` // Log position recovery replays from
+	Timestamp time.Time             `json:"timestamp"`
+}
+
+// NewPhysicalBackup creates a new physical backup instance
+func NewPhysicalBackup(db database.Connector, storage storage.Provider) *PhysicalBackup {
+	return &PhysicalBackup{
+		DB:      db,
+		Storage: storage,
+	}
+}
+
+// Backup performs a physical backup of the database server
+func (b *PhysicalBackup) Backup(ctx context.Context, opts BackupOptions) (*BackupResult, error) {
+	if b.DB == nil {
+		return nil, fmt.Errorf("database connector not initialized")
+	}
+	if b.Storage == nil {
+		return nil, fmt.Errorf("storage provider not initialized")
+	}
+
+	physical, ok := b.DB.(database.PhysicalConnector)
+	if !ok {
+		return nil, fmt.Errorf("physical backups are not supported for %s databases", b.DB.Type())
+	}
+
+	// Start backup
+	startTime := time.Now()
+	backupID := uuid.New().String()
+
+	// Get database info
+	dbInfo, err := b.DB.GetInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database info: %w", err)
+	}
+
+	// Create backup path
+	backupPath := filepath.Join(
+		string(b.DB.Type()),
+		opts.SourceDB,
+		"physical",
+		fmt.Sprintf("%s-%s.tar", startTime.Format("20060102-150405"), backupID),
+	)
+
+	backupPath += compression.Extension(opts.compressor().Type)
+
+	// Store backup data; the start position is only known once the copy begins
+	metadata := PhysicalMetadata{
+		Timestamp: startTime,
+	}
+	size, checksum, err := streamBackup(ctx, b.Storage, backupPath, map[string]string{
+		"backup_type":   string(Physical),
+		"backup_id":     backupID,
+		"source_db":     opts.SourceDB,
+		"start_time":    startTime.Format(time.RFC3339),
+		"is_compressed": fmt.Sprintf("%v", opts.Compress),
+	}, opts, func(w io.Writer) error {
+		var err error
+		metadata.Start, err = physical.PhysicalBackup(ctx, w)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BackupResult{
+		ID:           backupID,
+		Type:         Physical,
+		SourceDB:     opts.SourceDB,
+		Storage:      opts.DestStorage,
+		StartTime:    startTime,
+		EndTime:      time.Now(),
+		Size:         size,
+		Checksum:     checksum,
+		StoragePath:  backupPath,
+		IsCompressed: opts.Compress,
+		Success:      true,
+	}
+
+	// Write manifest next to the backup
+	manifest := newManifest(result, opts, b.DB.Type(), dbInfo)
+	manifest.Physical = &metadata
+	if err := WriteManifest(ctx, b.Storage, manifest); err != nil {
+		return nil, err
+	}
+
+	// Record backup in the catalog
+	if b.Catalog != nil {
+		if err := b.Catalog.Record(ctx, result); err != nil {
+			return nil, fmt.Errorf("failed to record backup in catalog: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// ListBackups returns a list of all available backups
+func (b *PhysicalBackup) ListBackups(ctx context.Context) ([]*BackupResult, error) {
+	return listBackups(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName})
+}
+
+// GetBackup retrieves details about a specific backup
+func (b *PhysicalBackup) GetBackup(ctx context.Context, id string) (*BackupResult, error) {
+	return getBackup(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName}, id)
+}
+
+// DeleteBackup removes a backup from storage
+func (b *PhysicalBackup) DeleteBackup(ctx context.Context, id string) error {
+	return deleteBackup(ctx, b.Storage, b.Catalog, CatalogFilter{Storage: b.StorageName}, id)
+}
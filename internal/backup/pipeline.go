@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/yourusername/backyardBackup/internal/database"
+	"github.com/yourusername/backyardBackup/internal/encryption"
+	"github.com/yourusername/backyardBackup/internal/storage"
+)
+
+// ChecksumPrefix identifies the hash algorithm used for backup checksums
+const ChecksumPrefix = "sha256:"
+
+// cleanupTimeout bounds the removal of a partial backup, which also runs
+// when the backup failed because its context was cancelled
+const cleanupTimeout = time.Minute
+
+// digestReader hashes and counts everything read through it
+type digestReader struct {
+	r    io.Reader
+	hash hash.Hash
+	size int64
+}
+
+// newDigestReader wraps a reader with SHA-256 hashing
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{
+		r:    r,
+		hash: sha256.New(),
+	}
+}
+
+// Read implements io.Reader
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.hash.Write(p[:n])
+	d.size += int64(n)
+	return n, err
+}
+
+// Checksum returns the checksum of the data read so far
+func (d *digestReader) Checksum() string {
+	return ChecksumPrefix + hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// dumpDatabase returns a dump function that backs up the given tables of a database
+func dumpDatabase(ctx context.Context, db database.Connector, tables []string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		return db.Backup(ctx, w, tables)
+	}
+}
+
+// pageConnector returns the database as a PageConnector if it is backed up
+// page by page
+func pageConnector(db database.Connector) (database.PageConnector, bool) {
+	pages, ok := db.(database.PageConnector)
+	return pages, ok && pages.PageLevel()
+}
+
+// streamBackup streams the output of dump straight into storage, compressing
+// and encrypting it as requested, and returns the size and checksum of the
+// stored object. If any stage fails, whatever was stored is removed.
+func streamBackup(ctx context.Context, store storage.Provider, path string, metadata map[string]string, opts BackupOptions, dump func(w io.Writer) error) (int64, string, error) {
+	size, checksum, err := storeStream(ctx, store, path, metadata, opts, dump)
+	if err != nil {
+		return 0, "", errors.Join(err, removePartial(store, path))
+	}
+	return size, checksum, nil
+}
+
+// removePartial deletes the object a failed backup left in storage, if any
+func removePartial(store storage.Provider, path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	if err := deleteIfExists(ctx, store, path); err != nil {
+		return fmt.Errorf("failed to remove partial backup %s: %w", path, err)
+	}
+	return nil
+}
+
+// storeStream runs the stages of streamBackup
+func storeStream(ctx context.Context, store storage.Provider, path string, metadata map[string]string, opts BackupOptions, dump func(w io.Writer) error) (int64, string, error) {
+	metadata["compression"] = string(opts.compressor().Type)
+	if opts.EncryptionKey != nil {
+		metadata["encryption"] = encryption.Algorithm
+		metadata["key_id"] = opts.EncryptionKey.ID
+	}
+
+	// Create a pipe for streaming backup data
+	pr, pw := io.Pipe()
+
+	// Start backup in a goroutine
+	errCh := make(chan error, 1)
+	go func() {
+		err := dumpTo(pw, opts, dump)
+		if err != nil {
+			pw.CloseWithError(err)
+			errCh <- err
+			return
+		}
+		pw.Close()
+		errCh <- nil
+	}()
+
+	// Store backup data, hashing it on the way
+	digest := newDigestReader(pr)
+	if err := store.Store(ctx, path, digest, metadata); err != nil {
+		pr.CloseWithError(err)
+		if backupErr := <-errCh; backupErr != nil {
+			return 0, "", backupErr
+		}
+		return 0, "", fmt.Errorf("failed to store backup: %w", err)
+	}
+
+	// Wait for backup to complete
+	if err := <-errCh; err != nil {
+		return 0, "", err
+	}
+
+	return digest.size, digest.Checksum(), nil
+}
+
+// dumpTo runs the output of dump through the stream stages into w
+func dumpTo(w io.Writer, opts BackupOptions, dump func(w io.Writer) error) error {
+	// Encryption stage
+	var encrypter io.WriteCloser
+	if opts.EncryptionKey != nil {
+		var err error
+		encrypter, err = encryption.NewWriter(w, opts.EncryptionKey)
+		if err != nil {
+			return fmt.Errorf("failed to start encryption: %w", err)
+		}
+		w = encrypter
+	}
+
+	// Compression stage, applied before encryption
+	compressor, err := opts.compressor().NewWriter(w)
+	if err != nil {
+		return fmt.Errorf("failed to start compression: %w", err)
+	}
+	w = compressor
+
+	if err := dump(w); err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("failed to finish compression: %w", err)
+	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return fmt.Errorf("failed to finish encryption: %w", err)
+		}
+	}
+
+	return nil
+}
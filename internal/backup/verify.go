@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/yourusername/backyardBackup/internal/storage"
+)
+
+// VerifyStatus is the outcome of verifying a single backup
+type VerifyStatus string
+
+const (
+	// VerifyOK means the stored object matches its recorded checksum and size
+	VerifyOK VerifyStatus = "ok"
+	// VerifyMismatch means the stored object differs from what was recorded
+	VerifyMismatch VerifyStatus = "mismatch"
+	// VerifyMissing means the stored object no longer exists
+	VerifyMissing VerifyStatus = "missing"
+	// VerifyUnchecked means no checksum was recorded for the backup
+	VerifyUnchecked VerifyStatus = "unchecked"
+	// VerifyError means the object could not be read
+	VerifyError VerifyStatus = "error"
+)
+
+// VerifyResult contains the outcome of verifying a backup
+type VerifyResult struct {
+	Backup           *BackupResult
+	Status           VerifyStatus
+	ExpectedChecksum string
+	ActualChecksum   string
+	ExpectedSize     int64
+	ActualSize       int64
+	ErrorMessage     string
+}
+
+// Verify re-reads a backup from storage and compares it with the checksum
+// and size recorded when it was taken
+func Verify(ctx context.Context, store storage.Provider, b *BackupResult) *VerifyResult {
+	result := &VerifyResult{
+		Backup:           b,
+		ExpectedChecksum: b.Checksum,
+		ExpectedSize:     b.Size,
+	}
+
+	// Check that the object still exists
+	if _, err := store.GetInfo(ctx, b.StoragePath); err != nil {
+		result.Status = VerifyMissing
+		result.ErrorMessage = err.Error()
+		return result
+	}
+
+	// Hash the stored object
+	hash := sha256.New()
+	counter := &countingWriter{w: hash}
+	if err := store.Retrieve(ctx, b.StoragePath, counter); err != nil {
+		result.Status = VerifyError
+		result.ErrorMessage = err.Error()
+		return result
+	}
+	result.ActualChecksum = ChecksumPrefix + hex.EncodeToString(hash.Sum(nil))
+	result.ActualSize = counter.n
+
+	switch {
+	case b.Checksum == "":
+		result.Status = VerifyUnchecked
+	case result.ActualChecksum != b.Checksum:
+		result.Status = VerifyMismatch
+		result.ErrorMessage = "checksum mismatch"
+	case result.ActualSize != b.Size:
+		result.Status = VerifyMismatch
+		result.ErrorMessage = fmt.Sprintf("size mismatch: expected %d bytes, found %d", b.Size, result.ActualSize)
+	default:
+		result.Status = VerifyOK
+	}
+
+	return result
+}
+
+// Orphan is a file in storage that does not belong to a complete backup
+type Orphan struct {
+	Path   string
+	Reason string
+}
+
+// FindOrphans returns the manifests and page maps in storage whose backup
+// object is gone, the backup objects that have no manifest, and any sidecar
+// files the provider keeps for objects that are gone
+func FindOrphans(ctx context.Context, store storage.Provider) ([]Orphan, error) {
+	files, err := store.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage: %w", err)
+	}
+
+	exists := make(map[string]bool)
+	for _, file := range files {
+		exists[file.Path] = true
+	}
+
+	var orphans []Orphan
+	for _, file := range files {
+		switch {
+		case isManifestFile(file.Path):
+			if !exists[strings.TrimSuffix(file.Path, ManifestSuffix)] {
+				orphans = append(orphans, Orphan{Path: file.Path, Reason: "manifest without backup"})
+			}
+		case strings.HasSuffix(file.Path, PageMapSuffix):
+			if !exists[strings.TrimSuffix(file.Path, PageMapSuffix)] {
+				orphans = append(orphans, Orphan{Path: file.Path, Reason: "page map without backup"})
+			}
+		case isBackupFile(file.Path):
+			// Manifests are written last, so the backup may be incomplete
+			if !exists[ManifestPath(file.Path)] {
+				orphans = append(orphans, Orphan{Path: file.Path, Reason: "backup without manifest"})
+			}
+		}
+	}
+
+	if sidecars, ok := store.(storage.SidecarProvider); ok {
+		paths, err := sidecars.OrphanedSidecars(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list sidecar files: %w", err)
+		}
+		for _, path := range paths {
+			orphans = append(orphans, Orphan{Path: path, Reason: "metadata without object"})
+		}
+	}
+
+	return orphans, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,391 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver
+
+	"github.com/yourusername/backyardBackup/internal/backup"
+	"github.com/yourusername/backyardBackup/internal/storage"
+)
+
+// FileName is the name of the catalog database inside the data directory
+const FileName = "catalog.db"
+
+// timeLayout is how times are stored: RFC 3339 in UTC with all nine
+// fractional digits, so that the text sorts in time order
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+const schema = `
+	CREATE TABLE IF NOT EXISTS backups (
+		id             TEXT PRIMARY KEY,
+		type           TEXT NOT NULL,
+		source_db      TEXT NOT NULL DEFAULT '',
+		storage        TEXT NOT NULL DEFAULT '',
+		storage_path   TEXT NOT NULL,
+		base_backup_id TEXT NOT NULL DEFAULT '',
+		start_time     TEXT NOT NULL,
+		end_time       TEXT NOT NULL,
+		size           INTEGER NOT NULL DEFAULT 0,
+		checksum       TEXT NOT NULL DEFAULT '',
+		file_count     INTEGER NOT NULL DEFAULT 0,
+		is_compressed  INTEGER NOT NULL DEFAULT 0,
+		success        INTEGER NOT NULL DEFAULT 0,
+		error_message  TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS backups_source ON backups (source_db, storage, start_time);
+	CREATE INDEX IF NOT EXISTS backups_base ON backups (base_backup_id);
+	CREATE TABLE IF NOT EXISTS backup_tables (
+		backup_id  TEXT NOT NULL,
+		table_name TEXT NOT NULL,
+		PRIMARY KEY (backup_id, table_name)
+	);
+`
+
+// Catalog is a persistent SQLite index of every backup taken
+type Catalog struct {
+	db   *sql.DB
+	path string
+}
+
+// Open opens (or creates) the catalog stored in the given data directory
+func Open(dataDir string) (*Catalog, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	path := filepath.Join(dataDir, FileName)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open catalog: %w", err)
+	}
+
+	// SQLite supports only one writer at a time
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize catalog schema: %w", err)
+	}
+	if err := normalizeTimes(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Catalog{
+		db:   db,
+		path: path,
+	}, nil
+}
+
+// normalizeTimes rewrites the times of catalogs written by earlier versions,
+// which dropped trailing zeros of the fraction and so did not sort in order
+func normalizeTimes(db *sql.DB) error {
+	width := len(timeLayout)
+	rows, err := db.Query("SELECT id, start_time, end_time FROM backups WHERE length(start_time) != ? OR length(end_time) != ?", width, width)
+	if err != nil {
+		return fmt.Errorf("failed to query catalog: %w", err)
+	}
+	defer rows.Close()
+
+	times := make(map[string][2]string)
+	for rows.Next() {
+		var id, startTime, endTime string
+		if err := rows.Scan(&id, &startTime, &endTime); err != nil {
+			return fmt.Errorf("failed to scan catalog row: %w", err)
+		}
+		times[id] = [2]string{startTime, endTime}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating catalog rows: %w", err)
+	}
+	rows.Close()
+
+	for id, t := range times {
+		startTime, _ := time.Parse(time.RFC3339Nano, t[0])
+		endTime, _ := time.Parse(time.RFC3339Nano, t[1])
+		_, err := db.Exec("UPDATE backups SET start_time = ?, end_time = ? WHERE id = ?",
+			formatTime(startTime), formatTime(endTime), id)
+		if err != nil {
+			return fmt.Errorf("failed to update backup %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// formatTime formats a time the way the catalog stores it
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// Close closes the catalog database
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// Path returns the location of the catalog database
+func (c *Catalog) Path() string {
+	return c.path
+}
+
+// Record adds or updates a backup in the catalog
+func (c *Catalog) Record(ctx context.Context, result *backup.BackupResult) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := record(ctx, tx, result); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// record writes a backup and its tables within a transaction
+func record(ctx context.Context, tx *sql.Tx, result *backup.BackupResult) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO backups (
+			id, type, source_db, storage, storage_path, base_backup_id,
+			start_time, end_time, size, checksum, file_count,
+			is_compressed, success, error_message
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		result.ID,
+		string(result.Type),
+		result.SourceDB,
+		result.Storage,
+		result.StoragePath,
+		result.BaseBackupID,
+		formatTime(result.StartTime),
+		formatTime(result.EndTime),
+		result.Size,
+		result.Checksum,
+		result.FileCount,
+		result.IsCompressed,
+		result.Success,
+		result.ErrorMessage,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record backup %s: %w", result.ID, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM backup_tables WHERE backup_id = ?", result.ID); err != nil {
+		return fmt.Errorf("failed to clear tables of backup %s: %w", result.ID, err)
+	}
+	for _, table := range result.Tables {
+		_, err := tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO backup_tables (backup_id, table_name) VALUES (?, ?)",
+			result.ID, table)
+		if err != nil {
+			return fmt.Errorf("failed to record tables of backup %s: %w", result.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Get returns the backup with the given ID
+func (c *Catalog) Get(ctx context.Context, id string) (*backup.BackupResult, error) {
+	backups, err := c.query(ctx, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) == 0 {
+		return nil, fmt.Errorf("backup %s not found", id)
+	}
+	return backups[0], nil
+}
+
+// List returns all backups matching the filter, oldest first
+func (c *Catalog) List(ctx context.Context, filter backup.CatalogFilter) ([]*backup.BackupResult, error) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.SourceDB != "" {
+		conditions = append(conditions, "source_db = ?")
+		args = append(args, filter.SourceDB)
+	}
+	if filter.Storage != "" {
+		conditions = append(conditions, "storage = ?")
+		args = append(args, filter.Storage)
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "type = ?")
+		args = append(args, string(filter.Type))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	return c.query(ctx, where, args...)
+}
+
+// Delete removes a backup from the catalog
+func (c *Catalog) Delete(ctx context.Context, id string) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM backup_tables WHERE backup_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete tables of backup %s: %w", id, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM backups WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete backup %s: %w", id, err)
+	}
+
+	return tx.Commit()
+}
+
+// Chain returns the backup with the given ID and all of its ancestors,
+// starting with the full backup at the root of the chain
+func (c *Catalog) Chain(ctx context.Context, id string) ([]*backup.BackupResult, error) {
+	var chain []*backup.BackupResult
+	seen := make(map[string]bool)
+
+	for id != "" {
+		if seen[id] {
+			return nil, fmt.Errorf("backup chain contains a cycle at %s", id)
+		}
+		seen[id] = true
+
+		b, err := c.Get(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("backup chain is broken: %w", err)
+		}
+		chain = append([]*backup.BackupResult{b}, chain...)
+		id = b.BaseBackupID
+	}
+
+	return chain, nil
+}
+
+// Dependents returns the backups that directly use the given backup as their base
+func (c *Catalog) Dependents(ctx context.Context, id string) ([]*backup.BackupResult, error) {
+	return c.query(ctx, "WHERE base_backup_id = ?", id)
+}
+
+// Rebuild replaces the catalog entries of a storage with the backups found by
+// scanning the storage itself. It returns the number of backups indexed.
+func (c *Catalog) Rebuild(ctx context.Context, store storage.Provider, storageName string) (int, error) {
+	backups, err := backup.ScanStorage(ctx, store)
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan storage: %w", err)
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM backup_tables WHERE backup_id IN (SELECT id FROM backups WHERE storage = ?)",
+		storageName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear catalog: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM backups WHERE storage = ?", storageName); err != nil {
+		return 0, fmt.Errorf("failed to clear catalog: %w", err)
+	}
+
+	count := 0
+	for _, b := range backups {
+		if b.ID == "" {
+			continue
+		}
+		b.Storage = storageName
+		if err := record(ctx, tx, b); err != nil {
+			return 0, err
+		}
+		count++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit catalog: %w", err)
+	}
+
+	return count, nil
+}
+
+// query runs a SELECT on the backups table with the given WHERE clause
+func (c *Catalog) query(ctx context.Context, where string, args ...interface{}) ([]*backup.BackupResult, error) {
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT id, type, source_db, storage, storage_path, base_backup_id,
+			start_time, end_time, size, checksum, file_count,
+			is_compressed, success, error_message
+		FROM backups `+where+`
+		ORDER BY start_time`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query catalog: %w", err)
+	}
+	defer rows.Close()
+
+	var backups []*backup.BackupResult
+	for rows.Next() {
+		var (
+			b         backup.BackupResult
+			backupTyp string
+			startTime string
+			endTime   string
+		)
+		err := rows.Scan(
+			&b.ID, &backupTyp, &b.SourceDB, &b.Storage, &b.StoragePath, &b.BaseBackupID,
+			&startTime, &endTime, &b.Size, &b.Checksum, &b.FileCount,
+			&b.IsCompressed, &b.Success, &b.ErrorMessage,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan catalog row: %w", err)
+		}
+		b.Type = backup.BackupType(backupTyp)
+		b.StartTime, _ = time.Parse(time.RFC3339Nano, startTime)
+		b.EndTime, _ = time.Parse(time.RFC3339Nano, endTime)
+		backups = append(backups, &b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating catalog rows: %w", err)
+	}
+	rows.Close()
+
+	// Attach table lists
+	for _, b := range backups {
+		tables, err := c.tables(ctx, b.ID)
+		if err != nil {
+			return nil, err
+		}
+		b.Tables = tables
+	}
+
+	return backups, nil
+}
+
+// tables returns the tables recorded for a backup
+func (c *Catalog) tables(ctx context.Context, id string) ([]string, error) {
+	rows, err := c.db.QueryContext(ctx,
+		"SELECT table_name FROM backup_tables WHERE backup_id = ? ORDER BY table_name", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tables of backup %s: %w", id, err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, rows.Err()
+}
@@ -1,9 +1,18 @@
 package compression
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
 )
 
 // CompressionType represents the type of compression
@@ -12,15 +21,61 @@ type CompressionType string
 const (
 	// Gzip compression
 	Gzip CompressionType = "gzip"
+	// Zstd compression
+	Zstd CompressionType = "zstd"
+	// LZ4 compression
+	LZ4 CompressionType = "lz4"
+	// Xz compression
+	Xz CompressionType = "xz"
 	// None - no compression
 	None CompressionType = "none"
 )
 
+// LongDistanceWindow is the zstd window size used for large inputs,
+// equivalent to the window of `zstd --long=27`
+const LongDistanceWindow = 128 << 20
+
+// magicNumbers identifies compressed streams by their first bytes
+var magicNumbers = []struct {
+	Type  CompressionType
+	Magic []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b}},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{LZ4, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{Xz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// extensions maps compression types to their file extension
+var extensions = map[CompressionType]string{
+	Gzip: ".gz",
+	Zstd: ".zst",
+	LZ4:  ".lz4",
+	Xz:   ".xz",
+}
+
+// xzDictCaps are the xz dictionary sizes of presets 0-9
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
 // Compressor provides compression functionality
 type Compressor struct {
 	Type CompressionType
+	// Level is the algorithm-specific compression level; nil selects the
+	// default. gzip: 0-9, zstd: 1-22, lz4: 0-9, xz: 0-9.
+	Level *int
+	// LongDistance selects a zstd window of LongDistanceWindow, so matches
+	// far apart in large inputs are found. It only enlarges the window; the
+	// encoder has no separate long-distance matcher.
+	LongDistance bool
+	// Workers is the number of cores used to compress; values above 1 enable
+	// block-parallel compression for gzip, zstd and lz4. xz is always serial.
+	// The output can be read by the standard single-threaded decompressors.
+	Workers int
 }
 
+// parallelBlockSize is the size of the blocks compressed in parallel by gzip
+const parallelBlockSize = 1 << 20
+
 // NewCompressor creates a new compressor with the specified type
 func NewCompressor(compType CompressionType) *Compressor {
 	return &Compressor{
@@ -30,56 +85,213 @@ func NewCompressor(compType CompressionType) *Compressor {
 
 // Compress compresses data from a reader to a writer
 func (c *Compressor) Compress(r io.Reader, w io.Writer) error {
-	switch c.Type {
-	case Gzip:
-		return compressGzip(r, w)
-	case None:
-		_, err := io.Copy(w, r)
+	cw, err := c.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(cw, r); err != nil {
+		cw.Close()
 		return err
-	default:
-		return fmt.Errorf("unsupported compression type: %s", c.Type)
 	}
+	return cw.Close()
 }
 
 // Decompress decompresses data from a reader to a writer
 func (c *Compressor) Decompress(r io.Reader, w io.Writer) error {
+	cr, err := c.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer cr.Close()
+
+	_, err = io.Copy(w, cr)
+	return err
+}
+
+// NewWriter returns a writer that compresses everything written to it.
+// Close must be called to flush the compressed stream; it does not close w.
+func (c *Compressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
 	switch c.Type {
 	case Gzip:
-		return decompressGzip(r, w)
-	case None:
-		_, err := io.Copy(w, r)
-		return err
+		level := gzip.DefaultCompression
+		if c.Level != nil {
+			level = *c.Level
+		}
+		if c.Workers > 1 {
+			return newParallelGzipWriter(w, level, c.Workers)
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Zstd:
+		opts := []zstd.EOption{}
+		if c.Level != nil {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(*c.Level)))
+		}
+		if c.LongDistance {
+			opts = append(opts, zstd.WithWindowSize(LongDistanceWindow))
+		}
+		// The encoder uses every core unless told otherwise
+		workers := 1
+		if c.Workers > 1 {
+			workers = c.Workers
+		}
+		opts = append(opts, zstd.WithEncoderConcurrency(workers))
+		return zstd.NewWriter(w, opts...)
+	case LZ4:
+		zw := lz4.NewWriter(w)
+		if c.Level != nil {
+			level, err := lz4Level(*c.Level)
+			if err != nil {
+				return nil, err
+			}
+			if err := zw.Apply(lz4.CompressionLevelOption(level)); err != nil {
+				return nil, err
+			}
+		}
+		if c.Workers > 1 {
+			if err := zw.Apply(lz4.ConcurrencyOption(c.Workers)); err != nil {
+				return nil, err
+			}
+		}
+		return zw, nil
+	case Xz:
+		config := xz.WriterConfig{}
+		if c.Level != nil {
+			if *c.Level < 0 || *c.Level >= len(xzDictCaps) {
+				return nil, fmt.Errorf("invalid xz compression level: %d", *c.Level)
+			}
+			config.DictCap = xzDictCaps[*c.Level]
+		}
+		return config.NewWriter(w)
+	case None, "":
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression type: %s", c.Type)
+	}
+}
+
+// NewReader returns a reader that decompresses data read from r
+func (c *Compressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	switch c.Type {
+	case Gzip:
+		if c.Workers > 1 {
+			return pgzip.NewReaderN(r, parallelBlockSize, c.Workers)
+		}
+		return gzip.NewReader(r)
+	case Zstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderMaxWindow(LongDistanceWindow))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case LZ4:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	case Xz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case None, "":
+		return io.NopCloser(r), nil
 	default:
-		return fmt.Errorf("unsupported compression type: %s", c.Type)
+		return nil, fmt.Errorf("unsupported compression type: %s", c.Type)
 	}
 }
 
-// IsCompressed checks if a file is compressed
+// Extension returns the file extension for a compression type
+func Extension(compType CompressionType) string {
+	return extensions[compType]
+}
+
+// TrimExtension removes a known compression extension from a path
+func TrimExtension(path string) string {
+	for _, ext := range extensions {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext)
+		}
+	}
+	return path
+}
+
+// Detect identifies the compression of a stream from its first bytes
+func Detect(header []byte) CompressionType {
+	for _, m := range magicNumbers {
+		if bytes.HasPrefix(header, m.Magic) {
+			return m.Type
+		}
+	}
+	return None
+}
+
+// NewDetectingReader detects the compression of a stream by its magic bytes
+// and returns a reader that decompresses it
+func NewDetectingReader(r io.Reader) (io.ReadCloser, CompressionType, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, None, err
+	}
+
+	compType := Detect(header)
+	cr, err := NewCompressor(compType).NewReader(br)
+	if err != nil {
+		return nil, compType, err
+	}
+	return cr, compType, nil
+}
+
+// IsCompressed checks if a file is compressed, by its content if the file
+// can be read and by its extension otherwise
 func IsCompressed(filename string) (bool, CompressionType) {
-	// Simple extension-based check
-	if len(filename) > 3 && filename[len(filename)-3:] == ".gz" {
-		return true, Gzip
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+
+		header := make([]byte, 6)
+		n, _ := io.ReadFull(file, header)
+		compType := Detect(header[:n])
+		return compType != None, compType
+	}
+
+	for compType, ext := range extensions {
+		if strings.HasSuffix(filename, ext) {
+			return true, compType
+		}
 	}
 	return false, None
 }
 
-// compressGzip compresses data using gzip
-func compressGzip(r io.Reader, w io.Writer) error {
-	gw := gzip.NewWriter(w)
-	defer gw.Close()
-	
-	_, err := io.Copy(gw, r)
-	return err
+// lz4Level maps a numeric level to an lz4 compression level
+func lz4Level(level int) (lz4.CompressionLevel, error) {
+	levels := []lz4.CompressionLevel{
+		lz4.Fast, lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4,
+		lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+	}
+	if level < 0 || level >= len(levels) {
+		return lz4.Fast, fmt.Errorf("invalid lz4 compression level: %d", level)
+	}
+	return levels[level], nil
 }
 
-// decompressGzip decompresses gzip data
-func decompressGzip(r io.Reader, w io.Writer) error {
-	gr, err := gzip.NewReader(r)
+// newParallelGzipWriter returns a gzip writer that compresses blocks on
+// several cores. The result is a regular single-member gzip stream.
+func newParallelGzipWriter(w io.Writer, level, workers int) (io.WriteCloser, error) {
+	gw, err := pgzip.NewWriterLevel(w, level)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer gr.Close()
-	
-	_, err = io.Copy(w, gr)
-	return err
-} 
\ No newline at end of file
+	if err := gw.SetConcurrency(parallelBlockSize, workers); err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+// nopWriteCloser adds a no-op Close to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close implements io.Closer
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MySQL binary log event types read by scanBinlog
+const (
+	binlogGTIDEvent         = 33
+	binlogPreviousGTIDEvent = 35
+)
+
+const (
+	binlogHeaderSize = 19
+	binlogMagic      = "\xfebin"
+)
+
+// binlogInfo describes the events in a binary log file
+type binlogInfo struct {
+	FirstEvent time.Time
+	LastEvent  time.Time
+	Size       int64   // Offset after the last event
+	Previous   gtidSet // GTIDs executed before the file
+	Executed   gtidSet // GTIDs executed up to the end of the file
+}
+
+// scanBinlog reads the event headers of a binary log file. Only the
+// timestamps and GTIDs of events are decoded.
+func scanBinlog(r io.Reader) (*binlogInfo, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(binlogMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != binlogMagic {
+		return nil, fmt.Errorf("not a binary log file")
+	}
+
+	info := &binlogInfo{
+		Size:     int64(len(binlogMagic)),
+		Previous: gtidSet{},
+		Executed: gtidSet{},
+	}
+	header := make([]byte, binlogHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("truncated event at offset %d", info.Size)
+		}
+
+		timestamp := binary.LittleEndian.Uint32(header[0:4])
+		eventType := header[4]
+		length := binary.LittleEndian.Uint32(header[9:13])
+		if length < binlogHeaderSize {
+			return nil, fmt.Errorf("invalid event length %d at offset %d", length, info.Size)
+		}
+		body := make([]byte, length-binlogHeaderSize)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return nil, fmt.Errorf("truncated event at offset %d", info.Size)
+		}
+
+		switch eventType {
+		case binlogPreviousGTIDEvent:
+			previous, err := decodeGTIDSet(body)
+			if err != nil {
+				return nil, err
+			}
+			info.Previous = previous
+			info.Executed.union(previous)
+		case binlogGTIDEvent:
+			// commit flag, server UUID, transaction number
+			if len(body) < 25 {
+				return nil, fmt.Errorf("invalid GTID event at offset %d", info.Size)
+			}
+			gno := binary.LittleEndian.Uint64(body[17:25])
+			info.Executed.add(formatUUID(body[1:17]), gtidInterval{gno, gno + 1})
+		}
+
+		// Artificial events have no timestamp
+		if timestamp != 0 {
+			t := time.Unix(int64(timestamp), 0)
+			if info.FirstEvent.IsZero() {
+				info.FirstEvent = t
+			}
+			info.LastEvent = t
+		}
+		info.Size += int64(length)
+	}
+
+	return info, nil
+}
+
+// gtidInterval is a range of transaction numbers; End is exclusive
+type gtidInterval struct {
+	Start, End uint64
+}
+
+// gtidSet maps server UUIDs to sorted, non-overlapping intervals
+type gtidSet map[string][]gtidInterval
+
+// decodeGTIDSet decodes the binary GTID set of a Previous_gtids event
+func decodeGTIDSet(data []byte) (gtidSet, error) {
+	invalid := fmt.Errorf("invalid GTID set")
+	if len(data) < 8 {
+		return nil, invalid
+	}
+	set := gtidSet{}
+	count := binary.LittleEndian.Uint64(data)
+	data = data[8:]
+	for i := uint64(0); i < count; i++ {
+		if len(data) < 24 {
+			return nil, invalid
+		}
+		uuid := formatUUID(data[:16])
+		intervals := binary.LittleEndian.Uint64(data[16:24])
+		data = data[24:]
+		for j := uint64(0); j < intervals; j++ {
+			if len(data) < 16 {
+				return nil, invalid
+			}
+			set.add(uuid, gtidInterval{
+				Start: binary.LittleEndian.Uint64(data[0:8]),
+				End:   binary.LittleEndian.Uint64(data[8:16]),
+			})
+			data = data[16:]
+		}
+	}
+	return set, nil
+}
+
+// add merges an interval into the set
+func (s gtidSet) add(uuid string, interval gtidInterval) {
+	intervals := append(s[uuid], interval)
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].Start < intervals[j].Start
+	})
+
+	merged := intervals[:1]
+	for _, next := range intervals[1:] {
+		last := &merged[len(merged)-1]
+		if next.Start <= last.End {
+			if next.End > last.End {
+				last.End = next.End
+			}
+			continue
+		}
+		merged = append(merged, next)
+	}
+	s[uuid] = merged
+}
+
+// union merges another set into the set
+func (s gtidSet) union(other gtidSet) {
+	for uuid, intervals := range other {
+		for _, interval := range intervals {
+			s.add(uuid, interval)
+		}
+	}
+}
+
+// String formats the set the way MySQL does, e.g. "3e11fa47-...:1-5:7"
+func (s gtidSet) String() string {
+	uuids := make([]string, 0, len(s))
+	for uuid := range s {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+
+	parts := make([]string, 0, len(uuids))
+	for _, uuid := range uuids {
+		part := uuid
+		for _, interval := range s[uuid] {
+			part += ":" + strconv.FormatUint(interval.Start, 10)
+			if interval.End-1 > interval.Start {
+				part += "-" + strconv.FormatUint(interval.End-1, 10)
+			}
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// formatUUID formats a 16-byte server UUID
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"io"
+	"time"
 )
 
 // DBType represents a database type
@@ -32,15 +33,6 @@ type ConnectConfig struct {
 	Options  map[string]string
 }
 
-// DatabaseInfo contains information about a database
-type DatabaseInfo struct {
-	Name        string
-	Size        int64
-	Tables      []string
-	TableSizes  map[string]int64
-	LastUpdated string
-}
-
 // Connector is the interface for database connections and operations
 type Connector interface {
 	// Connect establishes a connection to the database
@@ -58,9 +50,121 @@ type Connector interface {
 	// ListTables returns a list of all tables in the database
 	ListTables(ctx context.Context) ([]string, error)
 	
-	// GetInfo returns information about the database
-	GetInfo(ctx context.Context) (*DatabaseInfo, error)
+	// GetInfo returns information about the database, such as its size
+	// and "version", as key/value pairs recorded in backup manifests
+	GetInfo(ctx context.Context) (map[string]string, error)
 	
 	// Type returns the database type
 	Type() DBType
-} 
\ No newline at end of file
+}
+
+// PageConnector is implemented by connectors whose database is a single file
+// of fixed-size pages, which enables page-level incremental backups
+type PageConnector interface {
+	// ReadPages calls fn with a reader over a consistent snapshot of the
+	// database file and the size of its pages
+	ReadPages(ctx context.Context, fn func(r io.Reader, pageSize int) error) error
+
+	// PageLevel reports whether backups are taken page by page; it is false
+	// when the connector is configured to dump SQL instead
+	PageLevel() bool
+} 
+// TableStateConnector is implemented by connectors that can fingerprint the
+// contents of tables, which enables differential backups of changed tables only
+type TableStateConnector interface {
+	// TableStates returns a hash of the schema and rows of each table
+	TableStates(ctx context.Context, tables []string) (map[string]string, error)
+
+	// DropTables removes tables, replaying tables dropped since a full backup
+	DropTables(ctx context.Context, tables []string) error
+}
+
+// TableDropper is implemented by connectors that can drop tables, which lets
+// a rollback remove the tables a failed restore created
+type TableDropper interface {
+	// DropTables removes tables, ignoring those that do not exist
+	DropTables(ctx context.Context, tables []string) error
+}
+
+// LogPosition identifies a point in the transaction log of a database
+type LogPosition struct {
+	Position string    `json:"position"`         // LSN, binary log file and offset, or oplog timestamp
+	File     string    `json:"file,omitempty"`   // Log file containing the position
+	Time     time.Time `json:"time"`
+	GTIDs    string    `json:"gtids,omitempty"` // GTID set executed up to the position (MySQL)
+}
+
+// PhysicalConnector is implemented by connectors that can copy the data
+// directory of a running server, the base of point-in-time recovery
+type PhysicalConnector interface {
+	// PhysicalBackup writes a tar archive of the data directory to w and
+	// returns the log position recovery has to replay from
+	PhysicalBackup(ctx context.Context, w io.Writer) (*LogPosition, error)
+}
+
+// LogReceiver is implemented by connectors that can stream their transaction
+// log (PostgreSQL WAL, MySQL binary logs) to disk as it is written
+type LogReceiver interface {
+	// ReceiveLogs writes log files into dir until ctx is cancelled. from
+	// names the first file to receive if dir is empty; empty means the
+	// current one.
+	ReceiveLogs(ctx context.Context, dir, from string) error
+
+	// LogComplete reports whether a file received into dir is complete
+	LogComplete(dir, name string) bool
+}
+
+// LogDescriber is implemented by connectors that can read the range of log
+// positions covered by a log file
+type LogDescriber interface {
+	// DescribeLog returns the first and last position of a log file
+	DescribeLog(path string) (start, end *LogPosition, err error)
+}
+
+// LogPositionDumper is implemented by connectors whose dumps are consistent
+// with a known log position, from which archived logs can be replayed
+type LogPositionDumper interface {
+	// DumpWithPosition is Backup that also returns the log position the
+	// dump is consistent with
+	DumpWithPosition(ctx context.Context, w io.Writer, tables []string) (*LogPosition, error)
+}
+
+// LogDumper is implemented by connectors that can write the changes recorded
+// in their transaction log as statements Restore replays
+type LogDumper interface {
+	// DumpLogs writes the log events after from to w and returns the
+	// position it stopped at
+	DumpLogs(ctx context.Context, w io.Writer, from *LogPosition) (*LogPosition, error)
+}
+
+// LogReplayer is implemented by connectors that can replay archived log
+// files on top of a restored backup
+type LogReplayer interface {
+	// ReplayLogs applies the events in files, which are in log order, from
+	// the position from up to the time until
+	ReplayLogs(ctx context.Context, files []string, from *LogPosition, until time.Time) error
+}
+
+// DatabaseCreator is implemented by connectors of database servers that can
+// create a new database to restore backups into
+type DatabaseCreator interface {
+	// CreateDatabase creates an empty database on the server, unless it
+	// already exists
+	CreateDatabase(ctx context.Context, name string) error
+}
+
+// Retargeter is implemented by connectors whose backups name the database
+// they were taken from, which has to be replaced when they are restored into
+// another database
+type Retargeter interface {
+	// RestoreFrom makes Restore and ReplayLogs put the contents of backups
+	// of the database source into the database of the connector
+	RestoreFrom(source string)
+}
+
+// TableRestorer is implemented by connectors that can restore some of the
+// tables of a backup and leave the other tables of the database alone
+type TableRestorer interface {
+	// RestoreTables restores the listed tables from a backup read from r
+	RestoreTables(ctx context.Context, r io.Reader, tables []string) error
+}
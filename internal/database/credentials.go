@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// toolCommand runs a database client tool. Credentials are never put on the
+// command line, where other users can read them: they are passed in
+// temporary files only the current user can read, or in environment
+// variables added to the inherited environment.
+type toolCommand struct {
+	*exec.Cmd
+	files []string
+}
+
+// newToolCommand creates a client tool command that inherits the environment
+// of the process
+func newToolCommand(ctx context.Context, name string, args ...string) *toolCommand {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = os.Environ()
+	return &toolCommand{Cmd: cmd}
+}
+
+// credentialFile writes contents to a new temporary file that only the
+// current user can read and returns its path. The file is removed by Cleanup,
+// or right away if it cannot be written, since callers then do not return the
+// command to clean up.
+func (t *toolCommand) credentialFile(pattern, contents string) (string, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create credentials file: %w", err)
+	}
+
+	// CreateTemp already uses 0600; make sure a umask cannot widen it
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to protect credentials file: %w", err)
+	}
+	if _, err := file.WriteString(contents); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	t.files = append(t.files, file.Name())
+	return file.Name(), nil
+}
+
+// setEnv adds an environment variable for the tool
+func (t *toolCommand) setEnv(name, value string) {
+	t.Env = append(t.Env, name+"="+value)
+}
+
+// Cleanup removes the credential files of the command
+func (t *toolCommand) Cleanup() {
+	for _, path := range t.files {
+		os.Remove(path)
+	}
+	t.files = nil
+}
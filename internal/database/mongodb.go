@@ -5,11 +5,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// defaultOplogInterval is how often new oplog entries are dumped while
+// receiving the oplog, unless the "oplog_interval" option says otherwise
+const defaultOplogInterval = time.Minute
+
 // MongoDBConnector implements the Connector interface for MongoDB databases
 type MongoDBConnector struct {
 	host     string
@@ -17,7 +25,10 @@ type MongoDBConnector struct {
 	user     string
 	password string
 	dbname   string
+	source   string // Database the restored backups were taken from
 	uri      string
+	options  map[string]string
+	oplog    bool // Dump the whole replica set with its oplog
 }
 
 // Connect establishes a connection to the MongoDB database
@@ -27,22 +38,14 @@ func (c *MongoDBConnector) Connect(ctx context.Context, config ConnectConfig) er
 	c.user = config.User
 	c.password = config.Password
 	c.dbname = config.Database
+	c.options = config.Options
+	c.oplog = config.Options["oplog"] == "true"
 
 	// Build MongoDB URI
-	if c.user != "" && c.password != "" {
-		c.uri = fmt.Sprintf("mongodb://%s:%s@%s:%d/%s", c.user, c.password, c.host, c.port, c.dbname)
-	} else {
-		c.uri = fmt.Sprintf("mongodb://%s:%d/%s", c.host, c.port, c.dbname)
-	}
+	c.uri = c.connectionURI(c.dbname, nil)
 
 	// Test connection
-	args := []string{
-		"--uri", c.uri,
-		"--eval", "db.runCommand({ping: 1})",
-	}
-
-	cmd := exec.CommandContext(ctx, "mongosh", args...)
-	if err := cmd.Run(); err != nil {
+	if _, err := c.shell(ctx, c.uri, "db.runCommand({ping: 1})"); err != nil {
 		return fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
 
@@ -55,21 +58,30 @@ func (c *MongoDBConnector) Close() error {
 	return nil
 }
 
-// Backup dumps the database to a writer
+// Backup dumps the database to a writer. With the "oplog" option, the whole
+// replica set is dumped together with the oplog entries written during the
+// dump, so the backup is consistent; collections are then ignored.
 func (c *MongoDBConnector) Backup(ctx context.Context, w io.Writer, collections []string) error {
+	uri := c.uri
 	args := []string{
-		"--uri", c.uri,
 		"--archive",
 		"--gzip",
 	}
 
-	if len(collections) > 0 {
+	if c.oplog {
+		uri = c.instanceURI()
+		args = append(args, "--oplog")
+	} else if len(collections) > 0 {
 		for _, collection := range collections {
 			args = append(args, "--collection", collection)
 		}
 	}
 
-	cmd := exec.CommandContext(ctx, "mongodump", args...)
+	cmd, err := c.command(ctx, "mongodump", uri, args...)
+	if err != nil {
+		return err
+	}
+	defer cmd.Cleanup()
 	cmd.Stdout = w
 
 	if err := cmd.Run(); err != nil {
@@ -81,6 +93,31 @@ func (c *MongoDBConnector) Backup(ctx context.Context, w io.Writer, collections
 
 // Restore restores the database from a reader
 func (c *MongoDBConnector) Restore(ctx context.Context, r io.Reader) error {
+	return c.restoreArchive(ctx, r)
+}
+
+// RestoreTables restores the listed collections from a dump and leaves the
+// other collections alone
+func (c *MongoDBConnector) RestoreTables(ctx context.Context, r io.Reader, collections []string) error {
+	if c.oplog {
+		return fmt.Errorf("oplog backups restore the whole replica set and cannot be restored collection by collection")
+	}
+
+	// Namespaces are matched before they are renamed
+	source := c.dbname
+	if c.renamed() {
+		source = c.source
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `*`, `\*`)
+	args := make([]string, 0, len(collections))
+	for _, collection := range collections {
+		args = append(args, "--nsInclude="+escape.Replace(source)+"."+escape.Replace(collection))
+	}
+	return c.restoreArchive(ctx, r, args...)
+}
+
+// restoreArchive restores a dump with mongorestore, with additional arguments
+func (c *MongoDBConnector) restoreArchive(ctx context.Context, r io.Reader, extra ...string) error {
 	if c.uri == "" {
 		return fmt.Errorf("database connection not initialized")
 	}
@@ -106,15 +143,38 @@ func (c *MongoDBConnector) Restore(ctx context.Context, r io.Reader) error {
 		return fmt.Errorf("failed to seek temporary file: %w", err)
 	}
 
+	// Oplog dumps cover the whole replica set and end with the oplog
+	// entries written while they ran
+	uri := c.uri
+	if c.oplog {
+		if c.renamed() {
+			return fmt.Errorf("oplog backups restore the whole replica set and cannot be restored into another database")
+		}
+		uri = c.instanceURI()
+	}
+
 	// Create mongorestore command
 	args := []string{
-		"--uri", c.uri,
 		"--archive=" + tmpFile.Name(),
 		"--gzip",
 		"--drop", // Drop collections before restoring
 	}
+	if c.oplog {
+		args = append(args, "--oplogReplay")
+	}
+	if c.renamed() {
+		args = append(args,
+			"--nsFrom="+c.source+".$collection$",
+			"--nsTo="+c.dbname+".$collection$",
+		)
+	}
+	args = append(args, extra...)
 
-	cmd := exec.CommandContext(ctx, "mongorestore", args...)
+	cmd, err := c.command(ctx, "mongorestore", uri, args...)
+	if err != nil {
+		return err
+	}
+	defer cmd.Cleanup()
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
@@ -132,14 +192,7 @@ func (c *MongoDBConnector) ListTables(ctx context.Context) ([]string, error) {
 
 	// Create mongosh command to list collections
 	query := `db.getCollectionNames().join('\n')`
-	args := []string{
-		"--uri", c.uri,
-		"--quiet",
-		"--eval", query,
-	}
-
-	cmd := exec.CommandContext(ctx, "mongosh", args...)
-	output, err := cmd.Output()
+	output, err := c.shell(ctx, c.uri, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list collections: %w", err)
 	}
@@ -153,6 +206,23 @@ func (c *MongoDBConnector) ListTables(ctx context.Context) ([]string, error) {
 	return collections, nil
 }
 
+// DropTables removes collections from the database
+func (c *MongoDBConnector) DropTables(ctx context.Context, collections []string) error {
+	if c.uri == "" {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	names, err := json.Marshal(collections)
+	if err != nil {
+		return fmt.Errorf("failed to encode collection names: %w", err)
+	}
+	script := "for (const name of " + string(names) + ") { db.getCollection(name).drop(); }"
+	if _, err := c.shell(ctx, c.uri, script); err != nil {
+		return fmt.Errorf("failed to drop collections: %w", err)
+	}
+	return nil
+}
+
 // GetInfo returns information about the database
 func (c *MongoDBConnector) GetInfo(ctx context.Context) (map[string]string, error) {
 	if c.uri == "" {
@@ -173,14 +243,7 @@ func (c *MongoDBConnector) GetInfo(ctx context.Context) (map[string]string, erro
 			version: buildInfo.version
 		}))
 	`
-	args := []string{
-		"--uri", c.uri,
-		"--quiet",
-		"--eval", statsQuery,
-	}
-
-	cmd := exec.CommandContext(ctx, "mongosh", args...)
-	output, err := cmd.Output()
+	output, err := c.shell(ctx, c.uri, statsQuery)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database info: %w", err)
 	}
@@ -207,6 +270,330 @@ func (c *MongoDBConnector) GetInfo(ctx context.Context) (map[string]string, erro
 	return info, nil
 }
 
+// instanceURI returns a connection string for the whole deployment, which
+// oplog dumps need; the configured database is only used to authenticate
+func (c *MongoDBConnector) instanceURI() string {
+	if c.user != "" && c.password != "" {
+		return c.connectionURI("", url.Values{"authSource": {c.dbname}})
+	}
+	return c.connectionURI("", nil)
+}
+
+// connectionURI returns a connection string for a database, with the
+// credentials escaped
+func (c *MongoDBConnector) connectionURI(dbname string, query url.Values) string {
+	uri := url.URL{
+		Scheme:   "mongodb",
+		Host:     net.JoinHostPort(c.host, strconv.Itoa(c.port)),
+		Path:     "/" + dbname,
+		RawQuery: query.Encode(),
+	}
+	if c.user != "" && c.password != "" {
+		uri.User = url.UserPassword(c.user, c.password)
+	}
+	return uri.String()
+}
+
+// command prepares mongodump or mongorestore connected with uri. The
+// connection string holds the password, so it is passed in a config file.
+func (c *MongoDBConnector) command(ctx context.Context, name, uri string, args ...string) (*toolCommand, error) {
+	cmd := newToolCommand(ctx, name)
+	path, err := cmd.credentialFile("mongodb-*.yaml", "uri: "+strconv.Quote(uri)+"\n")
+	if err != nil {
+		return nil, err
+	}
+	cmd.Args = append(append(cmd.Args, "--config="+path), args...)
+	return cmd, nil
+}
+
+// shell runs a mongosh script connected with uri and returns its output. The
+// script connects itself with the connection string read from a credentials
+// file, since the environment of a process can be read by other users too.
+func (c *MongoDBConnector) shell(ctx context.Context, uri, script string) ([]byte, error) {
+	cmd := newToolCommand(ctx, "mongosh", "--nodb", "--quiet")
+	path, err := cmd.credentialFile("mongosh-*.uri", uri)
+	if err != nil {
+		return nil, err
+	}
+	defer cmd.Cleanup()
+
+	quoted, err := json.Marshal(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode credentials file path: %w", err)
+	}
+	cmd.Args = append(cmd.Args, "--eval",
+		"db = connect(require('fs').readFileSync("+string(quoted)+", 'utf8'));\n"+script)
+	return cmd.Output()
+}
+
+// oplogTimestamp is the position of an entry in the oplog
+type oplogTimestamp struct {
+	T uint32 // Seconds since the epoch
+	I uint32 // Ordinal within the second
+}
+
+func (ts oplogTimestamp) String() string {
+	return fmt.Sprintf("%d:%d", ts.T, ts.I)
+}
+
+// before reports whether ts comes before other
+func (ts oplogTimestamp) before(other oplogTimestamp) bool {
+	return ts.T < other.T || (ts.T == other.T && ts.I < other.I)
+}
+
+// oplogSliceName names the file holding the oplog entries after start up to
+// and including end. Names sort in oplog order.
+func oplogSliceName(start, end oplogTimestamp) string {
+	return fmt.Sprintf("oplog-%010d.%010d-%010d.%010d.bson", start.T, start.I, end.T, end.I)
+}
+
+// parseOplogSliceName returns the range of oplog entries in a slice file
+func parseOplogSliceName(name string) (start, end oplogTimestamp, err error) {
+	_, err = fmt.Sscanf(name, "oplog-%d.%d-%d.%d.bson", &start.T, &start.I, &end.T, &end.I)
+	if err != nil || oplogSliceName(start, end) != name {
+		return start, end, fmt.Errorf("invalid oplog slice name %q", name)
+	}
+	return start, end, nil
+}
+
+// oplogRange returns the timestamps of the oldest and newest oplog entries
+func (c *MongoDBConnector) oplogRange(ctx context.Context) (first, last oplogTimestamp, err error) {
+	query := `
+		const oplog = db.getSiblingDB('local').oplog.rs;
+		const first = oplog.find({}, {ts: 1}).sort({$natural: 1}).limit(1).next().ts;
+		const last = oplog.find({}, {ts: 1}).sort({$natural: -1}).limit(1).next().ts;
+		print(first.getHighBits() + ' ' + first.getLowBits() + ' ' + last.getHighBits() + ' ' + last.getLowBits());
+	`
+	output, err := c.shell(ctx, c.instanceURI(), query)
+	if err != nil {
+		return first, last, fmt.Errorf("failed to read oplog position: %w", err)
+	}
+	if _, err := fmt.Sscan(string(output), &first.T, &first.I, &last.T, &last.I); err != nil {
+		return first, last, fmt.Errorf("unexpected oplog position %q", strings.TrimSpace(string(output)))
+	}
+	return first, last, nil
+}
+
+// DumpWithPosition dumps the database like Backup and returns the oplog
+// position the dump can be replayed from. The position is read before the
+// dump starts; oplog entries are idempotent, so replaying entries the dump
+// already contains is harmless. Its time is when the dump ended, the moment
+// the restored dump is consistent with. Without the "oplog" option the dump
+// has no position.
+func (c *MongoDBConnector) DumpWithPosition(ctx context.Context, w io.Writer, collections []string) (*LogPosition, error) {
+	if !c.oplog {
+		return nil, c.Backup(ctx, w, collections)
+	}
+
+	_, start, err := c.oplogRange(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Backup(ctx, w, collections); err != nil {
+		return nil, err
+	}
+
+	// Oplog timestamps have a resolution of one second
+	return &LogPosition{
+		Position: start.String(),
+		Time:     time.Now().Truncate(time.Second),
+	}, nil
+}
+
+// ReceiveLogs tails the oplog into dir until ctx is cancelled, writing the
+// entries added since the previous slice to a new BSON file every
+// "oplog_interval" (one minute by default). from names the newest archived
+// slice to continue after; without it the oplog is followed from its end.
+func (c *MongoDBConnector) ReceiveLogs(ctx context.Context, dir, from string) error {
+	if !c.oplog {
+		return fmt.Errorf("receiving the oplog requires the \"oplog\" option")
+	}
+
+	interval := defaultOplogInterval
+	if value := c.options["oplog_interval"]; value != "" {
+		var err error
+		if interval, err = time.ParseDuration(value); err != nil {
+			return fmt.Errorf("invalid oplog_interval: %w", err)
+		}
+	}
+
+	// Continue after the newest slice, spooled or archived
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read oplog directory: %w", err)
+	}
+	for _, entry := range entries {
+		if c.LogComplete(dir, entry.Name()) && entry.Name() > from {
+			from = entry.Name()
+		}
+	}
+	var last oplogTimestamp
+	if from != "" {
+		if _, last, err = parseOplogSliceName(from); err != nil {
+			return err
+		}
+	} else if _, last, err = c.oplogRange(ctx); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		first, end, err := c.oplogRange(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if last.before(first) {
+			return fmt.Errorf("the oplog no longer contains entries after %s; take a new full backup", last)
+		}
+		if last.before(end) {
+			if err := c.dumpOplogSlice(ctx, dir, last, end); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			last = end
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// dumpOplogSlice writes the oplog entries after start up to and including
+// end to a slice file in dir. The file only appears once it is complete.
+func (c *MongoDBConnector) dumpOplogSlice(ctx context.Context, dir string, start, end oplogTimestamp) error {
+	tmpDir, err := os.MkdirTemp(dir, ".slice-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	query := fmt.Sprintf(`{"ts": {"$gt": {"$timestamp": {"t": %d, "i": %d}}, "$lte": {"$timestamp": {"t": %d, "i": %d}}}}`,
+		start.T, start.I, end.T, end.I)
+	args := []string{
+		"--db", "local",
+		"--collection", "oplog.rs",
+		"--query", query,
+		"--out", tmpDir,
+	}
+
+	cmd, err := c.command(ctx, "mongodump", c.instanceURI(), args...)
+	if err != nil {
+		return err
+	}
+	defer cmd.Cleanup()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mongodump of the oplog failed: %w", err)
+	}
+
+	slice := filepath.Join(tmpDir, "local", "oplog.rs.bson")
+	if err := os.Rename(slice, filepath.Join(dir, oplogSliceName(start, end))); err != nil {
+		return fmt.Errorf("failed to store oplog slice: %w", err)
+	}
+	return nil
+}
+
+// LogComplete reports whether a file in the oplog directory is a slice;
+// slices are written elsewhere and moved in once complete
+func (c *MongoDBConnector) LogComplete(dir, name string) bool {
+	_, _, err := parseOplogSliceName(name)
+	return err == nil
+}
+
+// DescribeLog returns the oplog positions a slice file starts after and ends at
+func (c *MongoDBConnector) DescribeLog(path string) (*LogPosition, *LogPosition, error) {
+	start, end, err := parseOplogSliceName(filepath.Base(path))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &LogPosition{Position: start.String(), Time: time.Unix(int64(start.T), 0)},
+		&LogPosition{Position: end.String(), Time: time.Unix(int64(end.T), 0)},
+		nil
+}
+
+// RestoreFrom makes Restore put the contents of backups of the database
+// source into the database of the connector
+func (c *MongoDBConnector) RestoreFrom(source string) {
+	c.source = source
+}
+
+// renamed reports whether backups are restored into another database than
+// the one they were taken from
+func (c *MongoDBConnector) renamed() bool {
+	return c.source != "" && c.source != c.dbname
+}
+
+// ReplayLogs applies the oplog entries in slice files with mongorestore
+// --oplogReplay, stopping before the first entry at or after until. Entries
+// from before from are applied again, which is harmless as they are idempotent.
+func (c *MongoDBConnector) ReplayLogs(ctx context.Context, files []string, from *LogPosition, until time.Time) error {
+	if len(files) == 0 {
+		return nil
+	}
+	if c.renamed() {
+		return fmt.Errorf("the oplog cannot be replayed into another database")
+	}
+
+	// mongorestore replays a single oplog file; BSON files can be concatenated
+	oplog, err := os.CreateTemp("", "mongodb-oplog-*.bson")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(oplog.Name())
+	defer oplog.Close()
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open oplog slice: %w", err)
+		}
+		_, err = io.Copy(oplog, file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write oplog: %w", err)
+		}
+	}
+	if err := oplog.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+
+	// mongorestore needs a dump directory, even an empty one
+	emptyDir, err := os.MkdirTemp("", "mongodb-replay-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(emptyDir)
+
+	args := []string{
+		"--oplogReplay",
+		"--oplogFile=" + oplog.Name(),
+	}
+	if !until.IsZero() {
+		args = append(args, fmt.Sprintf("--oplogLimit=%d:0", until.Unix()))
+	}
+	args = append(args, emptyDir)
+
+	cmd, err := c.command(ctx, "mongorestore", c.instanceURI(), args...)
+	if err != nil {
+		return err
+	}
+	defer cmd.Cleanup()
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mongorestore oplog replay failed: %w", err)
+	}
+
+	return nil
+}
+
 // Type returns the database type
 func (c *MongoDBConnector) Type() DBType {
 	return MongoDB
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestOplogSliceName(t *testing.T) {
+	tests := []struct {
+		start, end oplogTimestamp
+		want       string
+	}{
+		{oplogTimestamp{0, 0}, oplogTimestamp{1, 0}, "oplog-0000000000.0000000000-0000000001.0000000000.bson"},
+		{oplogTimestamp{1715774400, 3}, oplogTimestamp{1715774460, 12}, "oplog-1715774400.0000000003-1715774460.0000000012.bson"},
+		{oplogTimestamp{4294967295, 4294967295}, oplogTimestamp{4294967295, 4294967295}, "oplog-4294967295.4294967295-4294967295.4294967295.bson"},
+	}
+	for _, tt := range tests {
+		name := oplogSliceName(tt.start, tt.end)
+		if name != tt.want {
+			t.Errorf("oplogSliceName(%v, %v) = %s, want %s", tt.start, tt.end, name, tt.want)
+		}
+		start, end, err := parseOplogSliceName(name)
+		if err != nil || start != tt.start || end != tt.end {
+			t.Errorf("parseOplogSliceName(%s) = %v, %v, %v", name, start, end, err)
+		}
+	}
+}
+
+func TestOplogSliceNamesSort(t *testing.T) {
+	// Names sort in oplog order, so archived slices replay in order
+	timestamps := []oplogTimestamp{{9, 5}, {10, 0}, {10, 2}, {10, 10}, {100, 1}, {1715774400, 0}}
+	names := make([]string, len(timestamps)-1)
+	for i := range names {
+		names[i] = oplogSliceName(timestamps[i], timestamps[i+1])
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	for i := range names {
+		if sorted[i] != names[i] {
+			t.Fatalf("slices sort as %v, want %v", sorted, names)
+		}
+	}
+
+	for i := 0; i+1 < len(timestamps); i++ {
+		if !timestamps[i].before(timestamps[i+1]) || timestamps[i+1].before(timestamps[i]) {
+			t.Errorf("%v is not before %v", timestamps[i], timestamps[i+1])
+		}
+	}
+	if (oplogTimestamp{10, 2}).before(oplogTimestamp{10, 2}) {
+		t.Error("a timestamp is before itself")
+	}
+}
+
+func TestParseOplogSliceNameErrors(t *testing.T) {
+	invalid := []string{
+		"",
+		"oplog.rs.bson",
+		".slice-123",
+		"oplog-1.2-3.4.bson",
+		"oplog-0000000001.0000000002-0000000003.0000000004.bson.tmp",
+		"oplog-0000000001.0000000002-0000000003.0000000004",
+		"oplog-0000000001.0000000002_0000000003.0000000004.bson",
+		"oplog-00000000x1.0000000002-0000000003.0000000004.bson",
+	}
+	c := &MongoDBConnector{}
+	for _, name := range invalid {
+		if _, _, err := parseOplogSliceName(name); err == nil {
+			t.Errorf("parseOplogSliceName(%q) succeeded", name)
+		}
+		if c.LogComplete("", name) {
+			t.Errorf("LogComplete(%q) is true", name)
+		}
+	}
+}
+
+func TestDescribeOplogSlice(t *testing.T) {
+	c := &MongoDBConnector{}
+	name := oplogSliceName(oplogTimestamp{1715774400, 3}, oplogTimestamp{1715774460, 12})
+	if !c.LogComplete("", name) {
+		t.Errorf("LogComplete(%q) is false", name)
+	}
+
+	start, end, err := c.DescribeLog("/archive/mongodb/shop/logs/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start.Position != "1715774400:3" || !start.Time.Equal(time.Unix(1715774400, 0)) {
+		t.Errorf("start = %+v", start)
+	}
+	if end.Position != "1715774460:12" || !end.Time.Equal(time.Unix(1715774460, 0)) {
+		t.Errorf("end = %+v", end)
+	}
+}
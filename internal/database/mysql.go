@@ -1,20 +1,36 @@
 package database
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
-	"os/exec"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
-// MySQLConnector implements the Connector interface for MySQL databases
+// MySQLConnector implements the Connector interface for MySQL databases.
+// Metadata queries use a pooled connection; dumps and restores use the
+// MySQL client tools.
 type MySQLConnector struct {
+	db       *sql.DB
 	host     string
 	port     int
 	user     string
 	password string
 	dbname   string
+	source   string // Database the restored backups were taken from
+	options  map[string]string
 }
 
 // Connect establishes a connection to the MySQL database
@@ -24,38 +40,43 @@ func (c *MySQLConnector) Connect(ctx context.Context, config ConnectConfig) erro
 	c.user = config.User
 	c.password = config.Password
 	c.dbname = config.Database
+	c.options = config.Options
 
-	// Test connection
-	args := []string{
-		"-h", c.host,
-		"-P", fmt.Sprintf("%d", c.port),
-		"-u", c.user,
-		"-p" + c.password,
-		"-e", "SELECT 1",
-		c.dbname,
+	dsn := mysql.NewConfig()
+	dsn.User = c.user
+	dsn.Passwd = c.password
+	dsn.Net = "tcp"
+	dsn.Addr = net.JoinHostPort(c.host, strconv.Itoa(c.port))
+	dsn.DBName = c.dbname
+	db, err := sql.Open("mysql", dsn.FormatDSN())
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
 	}
 
-	cmd := exec.CommandContext(ctx, "mysql", args...)
-	if err := cmd.Run(); err != nil {
+	// Retire connections before the server's wait_timeout closes them
+	db.SetConnMaxLifetime(3 * time.Minute)
+
+	// Check connection with context
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return fmt.Errorf("failed to connect to MySQL: %w", err)
 	}
 
+	c.db = db
 	return nil
 }
 
 // Close terminates the database connection
 func (c *MySQLConnector) Close() error {
-	// No persistent connection to close in this implementation
+	if c.db != nil {
+		return c.db.Close()
+	}
 	return nil
 }
 
 // Backup dumps the database to a writer
 func (c *MySQLConnector) Backup(ctx context.Context, w io.Writer, tables []string) error {
 	args := []string{
-		"-h", c.host,
-		"-P", fmt.Sprintf("%d", c.port),
-		"-u", c.user,
-		"-p" + c.password,
 		"--single-transaction",
 		"--routines",
 		"--triggers",
@@ -69,7 +90,11 @@ func (c *MySQLConnector) Backup(ctx context.Context, w io.Writer, tables []strin
 		args = append(args, tables...)
 	}
 
-	cmd := exec.CommandContext(ctx, "mysqldump", args...)
+	cmd, err := c.command(ctx, "mysqldump", args...)
+	if err != nil {
+		return err
+	}
+	defer cmd.Cleanup()
 	cmd.Stdout = w
 
 	if err := cmd.Run(); err != nil {
@@ -79,19 +104,310 @@ func (c *MySQLConnector) Backup(ctx context.Context, w io.Writer, tables []strin
 	return nil
 }
 
-// Restore restores the database from a reader
-func (c *MySQLConnector) Restore(ctx context.Context, r io.Reader) error {
+// binlogPositionPattern matches the binary log position mysqldump records
+// with --master-data=2
+var binlogPositionPattern = regexp.MustCompile(`CHANGE (?:MASTER|REPLICATION SOURCE) TO (?:MASTER|SOURCE)_LOG_FILE='([^']+)', (?:MASTER|SOURCE)_LOG_POS=(\d+)`)
+
+// gtidPurgedPattern matches the executed GTID set mysqldump records on
+// servers with GTIDs enabled
+var gtidPurgedPattern = regexp.MustCompile(`SET @@GLOBAL\.GTID_PURGED=(?:/\*!80000 '\+'\*/ )?'([^']*)'`)
+
+// dumpHeaderLimit is how much of the start of a dump is searched for the
+// binary log position
+const dumpHeaderLimit = 1 << 20
+
+// dumpHeader passes a dump through while keeping its start, and notes when
+// the binary log position has been written
+type dumpHeader struct {
+	w          io.Writer
+	buf        bytes.Buffer
+	positioned time.Time
+}
+
+func (h *dumpHeader) Write(p []byte) (int, error) {
+	if room := dumpHeaderLimit - h.buf.Len(); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		h.buf.Write(p[:room])
+		if h.positioned.IsZero() && binlogPositionPattern.Match(h.buf.Bytes()) {
+			h.positioned = time.Now()
+		}
+	}
+	return h.w.Write(p)
+}
+
+// DumpWithPosition dumps the database like Backup and returns the binary
+// log position the dump is consistent with. This needs the RELOAD and
+// REPLICATION CLIENT privileges.
+func (c *MySQLConnector) DumpWithPosition(ctx context.Context, w io.Writer, tables []string) (*LogPosition, error) {
 	args := []string{
-		"-h", c.host,
-		"-P", fmt.Sprintf("%d", c.port),
-		"-u", c.user,
-		"-p" + c.password,
-		c.dbname,
+		"--single-transaction",
+		"--master-data=2",
+		"--routines",
+		"--triggers",
+		"--events",
+		"--add-drop-database",
+		"--databases", c.dbname,
+	}
+
+	if len(tables) > 0 {
+		args = append(args, "--tables")
+		args = append(args, tables...)
+	}
+
+	header := &dumpHeader{w: w}
+	cmd, err := c.command(ctx, "mysqldump", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer cmd.Cleanup()
+	cmd.Stdout = header
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("mysqldump failed: %w", err)
 	}
 
-	cmd := exec.CommandContext(ctx, "mysql", args...)
+	match := binlogPositionPattern.FindSubmatch(header.buf.Bytes())
+	if match == nil {
+		return nil, fmt.Errorf("mysqldump did not record a binary log position; is binary logging enabled?")
+	}
+	position := &LogPosition{
+		File:     string(match[1]),
+		Position: string(match[2]),
+		Time:     header.positioned,
+	}
+	if gtids := gtidPurgedPattern.FindSubmatch(header.buf.Bytes()); gtids != nil {
+		position.GTIDs = strings.Join(strings.Fields(string(gtids[1])), "")
+	}
+	return position, nil
+}
+
+// DumpLogs writes the binary log events of the database after from as SQL
+// and returns the position it stopped at. GTIDs are left out, so the events
+// apply as new transactions on top of a restored dump.
+func (c *MySQLConnector) DumpLogs(ctx context.Context, w io.Writer, from *LogPosition) (*LogPosition, error) {
+	end, err := c.binlogStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := c.query(ctx, "SHOW BINARY LOGS")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list binary logs: %w", err)
+	}
+	var files []string
+	for _, row := range logs {
+		name := row[0]
+		if name >= from.File && name <= end.File {
+			files = append(files, name)
+		}
+	}
+	if len(files) == 0 || files[0] != from.File {
+		return nil, fmt.Errorf("binary log %s is no longer on the server", from.File)
+	}
+
+	args := []string{
+		"--read-from-remote-server",
+		"--database=" + c.dbname,
+		"--skip-gtids",
+		"--start-position=" + from.Position,
+		"--stop-position=" + end.Position,
+	}
+	args = append(args, files...)
+
+	cmd, err := c.command(ctx, "mysqlbinlog", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer cmd.Cleanup()
+	cmd.Stdout = w
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("mysqlbinlog failed: %w", err)
+	}
+
+	return end, nil
+}
+
+// binlogStatus returns the current binary log position of the server
+func (c *MySQLConnector) binlogStatus(ctx context.Context) (*LogPosition, error) {
+	status, err := c.query(ctx, "SHOW MASTER STATUS")
+	if err != nil {
+		// MySQL 8.4 renamed the statement
+		if status, err = c.query(ctx, "SHOW BINARY LOG STATUS"); err != nil {
+			return nil, fmt.Errorf("failed to get binary log status: %w", err)
+		}
+	}
+
+	// File, Position, Binlog_Do_DB, Binlog_Ignore_DB, Executed_Gtid_Set
+	if len(status) == 0 || len(status[0]) < 2 || status[0][0] == "" {
+		return nil, fmt.Errorf("binary logging is not enabled")
+	}
+	parts := status[0]
+	position := &LogPosition{
+		File:     parts[0],
+		Position: parts[1],
+		Time:     time.Now(),
+	}
+	if len(parts) >= 5 {
+		position.GTIDs = strings.ReplaceAll(parts[4], "\n", "")
+	}
+	return position, nil
+}
+
+// ReceiveLogs copies binary log files into dir with mysqlbinlog as the server
+// writes them, until ctx is cancelled. A file cut short by a restart is
+// received again from its start. The "binlog_server_id" option sets the
+// server ID mysqlbinlog connects as, which must be unique among replicas.
+func (c *MySQLConnector) ReceiveLogs(ctx context.Context, dir, from string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read binary log directory: %w", err)
+	}
+	if len(entries) > 0 {
+		from = entries[len(entries)-1].Name()
+	}
+	if from == "" {
+		status, err := c.binlogStatus(ctx)
+		if err != nil {
+			return err
+		}
+		from = status.File
+	}
+
+	args := []string{
+		"--read-from-remote-server",
+		"--raw",
+		"--stop-never",
+		"--result-file=" + dir + string(os.PathSeparator),
+	}
+	if id := c.options["binlog_server_id"]; id != "" {
+		args = append(args, "--connection-server-id="+id)
+	}
+	args = append(args, from)
+
+	cmd, err := c.command(ctx, "mysqlbinlog", args...)
+	if err != nil {
+		return err
+	}
+	defer cmd.Cleanup()
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("mysqlbinlog failed: %w", err)
+	}
+
+	return nil
+}
+
+// LogComplete reports whether a binary log file has been received
+// completely, which is once the server has moved on to a newer file
+func (c *MySQLConnector) LogComplete(dir, name string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	return len(entries) > 0 && entries[len(entries)-1].Name() > name
+}
+
+// DescribeLog returns the positions, times and GTID sets at the start and
+// end of a binary log file
+func (c *MySQLConnector) DescribeLog(path string) (*LogPosition, *LogPosition, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open binary log: %w", err)
+	}
+	defer file.Close()
+
+	info, err := scanBinlog(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read binary log: %w", err)
+	}
+
+	name := filepath.Base(path)
+	start := &LogPosition{
+		File:     name,
+		Position: fmt.Sprintf("%d", len(binlogMagic)),
+		Time:     info.FirstEvent,
+		GTIDs:    info.Previous.String(),
+	}
+	end := &LogPosition{
+		File:     name,
+		Position: fmt.Sprintf("%d", info.Size),
+		Time:     info.LastEvent,
+		GTIDs:    info.Executed.String(),
+	}
+	return start, end, nil
+}
+
+// ReplayLogs applies the events of the database in archived binary log
+// files, starting at from and stopping before the first event after until.
+// GTIDs are left out, so the events apply as new transactions.
+func (c *MySQLConnector) ReplayLogs(ctx context.Context, files []string, from *LogPosition, until time.Time) error {
+	if len(files) == 0 {
+		return nil
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
+
+	args := []string{
+		"--database=" + c.dbname,
+		"--skip-gtids",
+		"--start-position=" + from.Position,
+	}
+	if c.renamed() {
+		// --database applies to the rewritten name
+		args = append(args, "--rewrite-db="+c.source+"->"+c.dbname)
+	}
+	if !until.IsZero() {
+		// mysqlbinlog reads the time in the local time zone
+		args = append(args, "--stop-datetime="+until.Local().Format("2006-01-02 15:04:05"))
+	}
+	args = append(args, files...)
+
+	pr, pw := io.Pipe()
+	cmd := newToolCommand(ctx, "mysqlbinlog", args...)
+	cmd.Stdout = pw
+	errCh := make(chan error, 1)
+	go func() {
+		err := cmd.Run()
+		pw.CloseWithError(err)
+		errCh <- err
+	}()
+
+	if err := c.Restore(ctx, pr); err != nil {
+		pr.CloseWithError(err)
+		<-errCh
+		return fmt.Errorf("failed to replay binary logs: %w", err)
+	}
+	if err := <-errCh; err != nil {
+		return fmt.Errorf("mysqlbinlog failed: %w", err)
+	}
+	return nil
+}
+
+// Restore restores the database from a reader
+func (c *MySQLConnector) Restore(ctx context.Context, r io.Reader) error {
+	cmd, err := c.command(ctx, "mysql", c.dbname)
+	if err != nil {
+		return err
+	}
+	defer cmd.Cleanup()
 	cmd.Stdin = r
 
+	// Dumps create and select the database they were taken from
+	if c.renamed() {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(renameDatabase(pw, r, c.source, c.dbname))
+		}()
+		defer pr.Close()
+		cmd.Stdin = pr
+	}
+
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("mysql restore failed: %w", err)
 	}
@@ -99,58 +415,293 @@ func (c *MySQLConnector) Restore(ctx context.Context, r io.Reader) error {
 	return nil
 }
 
-// ListTables returns a list of all tables in the database
-func (c *MySQLConnector) ListTables(ctx context.Context) ([]string, error) {
-	args := []string{
-		"-h", c.host,
-		"-P", fmt.Sprintf("%d", c.port),
-		"-u", c.user,
-		"-p" + c.password,
-		"-N", // Skip column names
-		"-B", // Batch mode (tab-separated)
-		c.dbname,
-		"-e", "SHOW TABLES",
+// RestoreTables restores the listed tables from a dump, with their triggers.
+// The database itself, its views, routines and events and its other tables
+// are left alone.
+func (c *MySQLConnector) RestoreTables(ctx context.Context, r io.Reader, tables []string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(filterDump(pw, r, tables))
+	}()
+	defer pr.Close()
+	return c.Restore(ctx, pr)
+}
+
+// dumpSectionPattern matches the comments mysqldump starts every table, view,
+// routine and event section of a dump with
+var dumpSectionPattern = regexp.MustCompile("^-- (Table structure for table|Dumping data for table|Temporary view structure for view|Final view structure for view|Dumping routines for database|Dumping events for database|Current Database:) (`(?:[^`]|``)*`|'[^']*')")
+
+// dropDatabasePattern matches the statement --add-drop-database adds
+var dropDatabasePattern = regexp.MustCompile(`(?i)^(/\*!\d+ )?DROP DATABASE `)
+
+// filterDump copies the statements of a dump from r to w that belong to the
+// listed tables, together with the statements before the first table that
+// set up the session and select the database
+func filterDump(w io.Writer, r io.Reader, tables []string) error {
+	selected := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		selected[table] = true
+	}
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	lineStart, keep := true, true
+	for {
+		// Section comments and DROP DATABASE are short and start a line
+		line, err := br.ReadSlice('\n')
+		if lineStart {
+			if m := dumpSectionPattern.FindSubmatch(line); m != nil {
+				switch string(m[1]) {
+				case "Table structure for table", "Dumping data for table":
+					keep = selected[unquoteMySQLIdentifier(string(m[2]))]
+				case "Current Database:":
+					keep = true
+				default:
+					keep = false
+				}
+			}
+		}
+		if keep && !(lineStart && dropDatabasePattern.Match(line)) {
+			if _, werr := w.Write(line); werr != nil {
+				return werr
+			}
+		}
+		lineStart = err == nil
+		switch err {
+		case nil, bufio.ErrBufferFull:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// RestoreFrom makes Restore and ReplayLogs put the contents of backups of
+// the database source into the database of the connector
+func (c *MySQLConnector) RestoreFrom(source string) {
+	c.source = source
+}
+
+// renamed reports whether backups are restored into another database than
+// the one they were taken from
+func (c *MySQLConnector) renamed() bool {
+	return c.source != "" && c.source != c.dbname
+}
+
+// databaseStatementPattern matches the statements of dumps and binary log
+// output that drop, create or select a database
+var databaseStatementPattern = regexp.MustCompile(`(?i)^(/\*!\d+ )?(DROP DATABASE|CREATE DATABASE|USE) `)
+
+// renameDatabase copies a dump from r to w, replacing the database from with
+// to in the statements that drop, create or select it
+func renameDatabase(w io.Writer, r io.Reader, from, to string) error {
+	quotedFrom, quotedTo := []byte(quoteMySQLIdentifier(from)), []byte(quoteMySQLIdentifier(to))
+	br := bufio.NewReaderSize(r, 64*1024)
+	lineStart := true
+	for {
+		// Rows can make lines longer than the buffer; database statements
+		// are always at the start of a line and short
+		line, err := br.ReadSlice('\n')
+		if lineStart && databaseStatementPattern.Match(line) {
+			line = bytes.Replace(line, quotedFrom, quotedTo, 1)
+		}
+		if _, werr := w.Write(line); werr != nil {
+			return werr
+		}
+		lineStart = err == nil
+		switch err {
+		case nil, bufio.ErrBufferFull:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
 	}
+}
 
-	cmd := exec.CommandContext(ctx, "mysql", args...)
-	output, err := cmd.Output()
+// ListTables returns a list of all tables in the database
+func (c *MySQLConnector) ListTables(ctx context.Context) ([]string, error) {
+	rows, err := c.query(ctx, "SHOW TABLES")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list tables: %w", err)
 	}
 
-	tables := strings.Split(strings.TrimSpace(string(output)), "\n")
+	tables := make([]string, 0, len(rows))
+	for _, row := range rows {
+		tables = append(tables, row[0])
+	}
 	return tables, nil
 }
 
 // GetInfo returns information about the database
-func (c *MySQLConnector) GetInfo(ctx context.Context) (*DatabaseInfo, error) {
-	args := []string{
-		"-h", c.host,
-		"-P", fmt.Sprintf("%d", c.port),
-		"-u", c.user,
-		"-p" + c.password,
-		"-N", // Skip column names
-		"-B", // Batch mode (tab-separated)
-		c.dbname,
-		"-e", "SELECT ROUND(SUM(data_length + index_length) / 1024 / 1024, 2) as size, version() as version FROM information_schema.tables WHERE table_schema = DATABASE() GROUP BY version",
+func (c *MySQLConnector) GetInfo(ctx context.Context) (map[string]string, error) {
+	if c.db == nil {
+		return nil, fmt.Errorf("database connection not initialized")
 	}
 
-	cmd := exec.CommandContext(ctx, "mysql", args...)
-	output, err := cmd.Output()
-	if err != nil {
+	var sizeBytes, tableCount int64
+	var version string
+	query := "SELECT COALESCE(SUM(data_length + index_length), 0), COUNT(*), version() " +
+		"FROM information_schema.tables WHERE table_schema = DATABASE()"
+	if err := c.db.QueryRowContext(ctx, query).Scan(&sizeBytes, &tableCount, &version); err != nil {
 		return nil, fmt.Errorf("failed to get database info: %w", err)
 	}
 
-	parts := strings.Split(strings.TrimSpace(string(output)), "\t")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("unexpected output format from database")
+	return map[string]string{
+		"size":        fmt.Sprintf("%.2f MB", float64(sizeBytes)/1024/1024),
+		"size_bytes":  fmt.Sprintf("%d", sizeBytes),
+		"version":     version,
+		"table_count": fmt.Sprintf("%d", tableCount),
+	}, nil
+}
+
+// TableStates returns the checksum and column definitions of each table
+func (c *MySQLConnector) TableStates(ctx context.Context, tables []string) (map[string]string, error) {
+	states := make(map[string]string, len(tables))
+	if len(tables) == 0 {
+		return states, nil
+	}
+
+	quoted := make([]string, len(tables))
+	for i, table := range tables {
+		quoted[i] = quoteMySQLIdentifier(table)
 	}
 
-	return &DatabaseInfo{
-		Size:    fmt.Sprintf("%s MB", parts[0]),
-		Version: parts[1],
-		Type:    MySQL,
-	}, nil
+	// Row checksums
+	checksums, err := c.query(ctx, "CHECKSUM TABLE "+strings.Join(quoted, ", "))
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum tables: %w", err)
+	}
+	for _, row := range checksums {
+		if len(row) != 2 {
+			continue
+		}
+		table := strings.TrimPrefix(row[0], c.dbname+".")
+		states[table] = row[1]
+	}
+
+	// Column definitions, so schema changes are detected as well
+	schemas, err := c.query(ctx, "SELECT table_name, MD5(GROUP_CONCAT(column_name, ' ', column_type ORDER BY ordinal_position)) "+
+		"FROM information_schema.columns WHERE table_schema = DATABASE() GROUP BY table_name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get table schemas: %w", err)
+	}
+	for _, row := range schemas {
+		if len(row) != 2 {
+			continue
+		}
+		if state, ok := states[row[0]]; ok {
+			states[row[0]] = state + ":" + row[1]
+		}
+	}
+
+	return states, nil
+}
+
+// DropTables removes tables from the database
+func (c *MySQLConnector) DropTables(ctx context.Context, tables []string) error {
+	if len(tables) == 0 {
+		return nil
+	}
+	if c.db == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	quoted := make([]string, len(tables))
+	for i, table := range tables {
+		quoted[i] = quoteMySQLIdentifier(table)
+	}
+
+	if _, err := c.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+strings.Join(quoted, ", ")); err != nil {
+		return fmt.Errorf("failed to drop tables: %w", err)
+	}
+	return nil
+}
+
+// CreateDatabase creates an empty database on the server, unless it already
+// exists
+func (c *MySQLConnector) CreateDatabase(ctx context.Context, name string) error {
+	if c.db == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if _, err := c.db.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS "+quoteMySQLIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", name, err)
+	}
+	return nil
+}
+
+// query runs a statement on the connection and returns every column of
+// every row as text, with NULL as an empty string
+func (c *MySQLConnector) query(ctx context.Context, query string) ([][]string, error) {
+	if c.db == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result [][]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make([]string, len(columns))
+		for i, value := range values {
+			row[i] = value.String
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// command prepares a MySQL client tool connected to the server. The password
+// is passed in an option file, which must be the first argument.
+func (c *MySQLConnector) command(ctx context.Context, name string, args ...string) (*toolCommand, error) {
+	cmd := newToolCommand(ctx, name)
+	connection := []string{
+		"-h", c.host,
+		"-P", fmt.Sprintf("%d", c.port),
+		"-u", c.user,
+	}
+	if c.password != "" {
+		path, err := cmd.credentialFile("mysql-*.cnf", "[client]\npassword="+quoteMySQLOption(c.password)+"\n")
+		if err != nil {
+			return nil, err
+		}
+		connection = append([]string{"--defaults-extra-file=" + path}, connection...)
+	}
+	cmd.Args = append(append(cmd.Args, connection...), args...)
+	return cmd, nil
+}
+
+// quoteMySQLOption quotes a value for a MySQL option file
+func quoteMySQLOption(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// quoteMySQLIdentifier quotes a table or column name
+func quoteMySQLIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// unquoteMySQLIdentifier reverses quoteMySQLIdentifier
+func unquoteMySQLIdentifier(quoted string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(strings.TrimPrefix(quoted, "`"), "`"), "``", "`")
 }
 
 // Type returns the database type
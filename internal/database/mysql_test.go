@@ -0,0 +1,155 @@
+package database
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Sections of a dump written by mysqldump --databases --add-drop-database
+// --routines. Each section ends with the "--" line that opens the comment
+// of the next one, which belongs to the section before it when filtering.
+const (
+	testDumpHeader = "-- MySQL dump 10.13  Distrib 8.0.36\n" +
+		"--\n" +
+		"-- Host: localhost    Database: shop\n" +
+		"-- ------------------------------------------------------\n" +
+		"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+		"SET NAMES utf8mb4;\n" +
+		"\n" +
+		"--\n" +
+		"-- Current Database: `shop`\n" +
+		"--\n" +
+		"\n"
+	testDumpDropDatabase = "/*!40000 DROP DATABASE IF EXISTS `shop`*/;\n"
+	testDumpUseDatabase  = "\n" +
+		"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `shop` /*!40100 DEFAULT CHARACTER SET utf8mb4 */;\n" +
+		"\n" +
+		"USE `shop`;\n" +
+		"\n" +
+		"--\n"
+	testDumpOrders = "-- Table structure for table `orders`\n" +
+		"--\n" +
+		"\n" +
+		"DROP TABLE IF EXISTS `orders`;\n" +
+		"CREATE TABLE `orders` (`id` int NOT NULL, `note` text);\n" +
+		"\n" +
+		"--\n" +
+		"-- Dumping data for table `orders`\n" +
+		"--\n" +
+		"\n" +
+		"LOCK TABLES `orders` WRITE;\n" +
+		"INSERT INTO `orders` VALUES (1,'a note\\n-- Table structure for table `users`\\nUSE `shop`;');\n" +
+		"UNLOCK TABLES;\n" +
+		"\n" +
+		"--\n"
+	testDumpOddName = "-- Table structure for table `odd``name`\n" +
+		"--\n" +
+		"\n" +
+		"DROP TABLE IF EXISTS `odd``name`;\n" +
+		"CREATE TABLE `odd``name` (`x` int);\n" +
+		"\n" +
+		"--\n"
+	testDumpUsers = "-- Table structure for table `users`\n" +
+		"--\n" +
+		"\n" +
+		"DROP TABLE IF EXISTS `users`;\n" +
+		"CREATE TABLE `users` (`id` int NOT NULL);\n" +
+		"\n" +
+		"--\n" +
+		"-- Dumping data for table `users`\n" +
+		"--\n" +
+		"\n" +
+		"INSERT INTO `users` VALUES (1),(2);\n" +
+		"\n" +
+		"--\n"
+	testDumpView = "-- Temporary view structure for view `recent`\n" +
+		"--\n" +
+		"\n" +
+		"DROP TABLE IF EXISTS `recent`;\n" +
+		"\n" +
+		"--\n"
+	testDumpRoutines = "-- Dumping routines for database 'shop'\n" +
+		"--\n" +
+		"/*!50003 DROP PROCEDURE IF EXISTS `cleanup` */;\n" +
+		"\n" +
+		"-- Dump completed on 2024-05-15 12:00:00\n"
+)
+
+func TestFilterDump(t *testing.T) {
+	dump := testDumpHeader + testDumpDropDatabase + testDumpUseDatabase + testDumpOrders + testDumpOddName + testDumpUsers + testDumpView + testDumpRoutines
+
+	tests := []struct {
+		name   string
+		tables []string
+		want   string
+	}{
+		{"one table", []string{"orders"}, testDumpHeader + testDumpUseDatabase + testDumpOrders},
+		{"quoted name", []string{"odd`name", "users"}, testDumpHeader + testDumpUseDatabase + testDumpOddName + testDumpUsers},
+		{"missing table", []string{"gone"}, testDumpHeader + testDumpUseDatabase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := filterDump(&out, strings.NewReader(dump), tt.tables); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterDumpLongLines(t *testing.T) {
+	// Rows longer than the read buffer, with section markers where the
+	// buffer splits them, stay whole and do not switch sections
+	long := "INSERT INTO `orders` VALUES (1,'" + strings.Repeat("x", 64*1024-len("INSERT INTO `orders` VALUES (1,'")) +
+		"-- Table structure for table `users`" + strings.Repeat("y", 100*1024) + "');\n"
+	orders := strings.Replace(testDumpOrders, "UNLOCK TABLES;\n", long+"UNLOCK TABLES;\n", 1)
+	dump := testDumpHeader + testDumpUseDatabase + orders + testDumpUsers
+
+	var out bytes.Buffer
+	if err := filterDump(&out, strings.NewReader(dump), []string{"orders"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := testDumpHeader + testDumpUseDatabase + orders; out.String() != want {
+		t.Errorf("got %d bytes, want %d", out.Len(), len(want))
+	}
+}
+
+func TestRenameDatabase(t *testing.T) {
+	dump := testDumpHeader + testDumpDropDatabase + testDumpUseDatabase + testDumpOrders
+
+	var out bytes.Buffer
+	if err := renameDatabase(&out, strings.NewReader(dump), "shop", "shop`copy"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the statements that drop, create or select the database change;
+	// rows that mention it are left alone
+	want := testDumpHeader +
+		"/*!40000 DROP DATABASE IF EXISTS `shop``copy`*/;\n" +
+		"\n" +
+		"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `shop``copy` /*!40100 DEFAULT CHARACTER SET utf8mb4 */;\n" +
+		"\n" +
+		"USE `shop``copy`;\n" +
+		"\n" +
+		"--\n" +
+		testDumpOrders
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestMySQLIdentifierQuoting(t *testing.T) {
+	for _, name := range []string{"plain", "with`tick", "``", "sp ace"} {
+		quoted := quoteMySQLIdentifier(name)
+		if got := unquoteMySQLIdentifier(quoted); got != name {
+			t.Errorf("unquote(quote(%q)) = %q", name, got)
+		}
+	}
+	if got := quoteMySQLIdentifier("a`b"); got != "`a``b`" {
+		t.Errorf("quote(a`b) = %s", got)
+	}
+}
@@ -2,21 +2,31 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
-	"os/exec"
+	"strconv"
 	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL driver
 )
 
-// PostgreSQLConnector implements the Connector interface for PostgreSQL databases
+// PostgreSQLConnector implements the Connector interface for PostgreSQL databases.
+// Metadata queries use a pooled connection; dumps and restores use the
+// PostgreSQL client tools.
 type PostgreSQLConnector struct {
+	db       *sql.DB
 	host     string
 	port     int
 	user     string
 	password string
 	dbname   string
 	sslmode  string
+	options  map[string]string
 }
 
 // Connect establishes a connection to the PostgreSQL database
@@ -27,27 +37,38 @@ func (c *PostgreSQLConnector) Connect(ctx context.Context, config ConnectConfig)
 	c.password = config.Password
 	c.dbname = config.Database
 	c.sslmode = "disable" // Default to disable, can be made configurable
+	if config.SSLMode != "" {
+		c.sslmode = config.SSLMode
+	}
+	c.options = config.Options
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.user, c.password),
+		Host:     net.JoinHostPort(c.host, strconv.Itoa(c.port)),
+		Path:     "/" + c.dbname,
+		RawQuery: url.Values{"sslmode": {c.sslmode}}.Encode(),
+	}
+	db, err := sql.Open("pgx", dsn.String())
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
 
-	// Test connection using psql
-	cmd := exec.CommandContext(ctx, "psql",
-		"-h", c.host,
-		"-p", fmt.Sprintf("%d", c.port),
-		"-U", c.user,
-		"-d", c.dbname,
-		"-c", "SELECT 1")
-	
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", c.password))
-	
-	if err := cmd.Run(); err != nil {
+	// Check connection with context
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
 	}
 
+	c.db = db
 	return nil
 }
 
 // Close terminates the database connection
 func (c *PostgreSQLConnector) Close() error {
-	// No persistent connection to close in this implementation
+	if c.db != nil {
+		return c.db.Close()
+	}
 	return nil
 }
 
@@ -65,12 +86,20 @@ func (c *PostgreSQLConnector) Backup(ctx context.Context, w io.Writer, tables []
 	}
 
 	if len(tables) > 0 {
-		args = append(args, "-t")
-		args = append(args, strings.Join(tables, " -t "))
+		// Tables are listed from the public schema. Quoted, pg_dump matches
+		// the name exactly instead of as a pattern, and --strict-names fails
+		// the dump when a table is gone instead of leaving it out.
+		args = append(args, "--strict-names")
+		for _, table := range tables {
+			args = append(args, "-t", "public."+quotePostgresIdentifier(table))
+		}
 	}
 
-	cmd := exec.CommandContext(ctx, "pg_dump", args...)
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", c.password))
+	cmd, err := c.command(ctx, "pg_dump", args...)
+	if err != nil {
+		return err
+	}
+	defer cmd.Cleanup()
 	cmd.Stdout = w
 
 	if err := cmd.Run(); err != nil {
@@ -97,8 +126,11 @@ func (c *PostgreSQLConnector) Restore(ctx context.Context, r io.Reader) error {
 		"--if-exists", // Don't error if object doesn't exist
 	}
 
-	cmd := exec.CommandContext(ctx, "pg_restore", args...)
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", c.password))
+	cmd, err := c.command(ctx, "pg_restore", args...)
+	if err != nil {
+		return err
+	}
+	defer cmd.Cleanup()
 	cmd.Stdin = r
 	cmd.Stderr = os.Stderr
 
@@ -111,34 +143,26 @@ func (c *PostgreSQLConnector) Restore(ctx context.Context, r io.Reader) error {
 
 // ListTables returns a list of all tables in the database
 func (c *PostgreSQLConnector) ListTables(ctx context.Context) ([]string, error) {
-	if c.host == "" {
+	if c.db == nil {
 		return nil, fmt.Errorf("database connection not initialized")
 	}
 
-	// Create psql command to list tables
-	query := `SELECT tablename FROM pg_tables WHERE schemaname = 'public';`
-	args := []string{
-		"-h", c.host,
-		"-p", fmt.Sprintf("%d", c.port),
-		"-U", c.user,
-		"-d", c.dbname,
-		"-t", // Tuple only output
-		"-A", // Unaligned output mode
-		"-c", query,
-	}
-
-	cmd := exec.CommandContext(ctx, "psql", args...)
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", c.password))
-
-	output, err := cmd.Output()
+	rows, err := c.db.QueryContext(ctx, "SELECT tablename FROM pg_tables WHERE schemaname = 'public' ORDER BY tablename;")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list tables: %w", err)
 	}
-
-	// Parse output
-	tables := strings.Split(strings.TrimSpace(string(output)), "\n")
-	if len(tables) == 1 && tables[0] == "" {
-		return []string{}, nil
+	defer rows.Close()
+
+	tables := []string{}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating table rows: %w", err)
 	}
 
 	return tables, nil
@@ -146,86 +170,203 @@ func (c *PostgreSQLConnector) ListTables(ctx context.Context) ([]string, error)
 
 // GetInfo returns information about the database
 func (c *PostgreSQLConnector) GetInfo(ctx context.Context) (map[string]string, error) {
-	if c.host == "" {
+	if c.db == nil {
 		return nil, fmt.Errorf("database connection not initialized")
 	}
 
-	info := make(map[string]string)
-
-	// Get database size
-	sizeQuery := `
-		SELECT pg_size_pretty(pg_database_size(current_database())) as size,
-			   pg_database_size(current_database()) as size_bytes;
+	var size, version string
+	var sizeBytes, tableCount int64
+	query := `
+		SELECT pg_size_pretty(pg_database_size(current_database())),
+			   pg_database_size(current_database()),
+			   version(),
+			   (SELECT count(*) FROM pg_tables WHERE schemaname = 'public');
 	`
-	args := []string{
-		"-h", c.host,
-		"-p", fmt.Sprintf("%d", c.port),
-		"-U", c.user,
-		"-d", c.dbname,
-		"-t", // Tuple only output
-		"-A", // Unaligned output mode
-		"-c", sizeQuery,
+	if err := c.db.QueryRowContext(ctx, query).Scan(&size, &sizeBytes, &version, &tableCount); err != nil {
+		return nil, fmt.Errorf("failed to get database info: %w", err)
+	}
+
+	return map[string]string{
+		"size":        size,
+		"size_bytes":  fmt.Sprintf("%d", sizeBytes),
+		"version":     version,
+		"table_count": fmt.Sprintf("%d", tableCount),
+	}, nil
+}
+
+// TableStates returns a fingerprint of the column definitions and rows of each
+// table: the row count and the sum of a 64-bit hash of every row. The sum is
+// computed while scanning, so tables of any size are never held in memory.
+func (c *PostgreSQLConnector) TableStates(ctx context.Context, tables []string) (map[string]string, error) {
+	if c.db == nil {
+		return nil, fmt.Errorf("database connection not initialized")
 	}
 
-	cmd := exec.CommandContext(ctx, "psql", args...)
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", c.password))
+	states := make(map[string]string, len(tables))
+	for _, table := range tables {
+		query := fmt.Sprintf(`SELECT
+			(SELECT coalesce(md5(string_agg(column_name || ' ' || data_type, ',' ORDER BY ordinal_position)), '')
+				FROM information_schema.columns WHERE table_schema = 'public' AND table_name = $1)
+			|| ':' ||
+			(SELECT count(*) || '/' || coalesce(sum(('x' || left(md5(t::text), 16))::bit(64)::bigint::numeric), 0) FROM public.%s t);`,
+			quotePostgresIdentifier(table))
+
+		var state string
+		if err := c.db.QueryRowContext(ctx, query, table).Scan(&state); err != nil {
+			return nil, fmt.Errorf("failed to hash table %s: %w", table, err)
+		}
+		states[table] = state
+	}
 
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get database size: %w", err)
+	return states, nil
+}
+
+// DropTables removes tables from the database
+func (c *PostgreSQLConnector) DropTables(ctx context.Context, tables []string) error {
+	if c.db == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(tables))
+	for i, table := range tables {
+		quoted[i] = "public." + quotePostgresIdentifier(table)
+	}
+
+	if _, err := c.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+strings.Join(quoted, ", ")+" CASCADE;"); err != nil {
+		return fmt.Errorf("failed to drop tables: %w", err)
+	}
+	return nil
+}
+
+// CreateDatabase creates an empty database on the server, unless it already
+// exists
+func (c *PostgreSQLConnector) CreateDatabase(ctx context.Context, name string) error {
+	if c.db == nil {
+		return fmt.Errorf("database connection not initialized")
 	}
 
-	parts := strings.Split(strings.TrimSpace(string(output)), "|")
-	if len(parts) >= 2 {
-		info["size"] = parts[0]
-		info["size_bytes"] = parts[1]
+	var exists bool
+	if err := c.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1);", name).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check database %s: %w", name, err)
+	}
+	if exists {
+		return nil
 	}
 
-	// Get version information
-	versionQuery := `SELECT version();`
-	args = []string{
+	// CREATE DATABASE cannot take parameters
+	if _, err := c.db.ExecContext(ctx, "CREATE DATABASE "+quotePostgresIdentifier(name)+";"); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", name, err)
+	}
+	return nil
+}
+
+// PhysicalBackup copies the data directory with pg_basebackup. The tar archive
+// includes the WAL needed to make the copy consistent; replaying archived WAL
+// from the returned position recovers later changes.
+func (c *PostgreSQLConnector) PhysicalBackup(ctx context.Context, w io.Writer) (*LogPosition, error) {
+	if c.db == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	// Record where the backup starts in the WAL
+	start := &LogPosition{}
+	query := "SELECT pg_current_wal_lsn()::text, pg_walfile_name(pg_current_wal_lsn()), now();"
+	if err := c.db.QueryRowContext(ctx, query).Scan(&start.Position, &start.File, &start.Time); err != nil {
+		return nil, fmt.Errorf("failed to get WAL position: %w", err)
+	}
+	start.Time = start.Time.UTC()
+
+	args := []string{
 		"-h", c.host,
 		"-p", fmt.Sprintf("%d", c.port),
 		"-U", c.user,
-		"-d", c.dbname,
-		"-t", // Tuple only output
-		"-A", // Unaligned output mode
-		"-c", versionQuery,
+		"-D", "-", // Write the archive to stdout
+		"-F", "t", // Tar format
+		"-X", "fetch", // Include the WAL written during the backup
+		"-c", "fast", // Start with an immediate checkpoint
+		"-l", "backyardBackup",
 	}
 
-	cmd = exec.CommandContext(ctx, "psql", args...)
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", c.password))
-
-	output, err = cmd.Output()
+	cmd, err := c.command(ctx, "pg_basebackup", args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get version info: %w", err)
+		return nil, err
 	}
+	defer cmd.Cleanup()
+	cmd.Stdout = w
 
-	info["version"] = strings.TrimSpace(string(output))
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pg_basebackup failed: %w", err)
+	}
+
+	return start, nil
+}
 
-	// Get table count
-	tableCountQuery := `SELECT count(*) FROM pg_tables WHERE schemaname = 'public';`
-	args = []string{
+// ReceiveLogs streams WAL segments into dir with pg_receivewal until ctx is
+// cancelled. pg_receivewal picks its start position itself, so from is
+// ignored. The "wal_slot" option selects a replication slot, which keeps the
+// server from recycling WAL that has not been received yet.
+func (c *PostgreSQLConnector) ReceiveLogs(ctx context.Context, dir, from string) error {
+	if c.host == "" {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	args := []string{
 		"-h", c.host,
 		"-p", fmt.Sprintf("%d", c.port),
 		"-U", c.user,
-		"-d", c.dbname,
-		"-t", // Tuple only output
-		"-A", // Unaligned output mode
-		"-c", tableCountQuery,
+		"-D", dir,
+		"--synchronous", // Flush WAL to disk as soon as it is received
+	}
+	if slot := c.options["wal_slot"]; slot != "" {
+		args = append(args, "-S", slot)
 	}
 
-	cmd = exec.CommandContext(ctx, "psql", args...)
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", c.password))
-
-	output, err = cmd.Output()
+	cmd, err := c.command(ctx, "pg_receivewal", args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get table count: %w", err)
+		return err
+	}
+	defer cmd.Cleanup()
+	cmd.Stderr = os.Stderr
+	// Let pg_receivewal flush the current segment before it exits
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = 10 * time.Second
+
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("pg_receivewal failed: %w", err)
 	}
 
-	info["table_count"] = strings.TrimSpace(string(output))
+	return nil
+}
+
+// LogComplete reports whether a WAL segment has been received completely;
+// pg_receivewal renames segments once they are complete
+func (c *PostgreSQLConnector) LogComplete(dir, name string) bool {
+	return !strings.HasSuffix(name, ".partial")
+}
+
+// command prepares a PostgreSQL client tool. The password is passed in a
+// password file named by PGPASSFILE.
+func (c *PostgreSQLConnector) command(ctx context.Context, name string, args ...string) (*toolCommand, error) {
+	cmd := newToolCommand(ctx, name, args...)
+	if c.password != "" {
+		escape := strings.NewReplacer(`\`, `\\`, ":", `\:`)
+		path, err := cmd.credentialFile("pgpass-*", fmt.Sprintf("*:*:*:%s:%s\n", escape.Replace(c.user), escape.Replace(c.password)))
+		if err != nil {
+			return nil, err
+		}
+		cmd.setEnv("PGPASSFILE", path)
+	}
+	return cmd, nil
+}
 
-	return info, nil
+// quotePostgresIdentifier quotes a table or column name
+func quotePostgresIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
 }
 
 // Type returns the database type
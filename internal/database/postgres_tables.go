@@ -0,0 +1,282 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// tocEntryPattern matches an entry of the table of contents pg_restore -l
+// lists: dump ID, catalog and object OIDs, then the type, schema, name and
+// owner of the object
+var tocEntryPattern = regexp.MustCompile(`^\d+; \d+ \d+ (.*)$`)
+
+// tocTypes are the types of table of contents entries that belong to a
+// table, longest first so that prefixes match the right type
+var tocTypes = []string{
+	"SEQUENCE OWNED BY",
+	"FK CONSTRAINT",
+	"SEQUENCE SET",
+	"TABLE DATA",
+	"CONSTRAINT",
+	"SEQUENCE",
+	"TRIGGER",
+	"COMMENT",
+	"DEFAULT",
+	"POLICY",
+	"INDEX",
+	"TABLE",
+	"RULE",
+	"ACL",
+}
+
+// schemaHeaderPattern matches the comment pg_restore writes before every
+// object of a schema-only script
+var schemaHeaderPattern = regexp.MustCompile(`(?m)^-- Name: (.*); Type: (.*); Schema: (.*); Owner: .*$`)
+
+// postgresIdentifier matches a possibly quoted identifier
+const postgresIdentifier = `("(?:[^"]|"")+"|[^\s."(]+)`
+
+// postgresTable matches a schema-qualified table name
+const postgresTable = postgresIdentifier + `\.` + postgresIdentifier
+
+// Statements that name the table an object belongs to
+var (
+	alterTablePattern    = regexp.MustCompile(`ALTER TABLE (?:ONLY )?` + postgresTable)
+	indexTablePattern    = regexp.MustCompile(`CREATE (?:UNIQUE )?INDEX .*? ON (?:ONLY )?` + postgresTable)
+	triggerTablePattern  = regexp.MustCompile(`(?s)CREATE (?:CONSTRAINT )?TRIGGER .*? ON ` + postgresTable)
+	policyTablePattern   = regexp.MustCompile(`(?s)CREATE POLICY .*? ON ` + postgresTable)
+	ruleTablePattern     = regexp.MustCompile(`(?s)CREATE RULE .*? TO ` + postgresTable)
+	commentTablePattern  = regexp.MustCompile(`COMMENT ON (?:TABLE|COLUMN) ` + postgresTable)
+	grantTablePattern    = regexp.MustCompile(`(?:GRANT|REVOKE) .*? ON TABLE ` + postgresTable)
+	ownedByPattern       = regexp.MustCompile(`OWNED BY ` + postgresTable + `\.`)
+	identityTablePattern = regexp.MustCompile(`ALTER TABLE (?:ONLY )?` + postgresTable + ` ALTER COLUMN .* ADD GENERATED`)
+)
+
+// ownerPatterns find the table in the statements of the types of objects
+// that belong to a table
+var ownerPatterns = map[string]*regexp.Regexp{
+	"CONSTRAINT":    alterTablePattern,
+	"FK CONSTRAINT": alterTablePattern,
+	"DEFAULT":       alterTablePattern,
+	"INDEX":         indexTablePattern,
+	"TRIGGER":       triggerTablePattern,
+	"POLICY":        policyTablePattern,
+	"RULE":          ruleTablePattern,
+	"COMMENT":       commentTablePattern,
+	"ACL":           grantTablePattern,
+}
+
+// tocEntry identifies an object in the table of contents of a dump
+type tocEntry struct {
+	Type   string
+	Schema string
+	Name   string
+}
+
+// RestoreTables restores the listed tables from a custom-format dump with
+// pg_restore -L, together with their data, defaults, constraints, indexes,
+// triggers and sequences. The other objects of the database are left alone.
+func (c *PostgreSQLConnector) RestoreTables(ctx context.Context, r io.Reader, tables []string) error {
+	if c.host == "" {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	// pg_restore reads the archive once for its contents and once to restore
+	archive, err := os.CreateTemp("", "postgres-restore-*.dump")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(archive.Name())
+	_, err = io.Copy(archive, r)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write backup data: %w", err)
+	}
+
+	toc, err := newToolCommand(ctx, "pg_restore", "-l", archive.Name()).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list dump contents: %w", err)
+	}
+	schema, err := newToolCommand(ctx, "pg_restore", "-s", "-f", "-", archive.Name()).Output()
+	if err != nil {
+		return fmt.Errorf("failed to read dump schema: %w", err)
+	}
+
+	list, err := tableEntries(toc, schema, tables)
+	if err != nil {
+		return err
+	}
+	listFile, err := os.CreateTemp("", "postgres-restore-*.list")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(listFile.Name())
+	_, err = listFile.Write(list)
+	if closeErr := listFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write restore list: %w", err)
+	}
+
+	args := []string{
+		"-h", c.host,
+		"-p", fmt.Sprintf("%d", c.port),
+		"-U", c.user,
+		"-d", c.dbname,
+		"-c", // Clean (drop) the restored objects before recreating them
+		"-v", // Verbose mode
+		"--if-exists",
+		"-L", listFile.Name(),
+		archive.Name(),
+	}
+
+	cmd, err := c.command(ctx, "pg_restore", args...)
+	if err != nil {
+		return err
+	}
+	defer cmd.Cleanup()
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_restore failed: %w", err)
+	}
+
+	return nil
+}
+
+// tableEntries returns the entries of a table of contents that belong to the
+// listed tables. Table names may be qualified with their schema; others are
+// in the public schema. Only tables are named in the table of contents, so the
+// table other objects belong to is looked up in the schema script of the dump.
+func tableEntries(toc, schema []byte, tables []string) ([]byte, error) {
+	selected := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		selected[qualifyPostgresTable(table)] = true
+	}
+	owners := schemaOwners(string(schema))
+
+	var list bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(toc))
+	for scanner.Scan() {
+		line := scanner.Text()
+		m := tocEntryPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		entry, ok := parseTOCEntry(m[1])
+		if !ok {
+			continue
+		}
+		if selected[entryTable(entry, owners)] {
+			list.WriteString(line)
+			list.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dump contents: %w", err)
+	}
+	return list.Bytes(), nil
+}
+
+// qualifyPostgresTable returns a table name qualified with its schema
+func qualifyPostgresTable(table string) string {
+	if strings.Contains(table, ".") {
+		return table
+	}
+	return "public." + table
+}
+
+// parseTOCEntry splits the description of a table of contents entry into
+// its type, schema and name; the owner after the name is dropped
+func parseTOCEntry(description string) (tocEntry, bool) {
+	for _, entryType := range tocTypes {
+		rest, ok := strings.CutPrefix(description, entryType+" ")
+		if !ok {
+			continue
+		}
+		schema, rest, ok := strings.Cut(rest, " ")
+		if !ok {
+			return tocEntry{}, false
+		}
+		end := strings.LastIndex(rest, " ")
+		if end < 0 {
+			return tocEntry{}, false
+		}
+		return tocEntry{Type: entryType, Schema: schema, Name: rest[:end]}, true
+	}
+	return tocEntry{}, false
+}
+
+// entryTable returns the qualified name of the table a table of contents
+// entry belongs to, or ""
+func entryTable(entry tocEntry, owners map[tocEntry]string) string {
+	switch entry.Type {
+	case "TABLE", "TABLE DATA":
+		return entry.Schema + "." + entry.Name
+	default:
+		return owners[entry]
+	}
+}
+
+// schemaOwners maps the objects of a schema script that belong to a table to
+// the qualified name of that table
+func schemaOwners(schema string) map[tocEntry]string {
+	owners := make(map[tocEntry]string)
+	headers := schemaHeaderPattern.FindAllStringSubmatchIndex(schema, -1)
+	for i, header := range headers {
+		end := len(schema)
+		if i+1 < len(headers) {
+			end = headers[i+1][0]
+		}
+		entry := tocEntry{
+			Name:   schema[header[2]:header[3]],
+			Type:   schema[header[4]:header[5]],
+			Schema: schema[header[6]:header[7]],
+		}
+		statement := schema[header[1]:end]
+
+		// The value a sequence is set to is data, so its entry is not in the
+		// schema script and is mapped together with the sequence
+		var types []string
+		pattern := ownerPatterns[entry.Type]
+		switch entry.Type {
+		case "SEQUENCE OWNED BY":
+			types, pattern = []string{"SEQUENCE", "SEQUENCE OWNED BY", "SEQUENCE SET"}, ownedByPattern
+		case "SEQUENCE":
+			// Identity columns are created with their sequence
+			types, pattern = []string{"SEQUENCE", "SEQUENCE SET"}, identityTablePattern
+		default:
+			types = []string{entry.Type}
+		}
+		if pattern == nil {
+			continue
+		}
+		m := pattern.FindStringSubmatch(statement)
+		if m == nil {
+			continue
+		}
+		table := unquotePostgresIdentifier(m[1]) + "." + unquotePostgresIdentifier(m[2])
+		for _, t := range types {
+			owners[tocEntry{Type: t, Schema: entry.Schema, Name: entry.Name}] = table
+		}
+	}
+	return owners
+}
+
+// unquotePostgresIdentifier reverses quotePostgresIdentifier for quoted
+// identifiers and returns others as they are
+func unquotePostgresIdentifier(name string) string {
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	}
+	return name
+}
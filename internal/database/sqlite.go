@@ -1,21 +1,25 @@
 package database
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3" // SQLite driver
+	sqlite3 "github.com/mattn/go-sqlite3" // SQLite driver
 )
 
 // SQLiteConnector implements the Connector interface for SQLite databases
 type SQLiteConnector struct {
 	db       *sql.DB
 	filePath string
+	format   string // "sql" for SQL dumps; copies of the database file otherwise
 }
 
 // Connect establishes a connection to the SQLite database
@@ -29,6 +33,7 @@ func (c *SQLiteConnector) Connect(ctx context.Context, config ConnectConfig) err
 	}
 
 	c.filePath = config.FilePath
+	c.format = config.Options["format"]
 
 	// Create parent directory if it doesn't exist
 	dir := filepath.Dir(c.filePath)
@@ -63,38 +68,233 @@ func (c *SQLiteConnector) Close() error {
 	return nil
 }
 
-// Backup dumps the database to a writer
+// Backup dumps the database to a writer. When tables are given, the dump is
+// a copy of the database that only contains those tables. With the "format"
+// option set to "sql", the dump is SQL instead.
 func (c *SQLiteConnector) Backup(ctx context.Context, w io.Writer, tables []string) error {
 	if c.db == nil {
 		return fmt.Errorf("database connection not established")
 	}
+	if c.format == SQLiteFormatSQL {
+		return c.dumpSQL(ctx, w, tables)
+	}
 
-	// For SQLite, we can simply copy the database file
-	file, err := os.Open(c.filePath)
+	copyTo := func(r io.Reader) error {
+		if _, err := io.Copy(w, r); err != nil {
+			return fmt.Errorf("failed to copy database: %w", err)
+		}
+		return nil
+	}
+
+	// Find the tables to leave out, if any
+	var drop []string
+	if len(tables) > 0 {
+		all, err := c.ListTables(ctx)
+		if err != nil {
+			return err
+		}
+		keep := make(map[string]bool, len(tables))
+		for _, table := range tables {
+			keep[table] = true
+		}
+		for _, table := range all {
+			if !keep[table] {
+				drop = append(drop, table)
+			}
+		}
+	}
+	if len(drop) == 0 {
+		return c.ReadPages(ctx, func(r io.Reader, pageSize int) error {
+			return copyTo(r)
+		})
+	}
+
+	path, cleanup, err := c.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := filterSnapshot(ctx, path, drop); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to open database file: %w", err)
+		return fmt.Errorf("failed to open database snapshot: %w", err)
 	}
 	defer file.Close()
 
-	// Copy the database file to the writer
-	if _, err := io.Copy(w, file); err != nil {
-		return fmt.Errorf("failed to copy database: %w", err)
+	return copyTo(file)
+}
+
+// ReadPages calls fn with a reader over a consistent snapshot of the database
+// file. The snapshot is taken with the online backup API, which copies pages
+// as they are, including changes still in the WAL, without blocking writers.
+func (c *SQLiteConnector) ReadPages(ctx context.Context, fn func(r io.Reader, pageSize int) error) error {
+	if c.db == nil {
+		return fmt.Errorf("database connection not established")
 	}
 
-	return nil
+	var pageSize int
+	if err := c.db.QueryRowContext(ctx, "PRAGMA page_size;").Scan(&pageSize); err != nil {
+		return fmt.Errorf("failed to get page size: %w", err)
+	}
+
+	path, cleanup, err := c.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open database snapshot: %w", err)
+	}
+	defer file.Close()
+
+	return fn(file, pageSize)
 }
 
-// Restore restores the database from a reader
+// PageLevel reports whether backups are copies of the database file, which
+// page-level incremental backups are built from
+func (c *SQLiteConnector) PageLevel() bool {
+	return c.format != SQLiteFormatSQL
+}
+
+// snapshotRetryDelay is how long snapshot waits before retrying when the
+// database is locked by a writer
+const snapshotRetryDelay = 100 * time.Millisecond
+
+// snapshot copies the database to a temporary file with the online backup
+// API and returns its path and a function that removes it. The copy is made
+// in a single step, so it is consistent.
+func (c *SQLiteConnector) snapshot(ctx context.Context) (string, func(), error) {
+	file, err := os.CreateTemp("", "backyard-sqlite-*.db")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	path := file.Name()
+	file.Close()
+	cleanup := func() {
+		for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+			os.Remove(path + suffix)
+		}
+	}
+
+	if err := c.backupTo(ctx, path); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	// Let the WAL shrink now that the snapshot no longer needs it; a
+	// passive checkpoint never waits for readers or writers
+	c.db.ExecContext(ctx, "PRAGMA wal_checkpoint(PASSIVE);")
+
+	return path, cleanup, nil
+}
+
+// backupTo copies the database into the database file at path
+func (c *SQLiteConnector) backupTo(ctx context.Context, path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot file: %w", err)
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot file: %w", err)
+	}
+	defer destConn.Close()
+
+	srcConn, err := c.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			backup, err := destDriver.(*sqlite3.SQLiteConn).Backup("main", srcDriver.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			}
+
+			for {
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Finish()
+					return fmt.Errorf("failed to copy database: %w", err)
+				}
+				if done {
+					break
+				}
+
+				// The database is locked; try again shortly
+				select {
+				case <-ctx.Done():
+					backup.Finish()
+					return ctx.Err()
+				case <-time.After(snapshotRetryDelay):
+				}
+			}
+
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("failed to finish backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// filterSnapshot drops tables from a database snapshot and compacts it
+func filterSnapshot(ctx context.Context, path string, drop []string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open database snapshot: %w", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Keep every change in the file itself rather than in a WAL
+	if _, err := db.ExecContext(ctx, "PRAGMA journal_mode=DELETE;"); err != nil {
+		return fmt.Errorf("failed to set journal mode of snapshot: %w", err)
+	}
+
+	for _, table := range drop {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s;", quoteSQLiteIdentifier(table))); err != nil {
+			return fmt.Errorf("failed to drop table %s from snapshot: %w", table, err)
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, "VACUUM;"); err != nil {
+		return fmt.Errorf("failed to compact snapshot: %w", err)
+	}
+	return db.Close()
+}
+
+// Restore restores the database from a reader. A database file replaces the
+// database; an SQL dump replaces the tables it contains.
 func (c *SQLiteConnector) Restore(ctx context.Context, r io.Reader) error {
 	if c.db == nil {
 		return fmt.Errorf("database connection not established")
 	}
 
-	// Close the current connection
-	if err := c.db.Close(); err != nil {
-		return fmt.Errorf("failed to close database connection: %w", err)
+	// Tell SQL dumps from database files by the file header. Nothing valid
+	// is empty, and an empty file would pass as an empty database.
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(sqliteFileHeader))
+	if len(header) == 0 {
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read backup data: %w", err)
+		}
+		return fmt.Errorf("backup data is empty")
 	}
-	c.db = nil
+	if string(header) != sqliteFileHeader {
+		return c.restoreSQL(ctx, br)
+	}
+	r = br
 
 	// Create a temporary file
 	tempFile := c.filePath + ".tmp"
@@ -103,21 +303,51 @@ func (c *SQLiteConnector) Restore(ctx context.Context, r io.Reader) error {
 		return fmt.Errorf("failed to create temporary file: %w", err)
 	}
 
-	// Copy the reader to the temporary file
+	// Copy the reader to the temporary file. The current database and its
+	// connection stay untouched until the copy is complete and readable.
 	if _, err := io.Copy(file, r); err != nil {
 		file.Close()
 		os.Remove(tempFile)
 		return fmt.Errorf("failed to write database: %w", err)
 	}
-	file.Close()
+	if err := file.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	if err := checkDatabaseFile(ctx, tempFile); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("restored database is corrupt: %w", err)
+	}
+
+	// Move the changes in the write-ahead log into the database file and
+	// close the current connection
+	if _, err := c.db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE);"); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to checkpoint database: %w", err)
+	}
+	if err := c.db.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to close database connection: %w", err)
+	}
+	c.db = nil
 
-	// Replace the original file with the temporary file
-	if err := os.Rename(tempFile, c.filePath); err != nil {
+	// Replace the original file with the temporary file. A write-ahead log
+	// left next to the original file would be replayed into the restored one.
+	var renameErr error
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(c.filePath + suffix); err != nil && !os.IsNotExist(err) {
+			renameErr = err
+		}
+	}
+	if renameErr == nil {
+		renameErr = os.Rename(tempFile, c.filePath)
+	}
+	if renameErr != nil {
 		os.Remove(tempFile)
-		return fmt.Errorf("failed to replace database file: %w", err)
 	}
 
-	// Reconnect to the database
+	// Reconnect to the database, which is the original one if it could not
+	// be replaced
 	db, err := sql.Open("sqlite3", c.filePath)
 	if err != nil {
 		return fmt.Errorf("failed to reopen database: %w", err)
@@ -133,6 +363,27 @@ func (c *SQLiteConnector) Restore(ctx context.Context, r io.Reader) error {
 	}
 
 	c.db = db
+	if renameErr != nil {
+		return fmt.Errorf("failed to replace database file: %w", renameErr)
+	}
+	return nil
+}
+
+// checkDatabaseFile runs a quick integrity check on a database file
+func checkDatabaseFile(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var status string
+	if err := db.QueryRowContext(ctx, "PRAGMA quick_check;").Scan(&status); err != nil {
+		return err
+	}
+	if status != "ok" {
+		return fmt.Errorf("%s", status)
+	}
 	return nil
 }
 
@@ -245,6 +496,79 @@ func (c *SQLiteConnector) GetInfo(ctx context.Context) (map[string]string, error
 	return info, nil
 }
 
+// TableStates returns the SHA-256 hash of the schema and rows of each table
+func (c *SQLiteConnector) TableStates(ctx context.Context, tables []string) (map[string]string, error) {
+	if c.db == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	states := make(map[string]string, len(tables))
+	for _, table := range tables {
+		hash := sha256.New()
+
+		// Schema
+		var schema string
+		query := "SELECT sql FROM sqlite_master WHERE type='table' AND name = ?;"
+		if err := c.db.QueryRowContext(ctx, query, table).Scan(&schema); err != nil {
+			return nil, fmt.Errorf("failed to get schema of table %s: %w", table, err)
+		}
+		io.WriteString(hash, schema)
+
+		// Rows, in storage order
+		if err := hashRows(ctx, c.db, hash, fmt.Sprintf("SELECT * FROM %s;", quoteSQLiteIdentifier(table))); err != nil {
+			return nil, fmt.Errorf("failed to hash table %s: %w", table, err)
+		}
+
+		states[table] = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	return states, nil
+}
+
+// DropTables removes tables from the database
+func (c *SQLiteConnector) DropTables(ctx context.Context, tables []string) error {
+	if c.db == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	for _, table := range tables {
+		if _, err := c.db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s;", quoteSQLiteIdentifier(table))); err != nil {
+			return fmt.Errorf("failed to drop table %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// hashRows writes every row returned by a query to a hash
+func hashRows(ctx context.Context, db *sql.DB, w io.Writer, query string) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		for _, value := range values {
+			fmt.Fprintf(w, "%T:%v\x00", value, value)
+		}
+		io.WriteString(w, "\n")
+	}
+	return rows.Err()
+}
+
 // Type returns the database type
 func (c *SQLiteConnector) Type() DBType {
 	return SQLite
@@ -0,0 +1,409 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SQLiteFormatSQL is the value of the "format" option that makes SQLite
+// backups portable SQL dumps instead of copies of the database file
+const SQLiteFormatSQL = "sql"
+
+// sqliteFileHeader starts every SQLite database file
+const sqliteFileHeader = "SQLite format 3\x00"
+
+// sqlBatchSize is how much SQL restoreSQL executes at once
+const sqlBatchSize = 1 << 20
+
+// dumpSQL writes the schema and rows of tables as SQL, like the .dump command
+// of the sqlite3 shell. Indexes and triggers of the tables are included;
+// views only when no table is left out. The dump is read in one transaction,
+// so it is consistent.
+func (c *SQLiteConnector) dumpSQL(ctx context.Context, w io.Writer, tables []string) error {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	all, err := queryStrings(ctx, tx, "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name;")
+	if err != nil {
+		return fmt.Errorf("failed to list tables: %w", err)
+	}
+	if len(tables) == 0 {
+		tables = all
+	}
+	selected := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		selected[table] = true
+	}
+
+	out := bufio.NewWriter(w)
+	out.WriteString("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+
+	// AUTOINCREMENT counters live in sqlite_sequence, if any table uses them
+	var sequences int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'sqlite_sequence';").Scan(&sequences); err != nil {
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	dumped := 0
+	for _, table := range all {
+		if !selected[table] {
+			continue
+		}
+		if err := dumpTable(ctx, tx, out, table, sequences > 0); err != nil {
+			return fmt.Errorf("failed to dump table %s: %w", table, err)
+		}
+		dumped++
+	}
+
+	// Indexes and triggers, which are created after the rows are inserted
+	rows, err := tx.QueryContext(ctx, "SELECT tbl_name, sql FROM sqlite_master WHERE type IN ('index', 'trigger') AND sql IS NOT NULL ORDER BY type, name;")
+	if err != nil {
+		return fmt.Errorf("failed to list indexes and triggers: %w", err)
+	}
+	for rows.Next() {
+		var table, stmt string
+		if err := rows.Scan(&table, &stmt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read schema: %w", err)
+		}
+		if selected[table] {
+			fmt.Fprintf(out, "%s;\n", stmt)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	// Views may use any table, so they are only dumped with all of them
+	if dumped == len(all) {
+		rows, err := tx.QueryContext(ctx, "SELECT name, sql FROM sqlite_master WHERE type = 'view' ORDER BY name;")
+		if err != nil {
+			return fmt.Errorf("failed to list views: %w", err)
+		}
+		for rows.Next() {
+			var name, stmt string
+			if err := rows.Scan(&name, &stmt); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read schema: %w", err)
+			}
+			fmt.Fprintf(out, "DROP VIEW IF EXISTS %s;\n%s;\n", quoteSQLiteIdentifier(name), stmt)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read schema: %w", err)
+		}
+	}
+
+	out.WriteString("COMMIT;\n")
+	return out.Flush()
+}
+
+// dumpTable writes the statements that recreate a table and its rows
+func dumpTable(ctx context.Context, tx *sql.Tx, w io.Writer, table string, hasSequence bool) error {
+	var schema string
+	if err := tx.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type='table' AND name = ?;", table).Scan(&schema); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "DROP TABLE IF EXISTS %s;\n%s;\n", quoteSQLiteIdentifier(table), schema)
+
+	// Generated columns are not listed, as they cannot be inserted
+	columns, err := queryStrings(ctx, tx, fmt.Sprintf("SELECT name FROM pragma_table_info(%s);", quoteSQLiteLiteral(table)))
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	// quote() renders every value as an SQL literal that reads back exactly
+	quoted := make([]string, len(columns))
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quoteSQLiteIdentifier(column)
+		values[i] = "quote(" + quoted[i] + ")"
+	}
+	insert := fmt.Sprintf("INSERT INTO %s(%s) VALUES(", quoteSQLiteIdentifier(table), strings.Join(quoted, ","))
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s;", strings.Join(values, " || ',' || "), quoteSQLiteIdentifier(table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, insert+row+");\n"); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Keep AUTOINCREMENT counters
+	if hasSequence {
+		var seq int64
+		err := tx.QueryRowContext(ctx, "SELECT seq FROM sqlite_sequence WHERE name = ?;", table).Scan(&seq)
+		if err == nil {
+			fmt.Fprintf(w, "DELETE FROM sqlite_sequence WHERE name = %s;\nINSERT INTO sqlite_sequence(name, seq) VALUES(%s, %d);\n",
+				quoteSQLiteLiteral(table), quoteSQLiteLiteral(table), seq)
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreSQL executes an SQL dump against the database. Tables in the dump
+// replace the existing ones; other tables are left alone.
+func (c *SQLiteConnector) restoreSQL(ctx context.Context, r io.Reader) error {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	defer conn.Close()
+
+	fail := func(err error) error {
+		conn.ExecContext(context.Background(), "ROLLBACK;")
+		return err
+	}
+
+	var batch, statement strings.Builder
+	var state statementState
+	flush := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		_, err := conn.ExecContext(ctx, batch.String())
+		batch.Reset()
+		return err
+	}
+
+	scanner := bufio.NewReader(r)
+	for {
+		line, err := scanner.ReadString('\n')
+		statement.WriteString(line)
+		state.scan(line)
+		if state.complete() {
+			batch.WriteString(statement.String())
+			statement.Reset()
+			state = statementState{}
+			if batch.Len() >= sqlBatchSize {
+				if err := flush(); err != nil {
+					return fail(fmt.Errorf("failed to execute dump: %w", err))
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("failed to read dump: %w", err))
+		}
+	}
+
+	if strings.TrimSpace(statement.String()) != "" {
+		return fail(fmt.Errorf("dump ends with an incomplete statement"))
+	}
+	if err := flush(); err != nil {
+		return fail(fmt.Errorf("failed to execute dump: %w", err))
+	}
+	return nil
+}
+
+// RestoreTables restores the listed tables from a backup, which is either a
+// copy of the database file or an SQL dump. The backup is unpacked into a
+// temporary database, from which the tables are copied as SQL, replacing the
+// existing ones with their indexes and triggers.
+func (c *SQLiteConnector) RestoreTables(ctx context.Context, r io.Reader, tables []string) error {
+	if c.db == nil {
+		return fmt.Errorf("database connection not established")
+	}
+
+	file, err := os.CreateTemp("", "sqlite-restore-*.db")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary database: %w", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	scratch := &SQLiteConnector{}
+	if err := scratch.Connect(ctx, ConnectConfig{Type: SQLite, FilePath: file.Name()}); err != nil {
+		return fmt.Errorf("failed to open temporary database: %w", err)
+	}
+	defer scratch.Close()
+	if err := scratch.Restore(ctx, r); err != nil {
+		return fmt.Errorf("failed to unpack backup: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		err := scratch.dumpSQL(ctx, pw, tables)
+		pw.CloseWithError(err)
+		errCh <- err
+	}()
+
+	err = c.restoreSQL(ctx, pr)
+	pr.Close()
+	if dumpErr := <-errCh; err == nil && dumpErr != nil {
+		return fmt.Errorf("failed to copy tables: %w", dumpErr)
+	}
+	return err
+}
+
+// statementState follows an SQL statement as it is read line by line, so
+// that the end of the statement is found without rescanning it
+type statementState struct {
+	quote   byte     // Closing character of the quoted text being read, or 0
+	comment byte     // '-' inside a line comment, '*' inside a block comment, or 0
+	prev    byte     // Previous character, to find comment delimiters
+	word    []byte   // Unquoted word being read
+	first   []string // First words of the statement, upper-cased
+	last    string   // Last token outside quotes and comments, upper-cased
+	cases   int      // CASE expressions not yet closed by their END
+	ended   bool     // The statement so far ends with a semicolon
+	endWord string   // Token before that semicolon
+}
+
+// scan advances the state over the next part of the statement
+func (s *statementState) scan(text string) {
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		prev := s.prev
+		s.prev = ch
+		switch {
+		case s.comment == '-':
+			if ch == '\n' {
+				s.comment = 0
+			}
+		case s.comment == '*':
+			if prev == '*' && ch == '/' {
+				s.comment = 0
+				// The closing slash cannot start another comment
+				s.prev = 0
+			}
+		case s.quote != 0:
+			if ch == s.quote {
+				s.quote = 0
+			}
+		case ch == '-' && i+1 < len(text) && text[i+1] == '-':
+			s.finishWord()
+			s.comment = '-'
+		case ch == '/' && i+1 < len(text) && text[i+1] == '*':
+			s.finishWord()
+			s.comment = '*'
+			i++
+			s.prev = 0
+		case isIdentifierChar(ch):
+			s.word = append(s.word, ch)
+		default:
+			s.finishWord()
+			switch ch {
+			case ' ', '\t', '\r', '\n':
+			case ';':
+				if s.last != ";" {
+					s.endWord = s.last
+				}
+				s.ended, s.last = true, ";"
+			default:
+				s.ended = false
+				s.last = string(ch)
+				switch ch {
+				case '\'', '"', '`':
+					s.quote = ch
+				case '[':
+					s.quote = ']'
+				}
+			}
+		}
+	}
+}
+
+// finishWord finishes the unquoted word being read
+func (s *statementState) finishWord() {
+	if len(s.word) == 0 {
+		return
+	}
+	word := strings.ToUpper(string(s.word))
+	s.word = s.word[:0]
+	if len(s.first) < 3 {
+		s.first = append(s.first, word)
+	}
+	// The END of a CASE expression does not end a trigger body
+	switch {
+	case word == "CASE":
+		s.cases++
+	case word == "END" && s.cases > 0:
+		s.cases--
+		word = "END CASE"
+	}
+	s.last = word
+	s.ended = false
+}
+
+// complete reports whether the statement read so far is complete: it ends
+// with a semicolon outside of quotes and comments that, for triggers, follows
+// the END of the trigger body
+func (s *statementState) complete() bool {
+	if len(s.word) > 0 || s.quote != 0 || s.comment == '*' || !s.ended {
+		return false
+	}
+
+	// Statements inside a trigger body end with semicolons too
+	isTrigger := len(s.first) >= 2 && s.first[0] == "CREATE" &&
+		(s.first[1] == "TRIGGER" || (len(s.first) >= 3 && (s.first[1] == "TEMP" || s.first[1] == "TEMPORARY") && s.first[2] == "TRIGGER"))
+	return !isTrigger || s.endWord == "END"
+}
+
+// isIdentifierChar reports whether ch can be part of an unquoted identifier
+func isIdentifierChar(ch byte) bool {
+	return ch == '_' || ch == '$' || ch >= 0x80 ||
+		(ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+// queryStrings returns the first column of every row of a query
+func queryStrings(ctx context.Context, tx *sql.Tx, query string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// quoteSQLiteIdentifier quotes a table or column name
+func quoteSQLiteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteSQLiteLiteral quotes a string as an SQL literal
+func quoteSQLiteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// splitStatements splits a dump into statements the way restoreSQL does,
+// line by line
+func splitStatements(dump string) (statements []string, rest string) {
+	var statement strings.Builder
+	var state statementState
+	r := bufio.NewReader(strings.NewReader(dump))
+	for {
+		line, err := r.ReadString('\n')
+		statement.WriteString(line)
+		state.scan(line)
+		if state.complete() {
+			statements = append(statements, statement.String())
+			statement.Reset()
+			state = statementState{}
+		}
+		if err != nil {
+			return statements, statement.String()
+		}
+	}
+}
+
+func TestStatementState(t *testing.T) {
+	tests := []struct {
+		name string
+		dump string
+		want int // Complete statements
+		rest bool
+	}{
+		{"one per line", "CREATE TABLE t (x);\nINSERT INTO t VALUES (1);\n", 2, false},
+		{"spanning lines", "CREATE TABLE t (\n  x INTEGER,\n  y TEXT\n);\n", 1, false},
+		{"two on a line", "INSERT INTO t VALUES (1); INSERT INTO t VALUES (2);\n", 1, false},
+		{"semicolon in a string", "INSERT INTO t VALUES ('a;\nb;');\n", 1, false},
+		{"escaped quote", "INSERT INTO t VALUES ('it''s;\n');\n", 1, false},
+		{"quoted identifiers", "CREATE TABLE \"a;\n\" ([b;\n] TEXT, `c;\n` TEXT);\n", 1, false},
+		{"line comment", "-- a comment;\nINSERT INTO t VALUES (1); -- done;\n", 1, false},
+		{"block comment", "/* a;\n b; */ INSERT INTO t VALUES (1);\n", 1, false},
+		{"comment after semicolon", "INSERT INTO t VALUES (1); /* trailing\n comment; */\n", 1, false},
+		{"division is not a comment", "SELECT 4/2;\n", 1, false},
+		{"trigger", "CREATE TRIGGER tr AFTER INSERT ON t BEGIN\n  UPDATE t SET x = 1;\n  DELETE FROM u;\nEND;\n", 1, false},
+		{"temporary trigger", "CREATE TEMP TRIGGER tr AFTER INSERT ON t BEGIN\n  DELETE FROM u;\nEND;\n", 1, false},
+		{"trigger with CASE", "CREATE TRIGGER tr AFTER INSERT ON t BEGIN\n  UPDATE t SET x = CASE WHEN new.x > 0 THEN 1 ELSE 0 END;\n  UPDATE t SET y = CASE new.y\n    WHEN 1 THEN 'one'\n  END;\nEND;\n", 1, false},
+		{"statement after a trigger", "CREATE TRIGGER tr AFTER INSERT ON t BEGIN\n  DELETE FROM u;\nEND;\nINSERT INTO t VALUES (1);\n", 2, false},
+		{"unterminated string", "INSERT INTO t VALUES ('abc;\n", 0, true},
+		{"unterminated trigger", "CREATE TRIGGER tr AFTER INSERT ON t BEGIN\n  DELETE FROM u;\n", 0, true},
+		{"no final newline", "INSERT INTO t VALUES (1);", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statements, rest := splitStatements(tt.dump)
+			if len(statements) != tt.want {
+				t.Errorf("got %d statements, want %d: %q", len(statements), tt.want, statements)
+			}
+			if (strings.TrimSpace(rest) != "") != tt.rest {
+				t.Errorf("got rest %q", rest)
+			}
+		})
+	}
+}
+
+// openSQLite opens a new SQLite database in a temporary directory
+func openSQLite(t *testing.T, options map[string]string) *SQLiteConnector {
+	t.Helper()
+	c := &SQLiteConnector{}
+	path := filepath.Join(t.TempDir(), "test.db")
+	if err := c.Connect(context.Background(), ConnectConfig{Type: SQLite, FilePath: path, Options: options}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// queryAll returns the first column of every row of a query
+func queryAll(t *testing.T, c *SQLiteConnector, query string) []string {
+	t.Helper()
+	rows, err := c.db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestSQLDumpRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := openSQLite(t, map[string]string{"format": SQLiteFormatSQL})
+	_, err := source.db.Exec(`
+CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, data BLOB, price REAL);
+CREATE TABLE "odd ""name""; table" (value TEXT);
+CREATE TABLE log (item INTEGER, kind TEXT);
+CREATE INDEX items_name ON items (name);
+CREATE TRIGGER items_log AFTER INSERT ON items BEGIN
+  INSERT INTO log VALUES (new.id, CASE WHEN new.price > 10 THEN 'expensive' ELSE 'cheap' END);
+END;
+CREATE VIEW cheap AS SELECT name FROM items WHERE price <= 10;
+INSERT INTO items (name, data, price) VALUES ('plain', x'00ff10', 1.5);
+INSERT INTO items (name, data, price) VALUES ('semi;colon
+and newline -- not a comment', NULL, 20);
+INSERT INTO items (name, data, price) VALUES ('it''s /* quoted */', x'', NULL);
+DELETE FROM items WHERE name = 'plain';
+INSERT INTO "odd ""name""; table" VALUES ('END;');
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dump bytes.Buffer
+	if err := source.Backup(ctx, &dump, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	target := openSQLite(t, nil)
+	if err := target.Restore(ctx, bytes.NewReader(dump.Bytes())); err != nil {
+		t.Fatalf("restore failed: %v\n%s", err, dump.String())
+	}
+
+	queries := []string{
+		"SELECT id || '|' || quote(name) || '|' || quote(data) || '|' || quote(price) FROM items ORDER BY id",
+		`SELECT value FROM "odd ""name""; table"`,
+		"SELECT item || '|' || kind FROM log ORDER BY item",
+		"SELECT name FROM cheap",
+		"SELECT type || '|' || name FROM sqlite_master ORDER BY type, name",
+		"SELECT name || '|' || seq FROM sqlite_sequence",
+	}
+	for _, query := range queries {
+		want, got := queryAll(t, source, query), queryAll(t, target, query)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s:\n got  %q\n want %q", query, got, want)
+		}
+	}
+
+	// The trigger still works after the restore
+	if _, err := target.db.Exec("INSERT INTO items (name, price) VALUES ('new', 11)"); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryAll(t, target, "SELECT kind FROM log WHERE item = 4"); len(got) != 1 || got[0] != "expensive" {
+		t.Errorf("trigger after restore logged %q", got)
+	}
+}
+
+func TestSQLDumpTables(t *testing.T) {
+	ctx := context.Background()
+	source := openSQLite(t, map[string]string{"format": SQLiteFormatSQL})
+	_, err := source.db.Exec(`
+CREATE TABLE a (x);
+CREATE TABLE b (x);
+CREATE VIEW v AS SELECT x FROM a JOIN b USING (x);
+INSERT INTO a VALUES (1);
+INSERT INTO b VALUES (2);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dump bytes.Buffer
+	if err := source.Backup(ctx, &dump, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the selected table is dumped, and no view that may use others
+	target := openSQLite(t, nil)
+	if err := target.Restore(ctx, &dump); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryAll(t, target, "SELECT name FROM sqlite_master ORDER BY name"); strings.Join(got, ",") != "a" {
+		t.Errorf("restored schema objects %q, want a", got)
+	}
+}
@@ -0,0 +1,335 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Algorithm is the name of the encryption scheme recorded in backup metadata
+const Algorithm = "aes-256-gcm"
+
+const (
+	// ChunkSize is the amount of plaintext sealed in each authenticated chunk
+	ChunkSize = 64 * 1024
+
+	formatVersion   = 1
+	noncePrefixSize = 7
+	finalChunkFlag  = 1 << 31
+)
+
+// magic identifies an encrypted backup stream
+var magic = []byte("BYBENC")
+
+var (
+	// ErrTruncated is returned when an encrypted stream ends before its final chunk
+	ErrTruncated = errors.New("encrypted stream is truncated")
+	// ErrAuthentication is returned when a chunk fails authentication
+	ErrAuthentication = errors.New("encrypted stream failed authentication")
+)
+
+// Stream layout:
+//
+//	header: "BYBENC" | version | kdf | salt length | salt | key ID length | key ID | nonce prefix
+//	chunks: length (uint32, high bit set on the final chunk) | AES-GCM sealed chunk
+//
+// Each chunk nonce is the nonce prefix followed by the chunk counter and the
+// final flag, and the header is used as additional data for every chunk, so
+// chunks cannot be reordered, dropped, truncated or moved between streams.
+
+// IsEncrypted reports whether data starts with an encrypted stream header
+func IsEncrypted(header []byte) bool {
+	return bytes.HasPrefix(header, magic)
+}
+
+// writer encrypts data written to it in authenticated chunks
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	buf     []byte
+	counter uint32
+	closed  bool
+}
+
+// NewWriter returns a writer that encrypts everything written to it with the
+// given key. Close must be called to write the final chunk.
+func NewWriter(w io.Writer, key *Key) (io.WriteCloser, error) {
+	var salt []byte
+	if key.kdf != KDFNone {
+		salt = make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	aead, err := key.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build and write header
+	var header bytes.Buffer
+	header.Write(magic)
+	header.WriteByte(formatVersion)
+	header.WriteByte(byte(kdfIDs[key.kdf]))
+	header.WriteByte(byte(len(salt)))
+	header.Write(salt)
+	header.WriteByte(byte(len(key.ID)))
+	header.WriteString(key.ID)
+	header.Write(prefix)
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write encryption header: %w", err)
+	}
+
+	return &writer{
+		w:      w,
+		aead:   aead,
+		header: header.Bytes(),
+		prefix: prefix,
+		buf:    make([]byte, 0, ChunkSize),
+	}, nil
+}
+
+// Write implements io.Writer
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to closed encryption writer")
+	}
+
+	total := 0
+	for len(p) > 0 {
+		if len(w.buf) == cap(w.buf) {
+			if err := w.seal(false); err != nil {
+				return total, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		total += n
+	}
+
+	return total, nil
+}
+
+// Close writes the final chunk. It does not close the underlying writer.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+// seal encrypts and writes the buffered chunk
+func (w *writer) seal(final bool) error {
+	if w.counter == ^uint32(0) {
+		return fmt.Errorf("encrypted stream too large")
+	}
+
+	sealed := w.aead.Seal(nil, chunkNonce(w.prefix, w.counter, final), w.buf, w.header)
+
+	length := uint32(len(sealed))
+	if final {
+		length |= finalChunkFlag
+	}
+
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], length)
+	if _, err := w.w.Write(lenBuf[:]); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	if _, err := w.w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+
+	w.buf = w.buf[:0]
+	w.counter++
+	return nil
+}
+
+// reader decrypts a stream produced by writer
+type reader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	chunk   []byte
+	buf     []byte
+	counter uint32
+	done    bool
+}
+
+// NewReader returns a reader that decrypts a stream produced by NewWriter,
+// looking up the key named in the stream header in the keyring
+func NewReader(r io.Reader, keyring *Keyring) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	var header bytes.Buffer
+	tr := io.TeeReader(br, &header)
+
+	// Magic and version
+	head := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(tr, head); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if !bytes.Equal(head[:len(magic)], magic) {
+		return nil, fmt.Errorf("stream is not encrypted")
+	}
+	if head[len(magic)] != formatVersion {
+		return nil, fmt.Errorf("unsupported encryption format version %d", head[len(magic)])
+	}
+
+	// Key derivation function and salt
+	kdfID, err := readByte(tr)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := readField(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Key ID
+	keyID, err := readField(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nonce prefix
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(tr, prefix); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+
+	key, err := keyring.Get(string(keyID))
+	if err != nil {
+		return nil, err
+	}
+	if kdfIDs[key.kdf] != int(kdfID) {
+		return nil, fmt.Errorf("encryption key %q does not match the key derivation used by the stream", key.ID)
+	}
+
+	aead, err := key.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{
+		r:      br,
+		aead:   aead,
+		header: header.Bytes(),
+		prefix: prefix,
+	}, nil
+}
+
+// Read implements io.Reader
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next chunk
+func (r *reader) next() error {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r.r, lenBuf[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(lenBuf[:])
+	final := length&finalChunkFlag != 0
+	length &^= finalChunkFlag
+	if int(length) > ChunkSize+r.aead.Overhead() {
+		return fmt.Errorf("encrypted chunk too large: %d bytes", length)
+	}
+
+	if cap(r.chunk) < int(length) {
+		r.chunk = make([]byte, ChunkSize+r.aead.Overhead())
+	}
+	chunk := r.chunk[:length]
+	if _, err := io.ReadFull(r.r, chunk); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	plain, err := r.aead.Open(chunk[:0], chunkNonce(r.prefix, r.counter, final), chunk, r.header)
+	if err != nil {
+		return ErrAuthentication
+	}
+
+	r.buf = plain
+	r.counter++
+	r.done = final
+	return nil
+}
+
+// chunkNonce builds the nonce of a chunk
+func chunkNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if final {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// newAEAD creates an AES-256-GCM cipher from a 32-byte key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// readByte reads a single header byte
+func readByte(r io.Reader) (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	return b[0], nil
+}
+
+// readField reads a length-prefixed header field
+func readField(r io.Reader) ([]byte, error) {
+	n, err := readByte(r)
+	if err != nil {
+		return nil, err
+	}
+	field := make([]byte, n)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	return field, nil
+}
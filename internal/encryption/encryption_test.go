@@ -0,0 +1,439 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testKey returns a raw key with random material
+func testKey(t *testing.T, id string) *Key {
+	t.Helper()
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewKey(id, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testKeyring returns a keyring holding keys, the first of them active
+func testKeyring(t *testing.T, keys ...*Key) *Keyring {
+	t.Helper()
+	keyring, err := NewKeyring(keys, keys[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// encrypt encrypts plain with key
+func encrypt(t *testing.T, key *Key, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decrypt decrypts a whole stream with the keys of keyring
+func decrypt(keyring *Keyring, stream []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(stream), keyring)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// randomBytes returns n random bytes
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// splitStream splits an encrypted stream into its header and its chunks,
+// each with its length prefix
+func splitStream(t *testing.T, stream []byte) ([]byte, [][]byte) {
+	t.Helper()
+	// Magic, version and KDF, then the salt and key ID fields
+	n := len(magic) + 2
+	for i := 0; i < 2; i++ {
+		n += 1 + int(stream[n])
+	}
+	n += noncePrefixSize
+
+	header, rest := stream[:n], stream[n:]
+	var chunks [][]byte
+	for len(rest) > 0 {
+		length := binary.BigEndian.Uint32(rest[:4]) &^ finalChunkFlag
+		end := 4 + int(length)
+		chunks = append(chunks, rest[:end])
+		rest = rest[end:]
+	}
+	return header, chunks
+}
+
+// join concatenates the parts of a stream into a new slice
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := testKey(t, "main")
+	keyring := testKeyring(t, key)
+
+	sizes := []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17}
+	for _, size := range sizes {
+		plain := randomBytes(t, size)
+		stream := encrypt(t, key, plain)
+
+		if !IsEncrypted(stream) {
+			t.Errorf("size %d: stream is not recognized as encrypted", size)
+		}
+		got, err := decrypt(keyring, stream)
+		if err != nil {
+			t.Errorf("size %d: decrypt failed: %v", size, err)
+			continue
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted data differs from the plaintext", size)
+		}
+	}
+}
+
+func TestRoundTripSmallWrites(t *testing.T) {
+	key := testKey(t, "main")
+	plain := randomBytes(t, 2*ChunkSize+100)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(plain); i += 1000 {
+		end := min(i+1000, len(plain))
+		if _, err := w.Write(plain[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decrypt(testKeyring(t, key), buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Error("decrypted data differs from the plaintext")
+	}
+}
+
+func TestPassphraseRoundTrip(t *testing.T) {
+	for _, kdf := range []KDF{KDFArgon2id, KDFScrypt} {
+		key, err := PassphraseKey("pass", "correct horse battery staple", kdf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain := randomBytes(t, ChunkSize+1)
+		stream := encrypt(t, key, plain)
+
+		got, err := decrypt(testKeyring(t, key), stream)
+		if err != nil {
+			t.Errorf("%s: decrypt failed: %v", kdf, err)
+			continue
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%s: decrypted data differs from the plaintext", kdf)
+		}
+
+		// The same passphrase with another key derivation cannot decrypt it
+		other := KDFScrypt
+		if kdf == KDFScrypt {
+			other = KDFArgon2id
+		}
+		otherKey, err := PassphraseKey("pass", "correct horse battery staple", other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := decrypt(testKeyring(t, otherKey), stream); err == nil {
+			t.Errorf("%s: stream decrypted with a %s key", kdf, other)
+		}
+	}
+}
+
+func TestPassphraseSalt(t *testing.T) {
+	key, err := PassphraseKey("pass", "secret", KDFArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every stream gets a fresh salt, so equal plaintexts differ
+	plain := []byte("same data")
+	first, second := encrypt(t, key, plain), encrypt(t, key, plain)
+	firstHeader, _ := splitStream(t, first)
+	secondHeader, _ := splitStream(t, second)
+	if bytes.Equal(firstHeader, secondHeader) {
+		t.Error("two streams share their salt and nonce prefix")
+	}
+}
+
+func TestKeyDerivation(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, saltSize)
+	otherSalt := bytes.Repeat([]byte{2}, saltSize)
+
+	derive := func(kdf KDF, passphrase string, salt []byte) []byte {
+		t.Helper()
+		key, err := PassphraseKey("k", passphrase, kdf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		derived, err := key.derive(salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(derived) != keySize {
+			t.Fatalf("%s: derived %d bytes, want %d", kdf, len(derived), keySize)
+		}
+		return derived
+	}
+
+	for _, kdf := range []KDF{KDFArgon2id, KDFScrypt} {
+		base := derive(kdf, "secret", salt)
+		if !bytes.Equal(base, derive(kdf, "secret", salt)) {
+			t.Errorf("%s: derivation is not deterministic", kdf)
+		}
+		if bytes.Equal(base, derive(kdf, "secret", otherSalt)) {
+			t.Errorf("%s: salt does not change the key", kdf)
+		}
+		if bytes.Equal(base, derive(kdf, "Secret", salt)) {
+			t.Errorf("%s: passphrase does not change the key", kdf)
+		}
+	}
+	if bytes.Equal(derive(KDFArgon2id, "secret", salt), derive(KDFScrypt, "secret", salt)) {
+		t.Error("argon2id and scrypt derive the same key")
+	}
+
+	// The default is Argon2id
+	key, err := PassphraseKey("k", "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.kdf != KDFArgon2id {
+		t.Errorf("default key derivation is %s, want %s", key.kdf, KDFArgon2id)
+	}
+}
+
+func TestKeyValidation(t *testing.T) {
+	if _, err := NewKey("short", make([]byte, keySize-1)); err == nil {
+		t.Error("NewKey accepted a short key")
+	}
+	if _, err := NewKey("", make([]byte, keySize)); err == nil {
+		t.Error("NewKey accepted an empty ID")
+	}
+	if _, err := NewKey(string(bytes.Repeat([]byte("x"), 256)), make([]byte, keySize)); err == nil {
+		t.Error("NewKey accepted an ID longer than 255 bytes")
+	}
+	if _, err := PassphraseKey("k", "", KDFArgon2id); err == nil {
+		t.Error("PassphraseKey accepted an empty passphrase")
+	}
+	if _, err := PassphraseKey("k", "secret", KDF("md5")); err == nil {
+		t.Error("PassphraseKey accepted an unknown key derivation function")
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	raw := randomBytes(t, keySize)
+	dir := t.TempDir()
+
+	contents := map[string][]byte{
+		"raw":    raw,
+		"hex":    []byte(hex.EncodeToString(raw) + "\n"),
+		"base64": []byte(base64.StdEncoding.EncodeToString(raw) + "\n"),
+	}
+	for name, data := range contents {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		key, err := LoadKeyFile(name, path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(key.material, raw) {
+			t.Errorf("%s: loaded key differs from the file", name)
+		}
+	}
+
+	path := filepath.Join(dir, "short")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(raw[:20])), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeyFile("short", path); err == nil {
+		t.Error("LoadKeyFile accepted a 20-byte key")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	old, current := testKey(t, "old"), testKey(t, "current")
+	keyring, err := NewKeyring([]*Key{old, current}, "current")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Backups encrypted with a retired key can still be decrypted
+	plain := []byte("written with the old key")
+	got, err := decrypt(keyring, encrypt(t, old, plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Error("decrypted data differs from the plaintext")
+	}
+	if keyring.Active() != current {
+		t.Error("Active does not return the active key")
+	}
+
+	if _, err := NewKeyring([]*Key{old, old}, "old"); err == nil {
+		t.Error("NewKeyring accepted duplicate keys")
+	}
+	if _, err := NewKeyring([]*Key{old}, "missing"); err == nil {
+		t.Error("NewKeyring accepted a missing active key")
+	}
+
+	var disabled *Keyring
+	if disabled.Active() != nil {
+		t.Error("nil keyring has an active key")
+	}
+	if _, err := decrypt(disabled, encrypt(t, old, plain)); err == nil {
+		t.Error("stream decrypted without keys")
+	}
+	if _, err := decrypt(testKeyring(t, current), encrypt(t, old, plain)); err == nil {
+		t.Error("stream decrypted without its key")
+	}
+}
+
+func TestWrongKeyMaterial(t *testing.T) {
+	stream := encrypt(t, testKey(t, "main"), []byte("secret data"))
+
+	// A key with the same ID but other material fails authentication
+	_, err := decrypt(testKeyring(t, testKey(t, "main")), stream)
+	if !errors.Is(err, ErrAuthentication) {
+		t.Errorf("got %v, want %v", err, ErrAuthentication)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	key := testKey(t, "main")
+	keyring := testKeyring(t, key)
+	stream := encrypt(t, key, randomBytes(t, 3*ChunkSize+17))
+	header, chunks := splitStream(t, stream)
+	if len(chunks) != 4 {
+		t.Fatalf("got %d chunks, want 4", len(chunks))
+	}
+
+	// withFlag returns a copy of chunk with the final flag set or cleared
+	withFlag := func(chunk []byte, final bool) []byte {
+		chunk = bytes.Clone(chunk)
+		length := binary.BigEndian.Uint32(chunk) &^ finalChunkFlag
+		if final {
+			length |= finalChunkFlag
+		}
+		binary.BigEndian.PutUint32(chunk, length)
+		return chunk
+	}
+
+	tests := []struct {
+		name   string
+		stream []byte
+		want   error
+	}{
+		{"chunk data modified", join(header, chunks[0], flip(chunks[1], 100), chunks[2], chunks[3]), ErrAuthentication},
+		{"tag modified", join(header, chunks[0], chunks[1], chunks[2], flip(chunks[3], len(chunks[3])-1)), ErrAuthentication},
+		{"nonce prefix modified", join(flip(header, len(header)-1), chunks[0], chunks[1], chunks[2], chunks[3]), ErrAuthentication},
+		{"chunks reordered", join(header, chunks[1], chunks[0], chunks[2], chunks[3]), ErrAuthentication},
+		{"chunk dropped", join(header, chunks[0], chunks[2], chunks[3]), ErrAuthentication},
+		{"chunk repeated", join(header, chunks[0], chunks[0], chunks[1], chunks[2], chunks[3]), ErrAuthentication},
+		{"final chunk dropped", join(header, chunks[0], chunks[1], chunks[2]), ErrTruncated},
+		{"truncated inside a chunk", join(header, chunks[0], chunks[1][:len(chunks[1])/2]), ErrTruncated},
+		{"truncated inside a length", join(header, chunks[0], chunks[1][:2]), ErrTruncated},
+		{"only the header", header, ErrTruncated},
+		{"early chunk marked final", join(header, withFlag(chunks[0], true)), ErrAuthentication},
+		{"final flag cleared", join(header, chunks[0], chunks[1], chunks[2], withFlag(chunks[3], false)), ErrAuthentication},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decrypt(keyring, tt.stream)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTamperHeader(t *testing.T) {
+	key := testKey(t, "main")
+	keyring := testKeyring(t, key)
+	stream := encrypt(t, key, []byte("secret data"))
+
+	// Chunks cannot be moved to a stream with another header
+	other := encrypt(t, key, []byte("other data"))
+	otherHeader, _ := splitStream(t, other)
+	_, chunks := splitStream(t, stream)
+	if _, err := decrypt(keyring, join(otherHeader, chunks[0])); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("spliced stream: got %v, want %v", err, ErrAuthentication)
+	}
+
+	// A bad magic, version or KDF is rejected before any chunk is read
+	for _, i := range []int{0, len(magic), len(magic) + 1} {
+		if _, err := decrypt(keyring, flip(stream, i)); err == nil {
+			t.Errorf("stream with header byte %d modified decrypted", i)
+		}
+	}
+}
+
+// flip1 returns a copy of b with the lowest bit of byte i flipped
+func flip(b []byte, i int) []byte {
+	b = bytes.Clone(b)
+	b[i] ^= 0x01
+	return b
+}
+
+func TestWriterClosed(t *testing.T) {
+	w, err := NewWriter(io.Discard, testKey(t, "main"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if _, err := w.Write([]byte("late")); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
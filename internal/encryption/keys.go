@@ -0,0 +1,189 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF is a key derivation function used to turn a passphrase into a key
+type KDF string
+
+const (
+	// KDFNone means the key is used as-is
+	KDFNone KDF = "none"
+	// KDFArgon2id derives the key with Argon2id
+	KDFArgon2id KDF = "argon2id"
+	// KDFScrypt derives the key with scrypt
+	KDFScrypt KDF = "scrypt"
+)
+
+// kdfIDs maps key derivation functions to their identifier in the stream header
+var kdfIDs = map[KDF]int{
+	KDFNone:     0,
+	KDFArgon2id: 1,
+	KDFScrypt:   2,
+}
+
+const (
+	keySize  = 32 // AES-256
+	saltSize = 16
+)
+
+// Key is an encryption key, either raw or derived from a passphrase
+type Key struct {
+	ID       string
+	kdf      KDF
+	material []byte
+}
+
+// NewKey creates a key from 32 bytes of raw key material
+func NewKey(id string, raw []byte) (*Key, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	if len(raw) != keySize {
+		return nil, fmt.Errorf("encryption key %q must be %d bytes, got %d", id, keySize, len(raw))
+	}
+
+	return &Key{
+		ID:       id,
+		kdf:      KDFNone,
+		material: raw,
+	}, nil
+}
+
+// LoadKeyFile reads a 32-byte key from a file. The file may contain the raw
+// bytes or their hex or base64 encoding.
+func LoadKeyFile(id, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	if len(data) == keySize {
+		return NewKey(id, data)
+	}
+
+	text := bytes.TrimSpace(data)
+	if raw, err := hex.DecodeString(string(text)); err == nil && len(raw) == keySize {
+		return NewKey(id, raw)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(string(text)); err == nil && len(raw) == keySize {
+		return NewKey(id, raw)
+	}
+
+	return nil, fmt.Errorf("key file %s does not contain a %d-byte key (raw, hex or base64)", path, keySize)
+}
+
+// PassphraseKey creates a key derived from a passphrase. A fresh random salt
+// is used for every backup and stored in the stream header.
+func PassphraseKey(id, passphrase string, kdf KDF) (*Key, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase for encryption key %q is empty", id)
+	}
+	if kdf == "" {
+		kdf = KDFArgon2id
+	}
+	if kdf != KDFArgon2id && kdf != KDFScrypt {
+		return nil, fmt.Errorf("unsupported key derivation function: %s", kdf)
+	}
+
+	return &Key{
+		ID:       id,
+		kdf:      kdf,
+		material: []byte(passphrase),
+	}, nil
+}
+
+// derive returns the AES key for a stream with the given salt
+func (k *Key) derive(salt []byte) ([]byte, error) {
+	switch k.kdf {
+	case KDFNone:
+		return k.material, nil
+	case KDFArgon2id:
+		return argon2.IDKey(k.material, salt, 3, 64*1024, 4, keySize), nil
+	case KDFScrypt:
+		return scrypt.Key(k.material, salt, 1<<15, 8, 1, keySize)
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function: %s", k.kdf)
+	}
+}
+
+// aead creates the cipher for a stream with the given salt
+func (k *Key) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := k.derive(salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key %q: %w", k.ID, err)
+	}
+	return newAEAD(key)
+}
+
+// validateID checks that a key ID fits in the stream header
+func validateID(id string) error {
+	if id == "" {
+		return fmt.Errorf("encryption key ID is required")
+	}
+	if len(id) > 255 {
+		return fmt.Errorf("encryption key ID %q is too long", id)
+	}
+	return nil
+}
+
+// Keyring holds the keys of a storage. New backups are encrypted with the
+// active key; retired keys are kept so older backups can still be restored.
+type Keyring struct {
+	keys   map[string]*Key
+	active string
+}
+
+// NewKeyring creates a keyring with the given keys and active key ID
+func NewKeyring(keys []*Key, active string) (*Keyring, error) {
+	k := &Keyring{
+		keys:   make(map[string]*Key),
+		active: active,
+	}
+	for _, key := range keys {
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate encryption key %q", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+
+	if active != "" {
+		if _, ok := k.keys[active]; !ok {
+			return nil, fmt.Errorf("active encryption key %q not found", active)
+		}
+	}
+
+	return k, nil
+}
+
+// Active returns the key used for new backups, or nil if encryption is disabled
+func (k *Keyring) Active() *Key {
+	if k == nil || k.active == "" {
+		return nil
+	}
+	return k.keys[k.active]
+}
+
+// Get returns the key with the given ID
+func (k *Keyring) Get(id string) (*Key, error) {
+	if k == nil {
+		return nil, fmt.Errorf("backup is encrypted with key %q but no encryption keys are configured", id)
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %q not found", id)
+	}
+	return key, nil
+}
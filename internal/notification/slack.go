@@ -87,7 +87,33 @@ func (n *SlackNotifier) Notify(ctx context.Context, event NotificationEvent) err
 		return fmt.Errorf("slack webhook URL not configured")
 	}
 
-	return fmt.Errorf("slack notification not implemented yet")
+	payload, err := n.buildSlackPayload(event)
+	if err != nil {
+		return fmt.Errorf("failed to build slack payload: %w", err)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal slack payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create slack request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send slack notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack webhook returned status %s", resp.Status)
+	}
+
+	return nil
 }
 
 // buildSlackPayload builds the Slack webhook payload
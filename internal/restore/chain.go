@@ -0,0 +1,167 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/yourusername/backyardBackup/internal/backup"
+	"github.com/yourusername/backyardBackup/internal/database"
+)
+
+// chainLink is a backup of a restore chain with its manifest, which is nil
+// for backups taken before manifests were introduced
+type chainLink struct {
+	Backup   *backup.BackupResult
+	Manifest *backup.Manifest
+}
+
+// format returns the incremental or differential format of the backup;
+// empty for full backups and complete dumps
+func (l *chainLink) format() string {
+	switch {
+	case l.Manifest == nil:
+		return ""
+	case l.Manifest.Incremental != nil:
+		return l.Manifest.Incremental.Format
+	case l.Manifest.Differential != nil:
+		return l.Manifest.Differential.Format
+	default:
+		return ""
+	}
+}
+
+// resolveChain returns the backups from the full backup a backup builds on up
+// to the backup itself, oldest first. The whole chain is checked before
+// anything is restored: every backup must be in the catalog and in storage,
+// have completed successfully and have been taken after its parent.
+func (r *SelectiveRestorer) resolveChain(ctx context.Context, backupInfo *backup.BackupResult) ([]*chainLink, error) {
+	link, err := r.newChainLink(ctx, backupInfo)
+	if err != nil {
+		return nil, err
+	}
+	chain := []*chainLink{link}
+	seen := map[string]bool{backupInfo.ID: true}
+
+	for chain[0].Backup.Type != backup.Full {
+		child := chain[0].Backup
+		parentID := child.BaseBackupID
+		if parentID == "" {
+			return nil, fmt.Errorf("%s backup %s has no parent backup", child.Type, child.ID)
+		}
+		if seen[parentID] {
+			return nil, fmt.Errorf("backup chain of %s contains a cycle at %s", backupInfo.ID, parentID)
+		}
+		seen[parentID] = true
+
+		parentInfo, err := r.Backups.GetBackup(ctx, parentID)
+		if err != nil {
+			return nil, fmt.Errorf("backup chain of %s is broken: parent %s of %s backup %s is missing: %w", backupInfo.ID, parentID, child.Type, child.ID, err)
+		}
+		parent, err := r.newChainLink(ctx, parentInfo)
+		if err != nil {
+			return nil, fmt.Errorf("backup chain of %s is broken: %w", backupInfo.ID, err)
+		}
+		if err := checkLink(parent, chain[0]); err != nil {
+			return nil, fmt.Errorf("backup chain of %s is invalid: %w", backupInfo.ID, err)
+		}
+		chain = append([]*chainLink{parent}, chain...)
+	}
+
+	return chain, nil
+}
+
+// newChainLink checks that a backup can be restored and reads its manifest
+func (r *SelectiveRestorer) newChainLink(ctx context.Context, b *backup.BackupResult) (*chainLink, error) {
+	if !b.Success {
+		return nil, fmt.Errorf("%s backup %s did not complete successfully", b.Type, b.ID)
+	}
+	if _, err := r.Storage.GetInfo(ctx, b.StoragePath); err != nil {
+		return nil, fmt.Errorf("%s backup %s is missing from storage: %w", b.Type, b.ID, err)
+	}
+
+	link := &chainLink{Backup: b}
+	if manifest, err := backup.ReadManifest(ctx, r.Storage, b.StoragePath); err == nil {
+		link.Manifest = manifest
+	}
+	return link, nil
+}
+
+// checkLink checks that a backup can be applied on top of its parent
+func checkLink(parent, child *chainLink) error {
+	p, c := parent.Backup, child.Backup
+	if p.SourceDB != c.SourceDB {
+		return fmt.Errorf("parent %s of backup %s belongs to database %s, not %s", p.ID, c.ID, p.SourceDB, c.SourceDB)
+	}
+	if !p.StartTime.Before(c.StartTime) {
+		return fmt.Errorf("parent %s of backup %s was taken after it", p.ID, c.ID)
+	}
+
+	switch {
+	case c.Type == backup.Differential && p.Type != backup.Full:
+		return fmt.Errorf("differential backup %s is based on %s backup %s instead of a full backup", c.ID, p.Type, p.ID)
+	case p.Type != backup.Full && p.Type != backup.Incremental:
+		return fmt.Errorf("%s backup %s cannot be the parent of backup %s", p.Type, p.ID, c.ID)
+	}
+
+	// Log-based backups must continue exactly where their parent ends
+	if child.format() == backup.FormatLog && parent.Manifest != nil {
+		start, end := child.Manifest.Incremental.LogStart, parent.Manifest.LogEnd()
+		if start != nil && end != nil && (start.File != end.File || start.Position != end.Position) {
+			return fmt.Errorf("log-based backup %s does not start where its parent %s ends", c.ID, p.ID)
+		}
+	}
+	return nil
+}
+
+// applyChain restores a chain of backups, oldest first. Restoring starts at
+// the newest backup that is complete on its own; the backups after it are
+// applied on top in order: log-based backups are replayed and table
+// differentials replace the tables that changed. tables limits the tables
+// table differentials drop and replace; nil means all of them.
+func (r *SelectiveRestorer) applyChain(ctx context.Context, chain []*chainLink, tables []string, fn func(stream io.Reader) error) error {
+	start := 0
+	for i, link := range chain {
+		if link.format() == "" {
+			start = i
+		}
+	}
+
+	for _, link := range chain[start:] {
+		var err error
+		if link.format() == backup.FormatTables {
+			err = r.applyTableDiff(ctx, link.Backup, link.Manifest.Differential, tables, fn)
+		} else {
+			err = r.retrieve(ctx, link.Backup, fn)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply %s backup %s: %w", link.Backup.Type, link.Backup.ID, err)
+		}
+	}
+	return nil
+}
+
+// applyTableDiff drops the tables that were removed since the base of a
+// differential backup and replaces the tables that changed, of those in
+// tables unless it is nil
+func (r *SelectiveRestorer) applyTableDiff(ctx context.Context, backupInfo *backup.BackupResult, metadata *backup.DifferentialMetadata, tables []string, fn func(stream io.Reader) error) error {
+	dropped, changed := metadata.DroppedTables, metadata.ChangedTables
+	if tables != nil {
+		dropped, changed = keepTables(dropped, tables), keepTables(changed, tables)
+	}
+
+	if len(dropped) > 0 {
+		dropper, ok := r.DB.(database.TableStateConnector)
+		if !ok {
+			return fmt.Errorf("database cannot drop tables removed since the base backup")
+		}
+		if err := dropper.DropTables(ctx, dropped); err != nil {
+			return err
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+	return r.retrieve(ctx, backupInfo, fn)
+}
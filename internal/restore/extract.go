@@ -0,0 +1,132 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/backyardBackup/internal/backup"
+	"github.com/yourusername/backyardBackup/internal/database"
+)
+
+// sqliteHeader starts every SQLite database file
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// Extract writes a backup, decrypted and decompressed, to a file in dir
+// instead of restoring it into a database. Page-level backups are
+// reassembled from their chain first. Backups that are applied on top of
+// other backups, like log-based incremental and table differential backups,
+// cannot be written to a single file.
+func (r *SelectiveRestorer) Extract(ctx context.Context, opts RestoreOptions, dir string) (*RestoreResult, error) {
+	if r.Storage == nil {
+		return nil, fmt.Errorf("storage provider not initialized")
+	}
+	if r.Backups == nil {
+		return nil, fmt.Errorf("backup service not initialized")
+	}
+
+	backupInfo, err := r.Backups.GetBackup(ctx, opts.BackupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup info: %w", err)
+	}
+
+	result := &RestoreResult{
+		ID:        uuid.New().String(),
+		BackupID:  backupInfo.ID,
+		StartTime: time.Now(),
+	}
+	r.restores[result.ID] = result
+
+	fail := func(err error) (*RestoreResult, error) {
+		result.Success = false
+		result.ErrorMessage = err.Error()
+		result.EndTime = time.Now()
+		return result, err
+	}
+
+	chain, err := r.resolveChain(ctx, backupInfo)
+	if err != nil {
+		return fail(err)
+	}
+	last := chain[len(chain)-1]
+	if format := last.format(); format == backup.FormatLog || format == backup.FormatTables {
+		return fail(fmt.Errorf("%s backup %s is applied on top of other backups and cannot be written to a file", backupInfo.Type, backupInfo.ID))
+	}
+	for _, link := range chain {
+		result.Chain = append(result.Chain, link.Backup.ID)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fail(fmt.Errorf("failed to create output directory: %w", err))
+	}
+
+	// Write to a temporary file, so a failed restore leaves nothing behind
+	file, err := os.CreateTemp(dir, "."+backupInfo.ID+"-*")
+	if err != nil {
+		return fail(fmt.Errorf("failed to create output file: %w", err))
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	write := func(stream io.Reader) error {
+		_, err := io.Copy(file, stream)
+		return err
+	}
+	if last.format() == backup.FormatPages {
+		err = r.restorePageChain(ctx, chain, write)
+	} else {
+		err = r.retrieve(ctx, backupInfo, write)
+	}
+	if err != nil {
+		return fail(fmt.Errorf("failed to write backup: %w", err))
+	}
+
+	// Name the file after the backup, with an extension matching its format
+	header := make([]byte, len(sqliteHeader))
+	n, _ := file.ReadAt(header, 0)
+	var dbType database.DBType
+	if last.Manifest != nil {
+		dbType = last.Manifest.Engine.Type
+	}
+	path := filepath.Join(dir, backupInfo.ID+dumpExtension(dbType, header[:n]))
+	if _, err := os.Stat(path); err == nil {
+		return fail(fmt.Errorf("output file %s already exists", path))
+	}
+	if err := file.Close(); err != nil {
+		return fail(fmt.Errorf("failed to write output file: %w", err))
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fail(fmt.Errorf("failed to write output file: %w", err))
+	}
+
+	result.OutputPath = path
+	result.TablesRestored = backupInfo.Tables
+	result.Success = true
+	result.EndTime = time.Now()
+	return result, nil
+}
+
+// dumpExtension returns the file extension of a backup of a database type,
+// given the first bytes of the backup
+func dumpExtension(dbType database.DBType, header []byte) string {
+	switch dbType {
+	case database.SQLite:
+		if bytes.Equal(header, sqliteHeader) {
+			return ".db"
+		}
+		return ".sql"
+	case database.MySQL:
+		return ".sql"
+	case database.PostgreSQL:
+		return ".dump"
+	case database.MongoDB:
+		return ".archive.gz"
+	default:
+		return ""
+	}
+}
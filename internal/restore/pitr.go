@@ -0,0 +1,297 @@
+package restore
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/backyardBackup/internal/backup"
+	"github.com/yourusername/backyardBackup/internal/database"
+)
+
+// RecoverySignalFile makes PostgreSQL start in targeted recovery mode
+const RecoverySignalFile = "recovery.signal"
+
+// findRecoveryBase returns the newest successful backup that the recovery
+// target can be reached from: a physical backup, or a logical backup with a
+// known log position if the database can replay archived logs
+func (r *SelectiveRestorer) findRecoveryBase(ctx context.Context, opts RestoreOptions) (*backup.BackupResult, error) {
+	backups, err := r.Backups.ListBackups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var targetLSN uint64
+	if opts.TargetLSN != "" {
+		if targetLSN, err = parseLSN(opts.TargetLSN); err != nil {
+			return nil, err
+		}
+	}
+
+	_, replays := r.DB.(database.LogReplayer)
+	var base *backup.BackupResult
+	for _, b := range backups {
+		if !b.Success {
+			continue
+		}
+		if opts.SourceDB != "" && b.SourceDB != opts.SourceDB {
+			continue
+		}
+		if base != nil && !b.StartTime.After(base.StartTime) {
+			continue
+		}
+
+		switch {
+		case b.Type == backup.Physical:
+			// A physical backup is only consistent once it has finished
+			if !opts.PointInTime.IsZero() && b.EndTime.After(opts.PointInTime) {
+				continue
+			}
+			if opts.TargetLSN != "" {
+				manifest, err := backup.ReadManifest(ctx, r.Storage, b.StoragePath)
+				if err != nil || manifest.Physical == nil || manifest.Physical.Start == nil {
+					continue
+				}
+				startLSN, err := parseLSN(manifest.Physical.Start.Position)
+				if err != nil || startLSN > targetLSN {
+					continue
+				}
+			}
+		case replays && opts.TargetLSN == "" && (b.Type == backup.Full || b.Type == backup.Incremental):
+			// A logical backup is consistent with the log position it recorded
+			manifest, err := backup.ReadManifest(ctx, r.Storage, b.StoragePath)
+			if err != nil {
+				continue
+			}
+			if end := manifest.LogEnd(); end == nil || end.Time.After(opts.PointInTime) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		base = b
+	}
+
+	if base == nil {
+		return nil, fmt.Errorf("no backup found to recover to the requested target")
+	}
+	return base, nil
+}
+
+// restorePhysical unpacks a physical backup into opts.DataDir and configures
+// PostgreSQL to replay archived WAL up to the recovery target when it is
+// started on that directory
+func (r *SelectiveRestorer) restorePhysical(ctx context.Context, backupInfo *backup.BackupResult, opts RestoreOptions) (*RestoreResult, error) {
+	result := &RestoreResult{
+		ID:        uuid.New().String(),
+		BackupID:  backupInfo.ID,
+		StartTime: time.Now(),
+	}
+	r.restores[result.ID] = result
+
+	fail := func(err error) (*RestoreResult, error) {
+		result.Success = false
+		result.ErrorMessage = err.Error()
+		result.EndTime = time.Now()
+		return result, err
+	}
+
+	if opts.DataDir == "" {
+		return fail(fmt.Errorf("a data directory is required to restore a physical backup"))
+	}
+	if entries, err := os.ReadDir(opts.DataDir); err == nil && len(entries) > 0 {
+		return fail(fmt.Errorf("data directory %s is not empty", opts.DataDir))
+	}
+	recovering := !opts.PointInTime.IsZero() || opts.TargetLSN != ""
+	if recovering && r.WALCommand == "" {
+		return fail(fmt.Errorf("a WAL fetch command is required for point-in-time recovery"))
+	}
+
+	// PostgreSQL refuses data directories other users can read
+	if err := os.MkdirAll(opts.DataDir, 0700); err != nil {
+		return fail(fmt.Errorf("failed to create data directory: %w", err))
+	}
+
+	err := r.retrieve(ctx, backupInfo, func(stream io.Reader) error {
+		return extractTar(stream, opts.DataDir)
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to restore physical backup: %w", err))
+	}
+
+	if r.WALCommand != "" {
+		if err := writeRecoveryConfig(opts.DataDir, r.WALCommand, opts); err != nil {
+			return fail(err)
+		}
+	}
+
+	result.OutputPath = opts.DataDir
+	result.Success = true
+	result.EndTime = time.Now()
+	return result, nil
+}
+
+// replayLogs replays the archived log files that follow a restored logical
+// backup, up to the recovery target
+func (r *SelectiveRestorer) replayLogs(ctx context.Context, manifest *backup.Manifest, until time.Time) error {
+	replayer, ok := r.DB.(database.LogReplayer)
+	if !ok {
+		return fmt.Errorf("database does not support point-in-time recovery")
+	}
+	if r.Logs == nil {
+		return fmt.Errorf("a log archive is required for point-in-time recovery")
+	}
+	if manifest == nil || manifest.LogEnd() == nil {
+		return fmt.Errorf("backup has no log position to replay from")
+	}
+	from := manifest.LogEnd()
+
+	segments, err := r.Logs.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "backyard-logs-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary log directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for _, segment := range segments {
+		if !segment.NeededAfter(from) {
+			continue
+		}
+		if segment.Start != nil && segment.Start.Time.After(until) {
+			break
+		}
+		if len(files) == 0 && from.File != "" && segment.Name != from.File {
+			return fmt.Errorf("log file %s is not archived", from.File)
+		}
+
+		path := filepath.Join(dir, segment.Name)
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create log file: %w", err)
+		}
+		err = r.Logs.Fetch(ctx, segment.Name, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		files = append(files, path)
+	}
+	if len(files) == 0 && from.File != "" {
+		return fmt.Errorf("log file %s is not archived", from.File)
+	}
+
+	return replayer.ReplayLogs(ctx, files, from, until)
+}
+
+// writeRecoveryConfig makes PostgreSQL fetch archived WAL on startup and
+// replay it up to the recovery target, or to the end of the archive
+func writeRecoveryConfig(dataDir, walCommand string, opts RestoreOptions) error {
+	var settings strings.Builder
+	settings.WriteString("\n# Point-in-time recovery configured by backyardBackup\n")
+	fmt.Fprintf(&settings, "restore_command = %s\n", quoteSetting(walCommand))
+	switch {
+	case !opts.PointInTime.IsZero():
+		fmt.Fprintf(&settings, "recovery_target_time = %s\n", quoteSetting(opts.PointInTime.Format("2006-01-02 15:04:05.999999-07:00")))
+	case opts.TargetLSN != "":
+		fmt.Fprintf(&settings, "recovery_target_lsn = %s\n", quoteSetting(opts.TargetLSN))
+	}
+	settings.WriteString("recovery_target_action = 'promote'\n")
+
+	file, err := os.OpenFile(filepath.Join(dataDir, "postgresql.auto.conf"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open postgresql.auto.conf: %w", err)
+	}
+	if _, err := file.WriteString(settings.String()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write recovery settings: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write recovery settings: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dataDir, RecoverySignalFile), nil, 0600); err != nil {
+		return fmt.Errorf("failed to create %s: %w", RecoverySignalFile, err)
+	}
+	return nil
+}
+
+// quoteSetting quotes a value for a PostgreSQL configuration file
+func quoteSetting(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// parseLSN parses a PostgreSQL log sequence number such as 0/16B3748
+func parseLSN(lsn string) (uint64, error) {
+	high, low, ok := strings.Cut(lsn, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	h, err := strconv.ParseUint(high, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	l, err := strconv.ParseUint(low, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	return h<<32 | l, nil
+}
+
+// extractTar unpacks a tar archive into a directory
+func extractTar(r io.Reader, dir string) error {
+	root := filepath.Clean(dir)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		target := filepath.Join(root, header.Name)
+		if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+			return fmt.Errorf("archive entry %q is outside the data directory", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return fmt.Errorf("failed to create file: %w", err)
+			}
+			if _, err := io.Copy(file, tr); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to write %s: %w", header.Name, err)
+			}
+			if err := file.Close(); err != nil {
+				return fmt.Errorf("failed to write %s: %w", header.Name, err)
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return fmt.Errorf("failed to create symlink: %w", err)
+			}
+		}
+	}
+}
@@ -8,10 +8,14 @@ import (
 // RestoreOptions contains configuration for a restore operation
 type RestoreOptions struct {
 	BackupID       string
-	TargetDB       string
+	TargetDB       string    // Database to restore into instead of the restorer's own
 	IncludeTables  []string
 	ExcludeTables  []string
 	PointInTime    time.Time // For point-in-time recovery
+	TargetLSN      string    // For point-in-time recovery to a log position
+	SourceDB       string    // Database whose backups are searched when BackupID is empty
+	DataDir        string    // Directory physical backups are restored into
+	NoSnapshot     bool      // Restore without a snapshot to roll back to on failure; a snapshot is a full logical copy of the restored tables unless the database is a single file
 	OverwriteExisting bool
 }
 
@@ -22,6 +26,10 @@ type RestoreResult struct {
 	StartTime      time.Time
 	EndTime        time.Time
 	TablesRestored []string
+	Chain          []string // Backups the restore was built from, oldest first
+	OutputPath     string // Directory or file written instead of restoring into the database
+	SnapshotID     string // Snapshot of the database taken before the restore
+	RolledBack     bool   // Whether the database was rolled back to the snapshot after a failure
 	Success        bool
 	ErrorMessage   string
 }
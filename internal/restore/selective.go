@@ -1,14 +1,18 @@
 package restore
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/yourusername/backyardBackup/internal/backup"
+	"github.com/yourusername/backyardBackup/internal/compression"
 	"github.com/yourusername/backyardBackup/internal/database"
+	"github.com/yourusername/backyardBackup/internal/encryption"
 	"github.com/yourusername/backyardBackup/internal/storage"
 )
 
@@ -17,6 +21,12 @@ type SelectiveRestorer struct {
 	DB      database.Connector
 	Storage storage.Provider
 	Backups backup.Backuper
+	Keys    *encryption.Keyring // Keys for encrypted backups
+	WALCommand string // Command PostgreSQL runs to fetch archived WAL, with %f and %p placeholders
+	SnapshotDir string // Directory pre-restore snapshots are written to; the temporary directory if empty
+	RollbackTimeout time.Duration // Bound on rolling back to a snapshot; DefaultRollbackTimeout if zero
+	Logs    *backup.LogArchive // Archived logs replayed for point-in-time recovery of logical backups
+	OpenTarget func(ctx context.Context, name string) (database.Connector, error) // Connects to the database named by RestoreOptions.TargetDB
 	restores map[string]*RestoreResult
 }
 
@@ -32,15 +42,44 @@ func NewSelectiveRestorer(db database.Connector, storage storage.Provider, backu
 
 // Restore performs a selective database restore according to the provided options
 func (r *SelectiveRestorer) Restore(ctx context.Context, opts RestoreOptions) (*RestoreResult, error) {
-	if r.DB == nil {
-		return nil, fmt.Errorf("database connector not initialized")
-	}
 	if r.Storage == nil {
 		return nil, fmt.Errorf("storage provider not initialized")
 	}
 	if r.Backups == nil {
 		return nil, fmt.Errorf("backup service not initialized")
 	}
+	if !opts.PointInTime.IsZero() && opts.TargetLSN != "" {
+		return nil, fmt.Errorf("only one of a point in time and a target LSN can be given")
+	}
+
+	// Restore into another database instead of the one the restorer was
+	// created with, which is left untouched
+	if opts.TargetDB != "" {
+		if opts.TargetLSN != "" {
+			return nil, fmt.Errorf("physical backups are restored into a data directory, not a target database")
+		}
+		if r.OpenTarget == nil {
+			return nil, fmt.Errorf("restoring into another database is not supported")
+		}
+		target, err := r.OpenTarget(ctx, opts.TargetDB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open target database %s: %w", opts.TargetDB, err)
+		}
+		defer target.Close()
+
+		source := r.DB
+		r.DB = target
+		defer func() { r.DB = source }()
+	}
+
+	// Point-in-time recovery starts from the newest backup before the target
+	if opts.BackupID == "" && (!opts.PointInTime.IsZero() || opts.TargetLSN != "") {
+		base, err := r.findRecoveryBase(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		opts.BackupID = base.ID
+	}
 
 	// Get backup details
 	backupInfo, err := r.Backups.GetBackup(ctx, opts.BackupID)
@@ -48,6 +87,36 @@ func (r *SelectiveRestorer) Restore(ctx context.Context, opts RestoreOptions) (*
 		return nil, fmt.Errorf("failed to get backup info: %w", err)
 	}
 
+	// Physical backups are restored into a data directory rather than the database
+	if backupInfo.Type == backup.Physical {
+		if opts.TargetDB != "" {
+			return nil, fmt.Errorf("physical backups are restored into a data directory, not a target database")
+		}
+		if len(opts.IncludeTables) > 0 || len(opts.ExcludeTables) > 0 {
+			return nil, fmt.Errorf("tables cannot be selected when restoring a physical backup")
+		}
+		return r.restorePhysical(ctx, backupInfo, opts)
+	}
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connector not initialized")
+	}
+
+	// Restore only some of the tables of the backup, leaving the others in
+	// the database alone
+	tables, err := selectTables(r.DB.Type(), backupInfo, opts.IncludeTables, opts.ExcludeTables)
+	if err != nil {
+		return nil, err
+	}
+	tableRestorer, ok := r.DB.(database.TableRestorer)
+	if tables != nil {
+		if !ok {
+			return nil, fmt.Errorf("%s databases cannot restore single tables", r.DB.Type())
+		}
+		if !opts.PointInTime.IsZero() {
+			return nil, fmt.Errorf("point-in-time recovery cannot be limited to tables")
+		}
+	}
+
 	// Create restore ID
 	restoreID := uuid.New().String()
 
@@ -61,6 +130,114 @@ func (r *SelectiveRestorer) Restore(ctx context.Context, opts RestoreOptions) (*
 	// Store result
 	r.restores[restoreID] = result
 
+	fail := func(err error) (*RestoreResult, error) {
+		result.Success = false
+		result.ErrorMessage = err.Error()
+		result.EndTime = time.Now()
+		return result, err
+	}
+
+	// Incremental and differential backups are applied on top of the chain
+	// of backups they build on. Page-level chains are reassembled into a
+	// database file first.
+	restore := func(stream io.Reader) error {
+		if tables != nil {
+			if err := tableRestorer.RestoreTables(ctx, stream, tables); err != nil {
+				return fmt.Errorf("failed to restore tables: %w", err)
+			}
+			return nil
+		}
+		if err := r.DB.Restore(ctx, stream); err != nil {
+			return fmt.Errorf("failed to restore database: %w", err)
+		}
+		return nil
+	}
+	chain, err := r.resolveChain(ctx, backupInfo)
+	if err == nil && opts.TargetDB != "" {
+		err = checkTarget(chain, r.DB)
+	}
+	if err == nil && tables != nil {
+		for _, link := range chain {
+			if link.format() == backup.FormatLog {
+				err = fmt.Errorf("log-based backup %s cannot be restored table by table", link.Backup.ID)
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fail(err)
+	}
+	for _, link := range chain {
+		result.Chain = append(result.Chain, link.Backup.ID)
+	}
+
+	// Copy the current data first, so a failed restore does not lose it
+	var snap *snapshot
+	if !opts.NoSnapshot {
+		if snap, err = r.takeSnapshot(ctx, tables); err != nil {
+			return fail(fmt.Errorf("failed to take pre-restore snapshot: %w", err))
+		}
+		result.SnapshotID = snap.ID
+	}
+
+	if chain[len(chain)-1].format() == backup.FormatPages {
+		err = r.restorePageChain(ctx, chain, restore)
+	} else {
+		err = r.applyChain(ctx, chain, tables, restore)
+	}
+	if err == nil && !opts.PointInTime.IsZero() {
+		err = r.replayLogs(ctx, chain[len(chain)-1].Manifest, opts.PointInTime)
+	}
+
+	// The restored tables are those of the backup
+	restored := tables
+	if restored == nil {
+		restored = backupInfo.Tables
+	}
+	if err == nil {
+		restored, err = r.validateRestore(ctx, restored)
+	}
+
+	if err != nil {
+		if snap != nil {
+			if rollbackErr := r.rollback(snap); rollbackErr != nil {
+				err = fmt.Errorf("%w; rolling back to snapshot %s failed, it is kept at %s: %v", err, snap.ID, snap.Path, rollbackErr)
+			} else {
+				snap.remove()
+				result.RolledBack = true
+				err = fmt.Errorf("%w; the database was rolled back to snapshot %s", err, snap.ID)
+			}
+		}
+		return fail(err)
+	}
+	if snap != nil {
+		snap.remove()
+	}
+
+	result.TablesRestored = restored
+	result.Success = true
+	result.EndTime = time.Now()
+
+	return result, nil
+}
+
+// checkTarget checks that a chain of backups can be restored into a target
+// database. Log-based backups contain binary log events that name the
+// database they were taken from, so they cannot be restored elsewhere.
+func checkTarget(chain []*chainLink, target database.Connector) error {
+	for _, link := range chain {
+		if link.Manifest != nil && link.Manifest.Engine.Type != "" && link.Manifest.Engine.Type != target.Type() {
+			return fmt.Errorf("%s backup %s cannot be restored into a %s database", link.Manifest.Engine.Type, link.Backup.ID, target.Type())
+		}
+		if link.format() == backup.FormatLog {
+			return fmt.Errorf("log-based backup %s cannot be restored into another database", link.Backup.ID)
+		}
+	}
+	return nil
+}
+
+// retrieve streams a backup from storage through its decode stages into fn
+func (r *SelectiveRestorer) retrieve(ctx context.Context, backupInfo *backup.BackupResult, fn func(stream io.Reader) error) error {
 	// Create a pipe for streaming backup data
 	pr, pw := io.Pipe()
 
@@ -76,40 +253,132 @@ func (r *SelectiveRestorer) Restore(ctx context.Context, opts RestoreOptions) (*
 		errCh <- nil
 	}()
 
-	// Restore the database
-	err = r.DB.Restore(ctx, pr)
+	// Undo the stream stages applied at backup time
+	stream, err := r.decodeStream(ctx, backupInfo, pr)
 	if err != nil {
-		result.Success = false
-		result.ErrorMessage = fmt.Sprintf("failed to restore database: %v", err)
-		result.EndTime = time.Now()
-		return result, fmt.Errorf("failed to restore database: %w", err)
+		pr.CloseWithError(err)
+		<-errCh
+		return err
+	}
+
+	if err := fn(stream); err != nil {
+		pr.CloseWithError(err)
+		<-errCh
+		return err
 	}
 
+	// Drain anything fn left unread so the retrieval can finish
+	io.Copy(io.Discard, stream)
+
 	// Wait for retrieval to complete
-	if err := <-errCh; err != nil {
-		result.Success = false
-		result.ErrorMessage = err.Error()
-		result.EndTime = time.Now()
-		return result, err
+	return <-errCh
+}
+
+// restorePageChain rebuilds a database from a chain of page-level backups by
+// applying the changed pages of every backup, oldest first, to a copy of the
+// full backup the chain starts from
+func (r *SelectiveRestorer) restorePageChain(ctx context.Context, chain []*chainLink, fn func(stream io.Reader) error) error {
+	for _, link := range chain[1:] {
+		if link.format() != backup.FormatPages {
+			return fmt.Errorf("%s backup %s is not page-level and cannot be part of a page-level chain", link.Backup.Type, link.Backup.ID)
+		}
 	}
 
-	// Get list of restored tables
-	tables, err := r.DB.ListTables(ctx)
+	file, err := os.CreateTemp("", "backyard-restore-*.db")
 	if err != nil {
-		result.Success = false
-		result.ErrorMessage = fmt.Sprintf("failed to list restored tables: %v", err)
-		result.EndTime = time.Now()
-		return result, fmt.Errorf("failed to list restored tables: %w", err)
+		return fmt.Errorf("failed to create temporary database file: %w", err)
 	}
+	defer os.Remove(file.Name())
+	defer file.Close()
 
-	result.TablesRestored = tables
-	result.Success = true
-	result.EndTime = time.Now()
+	full := chain[0].Backup
+	err = r.retrieve(ctx, full, func(stream io.Reader) error {
+		_, err := io.Copy(file, stream)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore full backup %s: %w", full.ID, err)
+	}
 
-	return result, nil
+	for _, link := range chain[1:] {
+		b := link.Backup
+		err := r.retrieve(ctx, b, func(stream io.Reader) error {
+			return backup.ApplyPageDiff(file, stream)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply %s backup %s: %w", b.Type, b.ID, err)
+		}
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read reassembled database: %w", err)
+	}
+	return fn(file)
+}
+
+// decodeStream wraps the raw backup stream with the decryption and
+// decompression stages recorded in the backup's manifest
+func (r *SelectiveRestorer) decodeStream(ctx context.Context, backupInfo *backup.BackupResult, raw io.Reader) (io.Reader, error) {
+	if _, err := r.Storage.GetInfo(ctx, backup.ManifestPath(backupInfo.StoragePath)); err != nil {
+		// Backups taken before manifests were introduced are recognised by
+		// their first bytes
+		return r.detectStream(raw)
+	}
+	manifest, err := backup.ReadManifest(ctx, r.Storage, backupInfo.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := raw
+	if manifest.Encryption != nil {
+		if manifest.Encryption.Algorithm != encryption.Algorithm {
+			return nil, fmt.Errorf("unsupported encryption algorithm: %s", manifest.Encryption.Algorithm)
+		}
+		stream, err = encryption.NewReader(stream, r.Keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt backup: %w", err)
+		}
+	}
+
+	compressor := &compression.Compressor{
+		Type:         manifest.Compression.Algorithm,
+		Level:        manifest.Compression.Level,
+		LongDistance: manifest.Compression.LongDistance,
+	}
+	stream, err = compressor.NewReader(stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress backup: %w", err)
+	}
+
+	return stream, nil
 }
 
-// ValidateBackup checks if a backup is valid and can be restored
+// detectStream decrypts and decompresses a backup without a manifest,
+// detecting both stages from the magic bytes of the stream
+func (r *SelectiveRestorer) detectStream(raw io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(raw)
+	header, err := br.Peek(8)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read backup data: %w", err)
+	}
+
+	var stream io.Reader = br
+	if encryption.IsEncrypted(header) {
+		stream, err = encryption.NewReader(stream, r.Keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt backup: %w", err)
+		}
+	}
+
+	stream, _, err = compression.NewDetectingReader(stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress backup: %w", err)
+	}
+	return stream, nil
+}
+
+// ValidateBackup checks if a backup is valid and can be restored. For
+// backups that cannot be restored, the error tells why.
 func (r *SelectiveRestorer) ValidateBackup(ctx context.Context, backupID string) (bool, error) {
 	if r.Backups == nil {
 		return false, fmt.Errorf("backup service not initialized")
@@ -121,15 +390,10 @@ func (r *SelectiveRestorer) ValidateBackup(ctx context.Context, backupID string)
 		return false, fmt.Errorf("failed to get backup info: %w", err)
 	}
 
-	// Check if backup exists and was successful
-	if !backup.Success {
-		return false, nil
-	}
-
-	// Check if backup file exists in storage
-	_, err = r.Storage.GetInfo(ctx, backup.StoragePath)
-	if err != nil {
-		return false, nil
+	// Check that the backup and every backup it builds on exist in storage
+	// and were successful
+	if _, err := r.resolveChain(ctx, backup); err != nil {
+		return false, err
 	}
 
 	return true, nil
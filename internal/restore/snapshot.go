@@ -0,0 +1,177 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/backyardBackup/internal/database"
+)
+
+// DefaultRollbackTimeout bounds a rollback when the restorer sets no timeout
+const DefaultRollbackTimeout = 30 * time.Minute
+
+// snapshot is a copy of a database taken before a restore, which the
+// database is rolled back to when the restore fails
+type snapshot struct {
+	ID     string
+	Path   string
+	Tables []string        // Tables a table-level restore replaces; nil for the whole database
+	Before map[string]bool // Tables in the database when the snapshot was taken
+}
+
+// existing returns the tables of a table-level restore that were in the
+// database when the snapshot was taken, which are those it holds
+func (s *snapshot) existing() []string {
+	tables := []string{}
+	for _, table := range s.Tables {
+		if s.Before[table] {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// takeSnapshot copies the database to a file in SnapshotDir. Databases that
+// are a single file are copied page by page, which is fastest. Others are
+// dumped, which costs as much as a backup of the same tables: all of them,
+// or those of tables that are already in the database for a table-level
+// restore.
+func (r *SelectiveRestorer) takeSnapshot(ctx context.Context, tables []string) (*snapshot, error) {
+	dir := r.SnapshotDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	// Snapshots contain the data of the database
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	// Tables the restore creates are dropped again by a rollback
+	present, err := r.DB.ListTables(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	snap := &snapshot{ID: uuid.New().String(), Tables: tables, Before: make(map[string]bool, len(present))}
+	for _, table := range present {
+		snap.Before[table] = true
+	}
+
+	snap.Path = filepath.Join(dir, "snapshot-"+snap.ID)
+	file, err := os.OpenFile(snap.Path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+
+	if pages, ok := r.DB.(database.PageConnector); ok {
+		err = pages.ReadPages(ctx, func(stream io.Reader, pageSize int) error {
+			_, err := io.Copy(file, stream)
+			return err
+		})
+	} else if tables == nil {
+		err = r.DB.Backup(ctx, file, nil)
+	} else if existing := snap.existing(); len(existing) > 0 {
+		// An empty list of tables would dump all of them
+		err = r.DB.Backup(ctx, file, existing)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(snap.Path)
+		return nil, err
+	}
+	return snap, nil
+}
+
+// rollback restores the database from a snapshot and drops the tables the
+// failed restore created, of those it restored for a table-level restore
+func (r *SelectiveRestorer) rollback(snap *snapshot) error {
+	// The restore may have failed because its context was cancelled
+	timeout := r.RollbackTimeout
+	if timeout <= 0 {
+		timeout = DefaultRollbackTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	file, err := os.Open(snap.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	// A table-level rollback leaves the other tables alone
+	scope := snap.Tables
+	if snap.Tables != nil {
+		if existing := snap.existing(); len(existing) > 0 {
+			restorer, ok := r.DB.(database.TableRestorer)
+			if !ok {
+				return fmt.Errorf("%s databases cannot restore single tables", r.DB.Type())
+			}
+			if err := restorer.RestoreTables(ctx, file, existing); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := r.DB.Restore(ctx, file); err != nil {
+			return err
+		}
+		if scope, err = r.DB.ListTables(ctx); err != nil {
+			return fmt.Errorf("failed to list tables: %w", err)
+		}
+	}
+
+	var created []string
+	for _, table := range scope {
+		if !snap.Before[table] {
+			created = append(created, table)
+		}
+	}
+	if len(created) == 0 {
+		return nil
+	}
+
+	dropper, ok := r.DB.(database.TableDropper)
+	if !ok {
+		return fmt.Errorf("%s databases cannot drop the tables the restore created: %s", r.DB.Type(), strings.Join(created, ", "))
+	}
+	if err := dropper.DropTables(ctx, created); err != nil {
+		return fmt.Errorf("failed to drop the tables the restore created: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the snapshot once it is no longer needed
+func (s *snapshot) remove() {
+	os.Remove(s.Path)
+}
+
+// validateRestore checks that the restored tables are in the database and
+// returns them. Backups that do not record their tables restored whatever
+// the database now contains.
+func (r *SelectiveRestorer) validateRestore(ctx context.Context, tables []string) ([]string, error) {
+	present, err := r.DB.ListTables(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list restored tables: %w", err)
+	}
+	if len(tables) == 0 {
+		return present, nil
+	}
+
+	exists := make(map[string]bool, len(present))
+	for _, table := range present {
+		exists[table] = true
+	}
+	for _, table := range tables {
+		if !exists[table] {
+			return nil, fmt.Errorf("table %s is missing after the restore", table)
+		}
+	}
+	return tables, nil
+}
@@ -0,0 +1,79 @@
+package restore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yourusername/backyardBackup/internal/backup"
+	"github.com/yourusername/backyardBackup/internal/database"
+)
+
+// selectTables returns the tables of a backup a restore is limited to by
+// include and exclude lists, or nil when all of them are restored. Included
+// tables must be in the backup.
+func selectTables(dbType database.DBType, backupInfo *backup.BackupResult, include, exclude []string) ([]string, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	include, exclude = backupTableNames(dbType, include), backupTableNames(dbType, exclude)
+
+	inBackup := make(map[string]bool, len(backupInfo.Tables))
+	for _, table := range backupInfo.Tables {
+		inBackup[table] = true
+	}
+	for _, table := range include {
+		if !inBackup[table] {
+			return nil, fmt.Errorf("table %s is not in backup %s", table, backupInfo.ID)
+		}
+	}
+
+	tables := backupInfo.Tables
+	if len(include) > 0 {
+		tables = keepTables(tables, include)
+	}
+	excluded := make(map[string]bool, len(exclude))
+	for _, table := range exclude {
+		excluded[table] = true
+	}
+	var selected []string
+	for _, table := range tables {
+		if !excluded[table] {
+			selected = append(selected, table)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no tables of backup %s are selected", backupInfo.ID)
+	}
+	return selected, nil
+}
+
+// backupTableNames returns the names tables are recorded under in backups.
+// PostgreSQL backups hold the tables of the public schema, which may be
+// qualified with it.
+func backupTableNames(dbType database.DBType, tables []string) []string {
+	if dbType != database.PostgreSQL {
+		return tables
+	}
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		names[i] = strings.TrimPrefix(table, "public.")
+	}
+	return names
+}
+
+// keepTables returns the tables that are also in selected, in order
+func keepTables(tables, selected []string) []string {
+	keep := make(map[string]bool, len(selected))
+	for _, table := range selected {
+		keep[table] = true
+	}
+
+	var kept []string
+	for _, table := range tables {
+		if keep[table] {
+			kept = append(kept, table)
+		}
+	}
+	return kept
+}
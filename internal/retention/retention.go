@@ -0,0 +1,391 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yourusername/backyardBackup/config"
+	"github.com/yourusername/backyardBackup/internal/backup"
+	"github.com/yourusername/backyardBackup/internal/catalog"
+	"github.com/yourusername/backyardBackup/internal/database"
+	"github.com/yourusername/backyardBackup/internal/logging"
+	"github.com/yourusername/backyardBackup/internal/storage"
+)
+
+// Policy defines which backups of a database are kept. A backup is kept if it
+// satisfies the flat limits (RetentionDays and MaxBackups) or is selected by
+// one of the grandfather-father-son rules (Hourly to Yearly).
+type Policy struct {
+	RetentionDays int // Delete backups older than this many days; 0 disables the limit
+	MaxBackups    int // Keep at most this many backups; 0 disables the limit
+	Hourly        int // Keep the newest backup of each of this many hours
+	Daily         int // Keep the newest backup of each of this many days
+	Weekly        int // Keep the newest backup of each of this many ISO weeks
+	Monthly       int // Keep the newest backup of each of this many months
+	Yearly        int // Keep the newest backup of each of this many years
+}
+
+// PolicyFromSchedule returns the retention policy configured in a schedule
+func PolicyFromSchedule(schedule config.BackupSchedule) Policy {
+	policy := Policy{
+		RetentionDays: schedule.RetentionDays,
+		MaxBackups:    schedule.MaxBackups,
+	}
+	if gfs := schedule.GFS; gfs != nil {
+		policy.Hourly = gfs.Hourly
+		policy.Daily = gfs.Daily
+		policy.Weekly = gfs.Weekly
+		policy.Monthly = gfs.Monthly
+		policy.Yearly = gfs.Yearly
+	}
+	return policy
+}
+
+// IsEmpty reports whether the policy keeps every backup
+func (p Policy) IsEmpty() bool {
+	return !p.hasFlatLimits() && !p.hasGFS()
+}
+
+// hasFlatLimits reports whether RetentionDays or MaxBackups is set
+func (p Policy) hasFlatLimits() bool {
+	return p.RetentionDays > 0 || p.MaxBackups > 0
+}
+
+// hasGFS reports whether any grandfather-father-son rule is set
+func (p Policy) hasGFS() bool {
+	return p.Hourly > 0 || p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0 || p.Yearly > 0
+}
+
+// Decision records whether a backup is kept and why
+type Decision struct {
+	Backup *backup.BackupResult
+	Keep   bool
+	Reason string
+}
+
+// Plan decides which backups to keep under a policy. Backups must belong to
+// a single database and storage. The most recent backup is always kept, and
+// a backup is never deleted while a kept incremental or differential backup
+// still depends on it. Decisions are returned oldest first.
+func Plan(backups []*backup.BackupResult, policy Policy, now time.Time) []*Decision {
+	decisions := make([]*Decision, len(backups))
+	byID := make(map[string]*Decision, len(backups))
+	for i, b := range backups {
+		decisions[i] = &Decision{Backup: b}
+		byID[b.ID] = decisions[i]
+	}
+
+	// Rank backups from newest to oldest
+	newest := sortedNewestFirst(decisions)
+
+	rules := policy.gfsRules()
+	for rank, d := range newest {
+		var reasons []string
+		if rank == 0 {
+			reasons = append(reasons, "most recent backup")
+		}
+		if policy.IsEmpty() {
+			reasons = append(reasons, "no retention policy")
+		}
+
+		// Flat limits
+		deleteReason := "not selected by any retention rule"
+		if policy.hasFlatLimits() {
+			if keep, reason := policy.flatDecision(rank, d.Backup.StartTime, now); keep {
+				reasons = append(reasons, reason)
+			} else {
+				deleteReason = reason
+			}
+		}
+
+		// Grandfather-father-son rules select the newest successful backup of each period
+		if d.Backup.Success {
+			for _, rule := range rules {
+				if reason, ok := rule.match(d.Backup.StartTime.In(now.Location())); ok {
+					reasons = append(reasons, reason)
+				}
+			}
+		}
+
+		d.Keep = len(reasons) > 0
+		if d.Keep {
+			d.Reason = strings.Join(reasons, ", ")
+		} else {
+			d.Reason = deleteReason
+		}
+	}
+
+	// Keep the bases of every kept backup
+	for _, d := range newest {
+		if !d.Keep {
+			continue
+		}
+		for id := d.Backup.BaseBackupID; id != ""; {
+			base, ok := byID[id]
+			if !ok || base.Keep {
+				break
+			}
+			base.Keep = true
+			base.Reason = fmt.Sprintf("base of kept backup %s", d.Backup.ID)
+			id = base.Backup.BaseBackupID
+		}
+	}
+
+	return decisions
+}
+
+// PlanAll decides which backups to keep under the policies of several
+// schedules that back up the same database to the same storage. A backup is
+// kept if any of the policies keeps it, so one schedule never deletes the
+// backups another schedule still keeps. Reasons name the schedule when there
+// is more than one. Decisions are returned oldest first.
+func PlanAll(backups []*backup.BackupResult, policies map[string]Policy, now time.Time) []*Decision {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	decisions := make([]*Decision, len(backups))
+	keepReasons := make([][]string, len(backups))
+	deleteReasons := make([][]string, len(backups))
+	for i, b := range backups {
+		decisions[i] = &Decision{Backup: b}
+	}
+	for _, name := range names {
+		prefix := ""
+		if len(names) > 1 {
+			prefix = name + ": "
+		}
+		// Plan returns the decisions in the order of the backups
+		for i, d := range Plan(backups, policies[name], now) {
+			if d.Keep {
+				decisions[i].Keep = true
+				keepReasons[i] = append(keepReasons[i], prefix+d.Reason)
+			} else {
+				deleteReasons[i] = append(deleteReasons[i], prefix+d.Reason)
+			}
+		}
+	}
+	for i, d := range decisions {
+		if d.Keep {
+			d.Reason = strings.Join(keepReasons[i], "; ")
+		} else {
+			d.Reason = strings.Join(deleteReasons[i], "; ")
+		}
+	}
+	return decisions
+}
+
+// flatDecision applies RetentionDays and MaxBackups to the backup with the
+// given rank (0 is the newest)
+func (p Policy) flatDecision(rank int, startTime, now time.Time) (bool, string) {
+	switch {
+	case p.MaxBackups > 0 && rank >= p.MaxBackups:
+		return false, fmt.Sprintf("exceeds the %d most recent backups", p.MaxBackups)
+	case p.RetentionDays > 0 && startTime.Before(now.AddDate(0, 0, -p.RetentionDays)):
+		return false, fmt.Sprintf("older than %d days", p.RetentionDays)
+	case p.RetentionDays > 0:
+		return true, fmt.Sprintf("within %d days", p.RetentionDays)
+	default:
+		return true, fmt.Sprintf("one of the %d most recent backups", p.MaxBackups)
+	}
+}
+
+// gfsRule keeps the newest backup of each of a number of periods
+type gfsRule struct {
+	name      string
+	remaining int
+	period    func(time.Time) string
+	last      string
+}
+
+// gfsRules returns the grandfather-father-son rules of the policy
+func (p Policy) gfsRules() []*gfsRule {
+	rules := []*gfsRule{
+		{name: "hourly", remaining: p.Hourly, period: func(t time.Time) string { return t.Format("2006-01-02 15:00") }},
+		{name: "daily", remaining: p.Daily, period: func(t time.Time) string { return t.Format("2006-01-02") }},
+		{name: "weekly", remaining: p.Weekly, period: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{name: "monthly", remaining: p.Monthly, period: func(t time.Time) string { return t.Format("2006-01") }},
+		{name: "yearly", remaining: p.Yearly, period: func(t time.Time) string { return t.Format("2006") }},
+	}
+
+	var active []*gfsRule
+	for _, rule := range rules {
+		if rule.remaining > 0 {
+			active = append(active, rule)
+		}
+	}
+	return active
+}
+
+// match reports whether a backup started at t is the newest of a new period.
+// Backups must be passed newest first.
+func (r *gfsRule) match(t time.Time) (string, bool) {
+	if r.remaining <= 0 {
+		return "", false
+	}
+
+	period := r.period(t)
+	if period == r.last {
+		return "", false
+	}
+	r.last = period
+	r.remaining--
+
+	return fmt.Sprintf("%s %s", r.name, period), true
+}
+
+// sortedNewestFirst returns the decisions ordered by backup start time, newest first
+func sortedNewestFirst(decisions []*Decision) []*Decision {
+	sorted := make([]*Decision, len(decisions))
+	copy(sorted, decisions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Backup.StartTime.After(sorted[j].Backup.StartTime)
+	})
+	return sorted
+}
+
+// Pruner deletes the backups of a storage that fall outside a retention policy
+type Pruner struct {
+	Storage     storage.Provider
+	Catalog     *catalog.Catalog
+	StorageName string
+	Logger      *logging.Logger
+	DryRun      bool               // Only report what would be deleted
+	Logs        *backup.LogArchive // Optional; archived logs older than every kept backup are deleted
+}
+
+// NewPruner creates a new pruner
+func NewPruner(store storage.Provider, cat *catalog.Catalog, storageName string, logger *logging.Logger) *Pruner {
+	return &Pruner{
+		Storage:     store,
+		Catalog:     cat,
+		StorageName: storageName,
+		Logger:      logger,
+	}
+}
+
+// Result contains the outcome of pruning a database
+type Result struct {
+	Decisions []*Decision            // Every backup considered, oldest first
+	Deleted   []*backup.BackupResult // Backups deleted (or that would be, in a dry run)
+	Errors    []error                // Backups that could not be deleted
+	Logs      []*backup.LogSegment   // Archived log files deleted (or that would be)
+}
+
+// Prune applies the retention policies of the schedules that back up a
+// database to the storage, keeping every backup any of them keeps
+func (p *Pruner) Prune(ctx context.Context, dbName string, policies map[string]Policy) (*Result, error) {
+	backups, err := p.Catalog.List(ctx, backup.CatalogFilter{
+		SourceDB: dbName,
+		Storage:  p.StorageName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	result := &Result{
+		Decisions: PlanAll(backups, policies, time.Now()),
+	}
+
+	// Delete newest first, so dependents are gone before their bases
+	deleted := make(map[string]bool)
+	for i := len(result.Decisions) - 1; i >= 0; i-- {
+		d := result.Decisions[i]
+		if d.Keep {
+			continue
+		}
+
+		// Backups recorded elsewhere in the catalog may also depend on this one
+		dependent, err := p.remainingDependent(ctx, d.Backup.ID, deleted)
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			d.Keep, d.Reason = true, "dependents could not be checked"
+			continue
+		}
+		if dependent != "" {
+			d.Keep, d.Reason = true, fmt.Sprintf("base of backup %s", dependent)
+			continue
+		}
+
+		if p.DryRun {
+			p.Logger.Info("Would delete backup %s (%s): %s", d.Backup.ID, d.Backup.StoragePath, d.Reason)
+			deleted[d.Backup.ID] = true
+			result.Deleted = append(result.Deleted, d.Backup)
+			continue
+		}
+
+		p.Logger.Info("Deleting backup %s (%s): %s", d.Backup.ID, d.Backup.StoragePath, d.Reason)
+		if err := backup.Remove(ctx, p.Storage, p.Catalog, d.Backup); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("failed to delete backup %s: %w", d.Backup.ID, err))
+			d.Keep, d.Reason = true, "deletion failed"
+			continue
+		}
+		deleted[d.Backup.ID] = true
+		result.Deleted = append(result.Deleted, d.Backup)
+	}
+
+	if p.Logs != nil {
+		if err := p.pruneLogs(ctx, result); err != nil {
+			result.Errors = append(result.Errors, err)
+		}
+	}
+
+	return result, nil
+}
+
+// pruneLogs deletes the archived log files that come before the log start of
+// the oldest kept backup that recovers from the log archive
+func (p *Pruner) pruneLogs(ctx context.Context, result *Result) error {
+	var keepFrom *database.LogPosition
+	for _, d := range result.Decisions {
+		if !d.Keep {
+			continue
+		}
+		manifest, err := backup.ReadManifest(ctx, p.Storage, d.Backup.StoragePath)
+		if err != nil {
+			continue
+		}
+		if start := manifest.LogStart(); start != nil {
+			keepFrom = start
+			break
+		}
+	}
+	if keepFrom == nil {
+		// Without a base backup there is nothing to tell which logs are needed
+		return nil
+	}
+
+	pruned, err := p.Logs.Prune(ctx, keepFrom, p.DryRun)
+	result.Logs = pruned
+	if err != nil {
+		return fmt.Errorf("failed to prune archived logs: %w", err)
+	}
+	if len(pruned) > 0 {
+		p.Logger.Info("Pruned %d archived log files before %s", len(pruned), pruned[len(pruned)-1].Name)
+	}
+	return nil
+}
+
+// remainingDependent returns the ID of a backup that still depends on the
+// given backup, ignoring backups already deleted in this run
+func (p *Pruner) remainingDependent(ctx context.Context, id string, deleted map[string]bool) (string, error) {
+	dependents, err := p.Catalog.Dependents(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to check dependents of backup %s: %w", id, err)
+	}
+
+	for _, dependent := range dependents {
+		if !deleted[dependent.ID] {
+			return dependent.ID, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,238 @@
+package retention
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata" // Time zones do not depend on the system database
+
+	"github.com/yourusername/backyardBackup/internal/backup"
+)
+
+// now is the time retention is applied at in the tests
+var now = time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+
+// successful returns a successful full backup started at t
+func successful(id string, t time.Time) *backup.BackupResult {
+	return &backup.BackupResult{ID: id, Type: backup.Full, StartTime: t, Success: true}
+}
+
+// daily returns successful backups started at midnight on the given days of
+// May 2024, oldest first, named after their day
+func daily(days ...int) []*backup.BackupResult {
+	var backups []*backup.BackupResult
+	for _, day := range days {
+		backups = append(backups, successful(fmt.Sprintf("d%02d", day), time.Date(2024, 5, day, 0, 0, 0, 0, time.UTC)))
+	}
+	return backups
+}
+
+// summarize formats decisions as "ID keep|delete: reason"
+func summarize(decisions []*Decision) []string {
+	lines := make([]string, len(decisions))
+	for i, d := range decisions {
+		action := "delete"
+		if d.Keep {
+			action = "keep"
+		}
+		lines[i] = fmt.Sprintf("%s %s: %s", d.Backup.ID, action, d.Reason)
+	}
+	return lines
+}
+
+func checkDecisions(t *testing.T, decisions []*Decision, want []string) {
+	t.Helper()
+	got := summarize(decisions)
+	if len(got) != len(want) {
+		t.Fatalf("got %d decisions, want %d:\n%s", len(got), len(want), strings.Join(got, "\n"))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("decision %d:\n got  %s\n want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPlanFlatLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   []string
+	}{
+		{"no policy", Policy{}, []string{
+			"d10 keep: no retention policy",
+			"d11 keep: no retention policy",
+			"d12 keep: no retention policy",
+			"d13 keep: no retention policy",
+			"d14 keep: no retention policy",
+			"d15 keep: most recent backup, no retention policy",
+		}},
+		{"retention days", Policy{RetentionDays: 3}, []string{
+			"d10 delete: older than 3 days",
+			"d11 delete: older than 3 days",
+			"d12 delete: older than 3 days",
+			"d13 keep: within 3 days",
+			"d14 keep: within 3 days",
+			"d15 keep: most recent backup, within 3 days",
+		}},
+		{"max backups", Policy{MaxBackups: 2}, []string{
+			"d10 delete: exceeds the 2 most recent backups",
+			"d11 delete: exceeds the 2 most recent backups",
+			"d12 delete: exceeds the 2 most recent backups",
+			"d13 delete: exceeds the 2 most recent backups",
+			"d14 keep: one of the 2 most recent backups",
+			"d15 keep: most recent backup, one of the 2 most recent backups",
+		}},
+		{"both limits", Policy{RetentionDays: 3, MaxBackups: 2}, []string{
+			"d10 delete: exceeds the 2 most recent backups",
+			"d11 delete: exceeds the 2 most recent backups",
+			"d12 delete: exceeds the 2 most recent backups",
+			"d13 delete: exceeds the 2 most recent backups",
+			"d14 keep: within 3 days",
+			"d15 keep: most recent backup, within 3 days",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkDecisions(t, Plan(daily(10, 11, 12, 13, 14, 15), tt.policy, now), tt.want)
+		})
+	}
+}
+
+func TestPlanKeepsMostRecent(t *testing.T) {
+	// Every backup is older than the limit, but the newest is still kept
+	later := now.AddDate(0, 0, 10)
+	checkDecisions(t, Plan(daily(13, 14, 15), Policy{RetentionDays: 1}, later), []string{
+		"d13 delete: older than 1 days",
+		"d14 delete: older than 1 days",
+		"d15 keep: most recent backup",
+	})
+}
+
+func TestPlanUnsortedInput(t *testing.T) {
+	// Decisions follow the order of the backups; ranks follow start times
+	backups := daily(14, 10, 15, 12)
+	checkDecisions(t, Plan(backups, Policy{MaxBackups: 2}, now), []string{
+		"d14 keep: one of the 2 most recent backups",
+		"d10 delete: exceeds the 2 most recent backups",
+		"d15 keep: most recent backup, one of the 2 most recent backups",
+		"d12 delete: exceeds the 2 most recent backups",
+	})
+}
+
+func TestPlanKeepsBases(t *testing.T) {
+	backups := daily(9, 10, 11, 12)
+	backups[2].Type, backups[2].BaseBackupID = backup.Incremental, "d10"
+	backups[3].Type, backups[3].BaseBackupID = backup.Incremental, "d11"
+
+	checkDecisions(t, Plan(backups, Policy{MaxBackups: 1}, now), []string{
+		"d09 delete: exceeds the 1 most recent backups",
+		"d10 keep: base of kept backup d12",
+		"d11 keep: base of kept backup d12",
+		"d12 keep: most recent backup, one of the 1 most recent backups",
+	})
+}
+
+func TestPlanAll(t *testing.T) {
+	backups := daily(10, 11, 12, 13, 14, 15)
+	policies := map[string]Policy{
+		"daily":  {MaxBackups: 2},
+		"weekly": {RetentionDays: 4},
+	}
+
+	// A backup is kept if any schedule keeps it
+	checkDecisions(t, PlanAll(backups, policies, now), []string{
+		"d10 delete: daily: exceeds the 2 most recent backups; weekly: older than 4 days",
+		"d11 delete: daily: exceeds the 2 most recent backups; weekly: older than 4 days",
+		"d12 keep: weekly: within 4 days",
+		"d13 keep: weekly: within 4 days",
+		"d14 keep: daily: one of the 2 most recent backups; weekly: within 4 days",
+		"d15 keep: daily: most recent backup, one of the 2 most recent backups; weekly: most recent backup, within 4 days",
+	})
+
+	// A single schedule is not named
+	checkDecisions(t, PlanAll(backups[4:], map[string]Policy{"daily": {MaxBackups: 1}}, now), []string{
+		"d14 delete: exceeds the 1 most recent backups",
+		"d15 keep: most recent backup, one of the 1 most recent backups",
+	})
+}
+
+func TestPolicyIsEmpty(t *testing.T) {
+	if !(Policy{}).IsEmpty() {
+		t.Error("zero policy is not empty")
+	}
+	for _, policy := range []Policy{{RetentionDays: 1}, {MaxBackups: 1}, {Daily: 1}, {Yearly: 1}} {
+		if policy.IsEmpty() {
+			t.Errorf("%+v is empty", policy)
+		}
+	}
+}
+
+func TestPlanGFS(t *testing.T) {
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	failed := successful("failed", at(2024, 5, 15, 11, 0))
+	failed.Success = false
+	backups := []*backup.BackupResult{
+		successful("2023-12-31", at(2023, 12, 31, 12, 0)),
+		successful("2024-03-31", at(2024, 3, 31, 12, 0)),
+		successful("2024-04-30", at(2024, 4, 30, 12, 0)),
+		successful("2024-05-06", at(2024, 5, 6, 12, 0)),
+		successful("2024-05-13", at(2024, 5, 13, 23, 0)),
+		successful("2024-05-14 08", at(2024, 5, 14, 8, 0)),
+		successful("2024-05-14 23", at(2024, 5, 14, 23, 0)),
+		successful("2024-05-15 09:00", at(2024, 5, 15, 9, 0)),
+		successful("2024-05-15 09:30", at(2024, 5, 15, 9, 30)),
+		successful("2024-05-15 10", at(2024, 5, 15, 10, 0)),
+		failed,
+	}
+	policy := Policy{Hourly: 2, Daily: 2, Weekly: 2, Monthly: 2, Yearly: 2}
+
+	// Each rule keeps the newest successful backup of each of its periods
+	checkDecisions(t, Plan(backups, policy, now), []string{
+		"2023-12-31 keep: yearly 2023",
+		"2024-03-31 delete: not selected by any retention rule",
+		"2024-04-30 keep: monthly 2024-04",
+		"2024-05-06 keep: weekly 2024-W19",
+		"2024-05-13 delete: not selected by any retention rule",
+		"2024-05-14 08 delete: not selected by any retention rule",
+		"2024-05-14 23 keep: daily 2024-05-14",
+		"2024-05-15 09:00 delete: not selected by any retention rule",
+		"2024-05-15 09:30 keep: hourly 2024-05-15 09:00",
+		"2024-05-15 10 keep: hourly 2024-05-15 10:00, daily 2024-05-15, weekly 2024-W20, monthly 2024-05, yearly 2024",
+		"failed keep: most recent backup",
+	})
+}
+
+func TestPlanGFSWithFlatLimits(t *testing.T) {
+	// A backup is kept by either the flat limits or a GFS rule
+	checkDecisions(t, Plan(daily(12, 13, 14, 15), Policy{MaxBackups: 1, Daily: 2}, now), []string{
+		"d12 delete: exceeds the 1 most recent backups",
+		"d13 delete: exceeds the 1 most recent backups",
+		"d14 keep: daily 2024-05-14",
+		"d15 keep: most recent backup, one of the 1 most recent backups, daily 2024-05-15",
+	})
+}
+
+func TestPlanGFSTimeZone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups := []*backup.BackupResult{
+		successful("late", time.Date(2024, 5, 14, 23, 30, 0, 0, time.UTC)),
+		successful("early", time.Date(2024, 5, 15, 0, 30, 0, 0, time.UTC)),
+	}
+
+	// Periods follow the time zone of now: both backups are on May 15 in Berlin
+	checkDecisions(t, Plan(backups, Policy{Daily: 2}, now), []string{
+		"late keep: daily 2024-05-14",
+		"early keep: most recent backup, daily 2024-05-15",
+	})
+	checkDecisions(t, Plan(backups, Policy{Daily: 2}, now.In(berlin)), []string{
+		"late delete: not selected by any retention rule",
+		"early keep: most recent backup, daily 2024-05-15",
+	})
+}
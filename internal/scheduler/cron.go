@@ -0,0 +1,286 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression. Each field is a bitmask of the
+// values it matches.
+type cronSchedule struct {
+	second   uint64
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	domStar  bool // Day of month was "*", so only day of week restricts days
+	dowStar  bool // Day of week was "*", so only day of month restricts days
+	location *time.Location
+}
+
+// cronField describes the allowed values of a cron field
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	secondField = cronField{name: "second", min: 0, max: 59}
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	domField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// cronMacros maps the supported @ shorthands to their expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// allHours is the hour field of a schedule that runs every hour
+const allHours = 1<<24 - 1
+
+// maxSearchYears bounds the search for the next run of expressions that
+// can never match, such as "0 0 30 2 *"
+const maxSearchYears = 5
+
+// parseCron parses a cron expression. It accepts the standard five fields
+// (minute hour day-of-month month day-of-week), an optional leading seconds
+// field, @ macros such as @daily, and a CRON_TZ= or TZ= prefix selecting the
+// time zone the expression is evaluated in (the local time zone by default).
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("empty cron expression")
+	}
+
+	// Time zone prefix
+	location := time.Local
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		i := strings.IndexAny(expr, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("cron expression %q has a time zone but no schedule", expr)
+		}
+		name := expr[strings.Index(expr, "=")+1 : i]
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", name, err)
+		}
+		location = loc
+		expr = strings.TrimSpace(expr[i:])
+	}
+
+	// Macros
+	if strings.HasPrefix(expr, "@") {
+		macro, ok := cronMacros[strings.ToLower(expr)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cron macro %q", expr)
+		}
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron expression %q must have 5 or 6 fields, got %d", expr, len(fields))
+	}
+
+	schedule := &cronSchedule{location: location}
+	var err error
+	if schedule.second, _, err = parseCronField(fields[0], secondField); err != nil {
+		return nil, err
+	}
+	if schedule.minute, _, err = parseCronField(fields[1], minuteField); err != nil {
+		return nil, err
+	}
+	if schedule.hour, _, err = parseCronField(fields[2], hourField); err != nil {
+		return nil, err
+	}
+	if schedule.dom, schedule.domStar, err = parseCronField(fields[3], domField); err != nil {
+		return nil, err
+	}
+	if schedule.month, _, err = parseCronField(fields[4], monthField); err != nil {
+		return nil, err
+	}
+	if schedule.dow, schedule.dowStar, err = parseCronField(fields[5], dowField); err != nil {
+		return nil, err
+	}
+
+	// Both 0 and 7 mean Sunday
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+
+	return schedule, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps.
+// It also reports whether the field was an unrestricted "*".
+func parseCronField(expr string, field cronField) (uint64, bool, error) {
+	var bits uint64
+	star := false
+
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+
+		// Range
+		var start, end int
+		switch {
+		case rangeExpr == "*" || rangeExpr == "?":
+			start, end = field.min, field.max
+			if !hasStep {
+				star = true
+			}
+		case strings.Contains(rangeExpr, "-"):
+			low, high, _ := strings.Cut(rangeExpr, "-")
+			var err error
+			if start, err = parseCronValue(low, field); err != nil {
+				return 0, false, err
+			}
+			if end, err = parseCronValue(high, field); err != nil {
+				return 0, false, err
+			}
+		default:
+			value, err := parseCronValue(rangeExpr, field)
+			if err != nil {
+				return 0, false, err
+			}
+			start, end = value, value
+			// "5/15" means every 15 starting at 5
+			if hasStep {
+				end = field.max
+			}
+		}
+		if start > end {
+			return 0, false, fmt.Errorf("invalid %s range %q", field.name, rangeExpr)
+		}
+
+		// Step
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepExpr)
+			if err != nil || step <= 0 {
+				return 0, false, fmt.Errorf("invalid %s step %q", field.name, stepExpr)
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, star, nil
+}
+
+// parseCronValue parses a single number or name of a cron field
+func parseCronValue(expr string, field cronField) (int, error) {
+	if value, ok := field.names[strings.ToLower(expr)]; ok {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(expr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", field.name, expr)
+	}
+	if value < field.min || value > field.max {
+		return 0, fmt.Errorf("%s %d out of range %d-%d", field.name, value, field.min, field.max)
+	}
+	return value, nil
+}
+
+// Next returns the first time after from that matches the schedule, or the
+// zero time if there is none within the next few years. Like cron, times in
+// the hour skipped when daylight saving time starts run when it ends, and
+// times in the hour repeated when it ends run only once, unless the schedule
+// runs every hour.
+func (c *cronSchedule) Next(from time.Time) time.Time {
+	origLocation := from.Location()
+	t := from.In(c.location).Truncate(time.Second).Add(time.Second)
+	yearLimit := t.Year() + maxSearchYears
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for c.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.location)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !c.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.location)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for c.hour&(1<<uint(t.Hour())) == 0 {
+		prev := t.Hour()
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, c.location).Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+		for skipped := prev + 1; skipped < t.Hour(); skipped++ {
+			if c.hour&(1<<uint(skipped)) != 0 {
+				return t.In(origLocation)
+			}
+		}
+	}
+
+	for c.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for c.second&(1<<uint(t.Second())) == 0 {
+		t = t.Truncate(time.Second).Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	// Ambiguous wall clock times resolve to their first occurrence
+	if c.hour != allHours && !t.Equal(time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, c.location)) {
+		t = t.Add(time.Second)
+		goto wrap
+	}
+
+	return t.In(origLocation)
+}
+
+// dayMatches applies the cron rule that a day matches if either the day of
+// month or the day of week matches, unless one of them is unrestricted
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,239 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Time zones do not depend on the system database
+)
+
+// mustLocation loads a time zone
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// nextRuns returns the next n runs of a schedule after from, formatted in
+// RFC 3339 in the time zone of from
+func nextRuns(t *testing.T, expr string, from time.Time, n int) []string {
+	t.Helper()
+	schedule, err := parseCron(expr)
+	if err != nil {
+		t.Fatalf("parseCron(%q): %v", expr, err)
+	}
+
+	var runs []string
+	for i := 0; i < n; i++ {
+		from = schedule.Next(from)
+		if from.IsZero() {
+			runs = append(runs, "never")
+			break
+		}
+		runs = append(runs, from.Format(time.RFC3339))
+	}
+	return runs
+}
+
+func checkRuns(t *testing.T, expr string, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%q: got runs %v, want %v", expr, got, want)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("%q: got runs %v, want %v", expr, got, want)
+			return
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2024, 5, 15, 10, 20, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"CRON_TZ=UTC * * * * *", []string{
+			"2024-05-15T10:21:00Z", "2024-05-15T10:22:00Z", "2024-05-15T10:23:00Z",
+		}},
+		{"CRON_TZ=UTC */15 * * * *", []string{
+			"2024-05-15T10:30:00Z", "2024-05-15T10:45:00Z", "2024-05-15T11:00:00Z",
+		}},
+		{"CRON_TZ=UTC 5/20 * * * *", []string{
+			"2024-05-15T10:25:00Z", "2024-05-15T10:45:00Z", "2024-05-15T11:05:00Z",
+		}},
+		{"CRON_TZ=UTC */10 * * * * *", []string{
+			"2024-05-15T10:20:40Z", "2024-05-15T10:20:50Z", "2024-05-15T10:21:00Z",
+		}},
+		{"CRON_TZ=UTC 0 2 * * *", []string{
+			"2024-05-16T02:00:00Z", "2024-05-17T02:00:00Z", "2024-05-18T02:00:00Z",
+		}},
+		{"CRON_TZ=UTC 30 9-17/4 * * mon-fri", []string{
+			"2024-05-15T13:30:00Z", "2024-05-15T17:30:00Z", "2024-05-16T09:30:00Z",
+		}},
+		{"CRON_TZ=UTC 0 0 * * 7", []string{
+			"2024-05-19T00:00:00Z", "2024-05-26T00:00:00Z", "2024-06-02T00:00:00Z",
+		}},
+		{"CRON_TZ=UTC 0 0 * * SUN", []string{
+			"2024-05-19T00:00:00Z", "2024-05-26T00:00:00Z", "2024-06-02T00:00:00Z",
+		}},
+		{"CRON_TZ=UTC 0 0 1,15 * *", []string{
+			"2024-06-01T00:00:00Z", "2024-06-15T00:00:00Z", "2024-07-01T00:00:00Z",
+		}},
+		// Day of month and day of week both restricted: either matches
+		{"CRON_TZ=UTC 0 0 13 * fri", []string{
+			"2024-05-17T00:00:00Z", "2024-05-24T00:00:00Z", "2024-05-31T00:00:00Z",
+		}},
+		{"CRON_TZ=UTC 0 0 31 * *", []string{
+			"2024-05-31T00:00:00Z", "2024-07-31T00:00:00Z", "2024-08-31T00:00:00Z",
+		}},
+		{"CRON_TZ=UTC 0 12 29 feb *", []string{
+			"2028-02-29T12:00:00Z", "2032-02-29T12:00:00Z",
+		}},
+		{"CRON_TZ=UTC 0 0 30 2 *", []string{"never"}},
+		{"CRON_TZ=UTC @hourly", []string{
+			"2024-05-15T11:00:00Z", "2024-05-15T12:00:00Z",
+		}},
+		{"CRON_TZ=UTC @daily", []string{
+			"2024-05-16T00:00:00Z", "2024-05-17T00:00:00Z",
+		}},
+		{"CRON_TZ=UTC @weekly", []string{
+			"2024-05-19T00:00:00Z", "2024-05-26T00:00:00Z",
+		}},
+		{"CRON_TZ=UTC @monthly", []string{
+			"2024-06-01T00:00:00Z", "2024-07-01T00:00:00Z",
+		}},
+		{"CRON_TZ=UTC @yearly", []string{
+			"2025-01-01T00:00:00Z", "2026-01-01T00:00:00Z",
+		}},
+		{"CRON_TZ=UTC 0 0 1 jan-mar/2 *", []string{
+			"2025-01-01T00:00:00Z", "2025-03-01T00:00:00Z", "2026-01-01T00:00:00Z",
+		}},
+	}
+	for _, tt := range tests {
+		checkRuns(t, tt.expr, nextRuns(t, tt.expr, from, len(tt.want)), tt.want)
+	}
+}
+
+func TestCronNextAfterMatch(t *testing.T) {
+	// A time that matches is not its own next run
+	schedule, err := parseCron("CRON_TZ=UTC 0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2024, 5, 15, 2, 0, 0, 0, time.UTC)
+	want := time.Date(2024, 5, 16, 2, 0, 0, 0, time.UTC)
+	if got := schedule.Next(from); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Fractions of a second are dropped
+	from = time.Date(2024, 5, 15, 1, 59, 59, 999999999, time.UTC)
+	want = time.Date(2024, 5, 15, 2, 0, 0, 0, time.UTC)
+	if got := schedule.Next(from); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCronTimeZone(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+	tokyo := mustLocation(t, "Asia/Tokyo")
+	from := time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+
+	// The schedule is evaluated in its zone; runs keep the zone of from
+	checkRuns(t, "CRON_TZ=Europe/Berlin 0 2 * * *",
+		nextRuns(t, "CRON_TZ=Europe/Berlin 0 2 * * *", from, 2),
+		[]string{"2024-05-16T00:00:00Z", "2024-05-17T00:00:00Z"})
+	checkRuns(t, "TZ=Asia/Tokyo 0 9 * * *",
+		nextRuns(t, "TZ=Asia/Tokyo 0 9 * * *", from.In(berlin), 2),
+		[]string{"2024-05-16T02:00:00+02:00", "2024-05-17T02:00:00+02:00"})
+
+	// Without a prefix the local time zone is used
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = tokyo
+	schedule, err := parseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 5, 16, 9, 0, 0, 0, tokyo)
+	if got := schedule.Next(from); !got.Equal(want) {
+		t.Errorf("local time zone: got %v, want %v", got, want)
+	}
+}
+
+func TestCronDaylightSaving(t *testing.T) {
+	newYork := mustLocation(t, "America/New_York")
+	// Clocks go forward at 02:00 on March 10 and back at 02:00 on November 3
+	spring := time.Date(2024, 3, 9, 12, 0, 0, 0, newYork)
+	autumn := time.Date(2024, 11, 2, 12, 0, 0, 0, newYork)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []string
+	}{
+		{"skipped time runs when the clocks go forward", "CRON_TZ=America/New_York 30 2 * * *", spring, []string{
+			"2024-03-10T03:00:00-04:00", "2024-03-11T02:30:00-04:00", "2024-03-12T02:30:00-04:00",
+		}},
+		{"times after the gap are unaffected", "CRON_TZ=America/New_York 30 3 * * *", spring, []string{
+			"2024-03-10T03:30:00-04:00", "2024-03-11T03:30:00-04:00", "2024-03-12T03:30:00-04:00",
+		}},
+		{"hourly runs follow the clock", "CRON_TZ=America/New_York 0 * * * *", time.Date(2024, 3, 10, 0, 30, 0, 0, newYork), []string{
+			"2024-03-10T01:00:00-05:00", "2024-03-10T03:00:00-04:00", "2024-03-10T04:00:00-04:00",
+		}},
+		{"repeated time runs once", "CRON_TZ=America/New_York 30 1 * * *", autumn, []string{
+			"2024-11-03T01:30:00-04:00", "2024-11-04T01:30:00-05:00", "2024-11-05T01:30:00-05:00",
+		}},
+		{"hourly runs repeat with the hour", "CRON_TZ=America/New_York 30 * * * *", time.Date(2024, 11, 3, 0, 45, 0, 0, newYork), []string{
+			"2024-11-03T01:30:00-04:00", "2024-11-03T01:30:00-05:00", "2024-11-03T02:30:00-05:00",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Runs are compared in the time zone of the schedule
+			var got []string
+			schedule, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from := tt.from
+			for range tt.want {
+				from = schedule.Next(from)
+				got = append(got, from.In(newYork).Format(time.RFC3339))
+			}
+			checkRuns(t, tt.expr, got, tt.want)
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"@fortnightly",
+		"CRON_TZ=Mars/Olympus 0 0 * * *",
+		"CRON_TZ=UTC",
+	}
+	for _, expr := range invalid {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded", expr)
+		}
+	}
+}
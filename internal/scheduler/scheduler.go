@@ -3,6 +3,9 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,49 +16,185 @@ import (
 
 // BackupTask represents a scheduled backup task
 type BackupTask struct {
-	Name     string
-	Schedule string // Cron expression
-	Type     backup.BackupType
-	DB       string
-	Storage  string
-	Options  backup.BackupOptions
-	NextRun  time.Time
+	Name         string
+	ScheduleName string // Name of the schedule in the configuration
+	Schedule     string // Cron expression
+	Type         backup.BackupType
+	DB           string
+	Storage      string
+	Options      backup.BackupOptions
+	NextRun      time.Time
+	LastRun      time.Time            // Scheduled time of the last finished run, restored from the state file
+	CatchUp      config.CatchUpPolicy // What to do with a run missed while the scheduler was down
+	Jitter       time.Duration        // Maximum random delay added to the start of each run
 }
 
+// maxSleep bounds how long the scheduler sleeps between checks, so that
+// wall-clock changes and suspended hosts are noticed
+const maxSleep = time.Minute
+
 // Scheduler manages scheduled backup operations
 type Scheduler struct {
-	tasks   []*BackupTask
-	runner  func(context.Context, *BackupTask) error
-	logger  *logging.Logger
-	mu      sync.Mutex
-	ctx     context.Context
-	cancel  context.CancelFunc
-	running bool
+	tasks     []*BackupTask
+	runner    func(context.Context, *BackupTask) error
+	logger    *logging.Logger
+	mu        sync.Mutex
+	ctx       context.Context // Cancelled by Stop; ends the loop and pending jitter delays
+	cancel    context.CancelFunc
+	runCtx    context.Context // Given to runners; cancelled only when Stop gives up waiting
+	runCancel context.CancelFunc
+	running   bool
+	active    map[string]bool // Tasks with a run in progress
+	wake      chan struct{}
+	wg        sync.WaitGroup
+	statePath string                // Where last-run state is persisted; empty disables persistence
+	state     map[string]*taskState // Persisted state by task name
 }
 
 // NewScheduler creates a new scheduler
 func NewScheduler(logger *logging.Logger, runner func(context.Context, *BackupTask) error) *Scheduler {
 	ctx, cancel := context.WithCancel(context.Background())
+	runCtx, runCancel := context.WithCancel(context.Background())
 	return &Scheduler{
-		tasks:   []*BackupTask{},
-		runner:  runner,
-		logger:  logger,
-		ctx:     ctx,
-		cancel:  cancel,
-		running: false,
+		tasks:     []*BackupTask{},
+		runner:    runner,
+		logger:    logger,
+		ctx:       ctx,
+		cancel:    cancel,
+		runCtx:    runCtx,
+		runCancel: runCancel,
+		running:   false,
+		active:    make(map[string]bool),
+		wake:      make(chan struct{}, 1),
+		state:     make(map[string]*taskState),
 	}
 }
 
-// LoadSchedules loads schedules from configuration
+// SetStateFile loads the persisted last-run state from path and keeps it up
+// to date as tasks run, so missed runs can be detected after a restart
+func (s *Scheduler) SetStateFile(path string) error {
+	state, err := loadState(path)
+	if err != nil {
+		return err
+	}
+	
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	s.statePath = path
+	s.state = state
+	for _, task := range s.tasks {
+		if st, ok := s.state[task.Name]; ok {
+			task.LastRun = st.LastRun
+		}
+	}
+	
+	return nil
+}
+
+// LoadSchedules loads schedules from configuration. Every cron expression of
+// a schedule (full, incremental, differential) becomes a separate task.
 func (s *Scheduler) LoadSchedules(cfg *config.Config) error {
-	return fmt.Errorf("scheduler not implemented yet")
+	if err := s.SetStateFile(filepath.Join(cfg.DataDir, StateFileName)); err != nil {
+		return err
+	}
+	
+	// Load schedules in a stable order
+	names := make([]string, 0, len(cfg.Schedules))
+	for name := range cfg.Schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	
+	now := time.Now()
+	for _, name := range names {
+		schedule := cfg.Schedules[name]
+		
+		dbName, storeName, err := cfg.ScheduleTarget(name)
+		if err != nil {
+			return err
+		}
+		
+		catchUp := schedule.CatchUp
+		switch catchUp {
+		case "":
+			catchUp = config.CatchUpRun
+		case config.CatchUpRun, config.CatchUpSkip:
+		default:
+			return fmt.Errorf("schedule %s: unsupported catch-up policy %q", name, catchUp)
+		}
+		if schedule.Jitter < 0 {
+			return fmt.Errorf("schedule %s: jitter must not be negative", name)
+		}
+		
+		expressions := []struct {
+			expr       string
+			backupType backup.BackupType
+		}{
+			{schedule.FullBackup, backup.Full},
+			{schedule.IncrementalBackup, backup.Incremental},
+			{schedule.DifferentialBackup, backup.Differential},
+			{schedule.PhysicalBackup, backup.Physical},
+		}
+		for _, e := range expressions {
+			if e.expr == "" {
+				continue
+			}
+			
+			nextRun, err := parseCronExpression(e.expr, now)
+			if err != nil {
+				return fmt.Errorf("schedule %s: invalid %s backup expression: %w", name, e.backupType, err)
+			}
+			
+			opts := backup.BackupOptions{
+				Type:        e.backupType,
+				Compress:    cfg.Compression,
+				SourceDB:    dbName,
+				DestStorage: storeName,
+			}
+			if compConfig := schedule.Compression; compConfig != nil {
+				opts.Compression = compConfig.Type
+				opts.CompressionLevel = compConfig.Level
+				opts.LongDistance = compConfig.LongDistance
+				opts.CompressionWorkers = compConfig.Workers
+			}
+			
+			s.AddTask(&BackupTask{
+				Name:         fmt.Sprintf("%s/%s", name, e.backupType),
+				ScheduleName: name,
+				Schedule:     e.expr,
+				Type:         e.backupType,
+				DB:           dbName,
+				Storage:      storeName,
+				Options:      opts,
+				NextRun:      nextRun,
+				CatchUp:      catchUp,
+				Jitter:       schedule.Jitter,
+			})
+		}
+	}
+	
+	return nil
 }
 
-// AddTask adds a new backup task to the scheduler
+// AddTask adds a new backup task to the scheduler. The next run is computed
+// from the task's cron expression if it is not set.
 func (s *Scheduler) AddTask(task *BackupTask) {
+	if task.NextRun.IsZero() {
+		nextRun, err := parseCronExpression(task.Schedule, time.Now())
+		if err != nil {
+			s.logger.Error("Task %s has an invalid schedule and will not run: %v", task.Name, err)
+		}
+		task.NextRun = nextRun
+	}
+	
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if st, ok := s.state[task.Name]; ok {
+		task.LastRun = st.LastRun
+	}
 	s.tasks = append(s.tasks, task)
+	s.notify()
 }
 
 // RemoveTask removes a backup task from the scheduler
@@ -81,20 +220,195 @@ func (s *Scheduler) Start() error {
 		return fmt.Errorf("scheduler already running")
 	}
 	s.running = true
+	s.catchUp(time.Now())
 	s.mu.Unlock()
 	
-	return fmt.Errorf("scheduler not implemented yet")
+	s.wg.Add(1)
+	go s.loop()
+	
+	return nil
 }
 
-// Stop stops the scheduler
-func (s *Scheduler) Stop() {
+// Stop stops starting new runs and waits for running backups to finish.
+// Runs still waiting out their jitter delay are dropped. When ctx is done
+// before the backups finish, they are cancelled and Stop waits for them to
+// return.
+func (s *Scheduler) Stop(ctx context.Context) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	
 	if s.running {
 		s.cancel()
 		s.running = false
 	}
+	s.mu.Unlock()
+	
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warning("Cancelling running backups")
+		s.runCancel()
+		<-done
+	}
+	s.runCancel()
+}
+
+// catchUp handles runs that were missed while the scheduler was down,
+// including runs it was interrupted in: tasks with the run policy are run
+// once now, others wait for their next run. It must be called with s.mu held.
+func (s *Scheduler) catchUp(now time.Time) {
+	for _, task := range s.tasks {
+		if task.LastRun.IsZero() {
+			// No run of the task has finished, so there may be no backup
+			if task.CatchUp == config.CatchUpRun {
+				s.logger.Warning("Task %s has never finished a run; running now", task.Name)
+				task.NextRun = now
+			}
+			continue
+		}
+		
+		missed, err := parseCronExpression(task.Schedule, task.LastRun)
+		if err != nil || !missed.Before(now) || !missed.Before(task.NextRun) {
+			continue
+		}
+		
+		if task.CatchUp == config.CatchUpSkip {
+			s.logger.Warning("Task %s missed its run at %s; skipping until %s",
+				task.Name, missed.Format(time.RFC3339), task.NextRun.Format(time.RFC3339))
+			continue
+		}
+		
+		s.logger.Warning("Task %s missed its run at %s; running now", task.Name, missed.Format(time.RFC3339))
+		task.NextRun = now
+	}
+}
+
+// loop runs due tasks until the scheduler is stopped
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+	
+	for {
+		now := time.Now()
+		sleep := maxSleep
+		
+		s.mu.Lock()
+		for _, task := range s.tasks {
+			if task.NextRun.IsZero() {
+				continue
+			}
+			if !task.NextRun.After(now) {
+				s.dispatch(task, now)
+			}
+			if !task.NextRun.IsZero() && task.NextRun.Sub(now) < sleep {
+				sleep = task.NextRun.Sub(now)
+			}
+		}
+		s.mu.Unlock()
+		
+		timer := time.NewTimer(sleep)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// dispatch starts a run of a due task and schedules its next run.
+// It must be called with s.mu held.
+func (s *Scheduler) dispatch(task *BackupTask, now time.Time) {
+	scheduled := task.NextRun
+	nextRun, err := parseCronExpression(task.Schedule, now)
+	if err != nil {
+		s.logger.Error("Task %s has an invalid schedule and will not run again: %v", task.Name, err)
+	}
+	task.NextRun = nextRun
+	
+	// Never run the same task twice at once
+	if s.active[task.Name] {
+		s.logger.Warning("Skipping run of task %s: previous run still in progress", task.Name)
+		return
+	}
+	s.active[task.Name] = true
+	
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.active, task.Name)
+			s.mu.Unlock()
+		}()
+		
+		// Spread the start of tasks scheduled at the same time. A run
+		// dropped here is caught up on the next start.
+		if task.Jitter > 0 {
+			delay := time.Duration(rand.Int63n(int64(task.Jitter)))
+			s.logger.Debug("Delaying task %s by %s", task.Name, delay)
+			timer := time.NewTimer(delay)
+			select {
+			case <-s.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		
+		s.logger.Info("Running scheduled task %s", task.Name)
+		start := time.Now()
+		result := "success"
+		if err := s.runner(s.runCtx, task); err != nil {
+			s.logger.Error("Scheduled task %s failed: %v", task.Name, err)
+			result = err.Error()
+		} else {
+			s.logger.Info("Scheduled task %s completed in %s", task.Name, time.Since(start))
+		}
+		
+		// The run only counts once it has finished, so a run the scheduler
+		// cancelled or crashed in is caught up on the next start
+		if s.runCtx.Err() != nil {
+			return
+		}
+		s.mu.Lock()
+		task.LastRun = scheduled
+		s.recordState(task.Name, func(st *taskState) {
+			st.LastRun = scheduled
+			st.LastResult = result
+		})
+		s.mu.Unlock()
+	}()
+}
+
+// recordState updates and persists the state of a task.
+// It must be called with s.mu held.
+func (s *Scheduler) recordState(name string, update func(*taskState)) {
+	st, ok := s.state[name]
+	if !ok {
+		st = &taskState{}
+		s.state[name] = st
+	}
+	update(st)
+	
+	if s.statePath == "" {
+		return
+	}
+	if err := saveState(s.statePath, s.state); err != nil {
+		s.logger.Error("Failed to save scheduler state: %v", err)
+	}
+}
+
+// notify wakes the scheduler loop so it picks up changed tasks
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
 }
 
 // ListTasks returns a list of all scheduled tasks
@@ -111,5 +425,14 @@ func (s *Scheduler) ListTasks() []*BackupTask {
 
 // parseCronExpression parses a cron expression and returns the next run time
 func parseCronExpression(expr string, from time.Time) (time.Time, error) {
-	return time.Time{}, fmt.Errorf("cron expression parsing not implemented yet")
+	schedule, err := parseCron(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	
+	next := schedule.Next(from)
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never matches", expr)
+	}
+	return next, nil
 } 
\ No newline at end of file
@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateFileName is the name of the scheduler state file inside the data directory
+const StateFileName = "scheduler-state.json"
+
+// taskState is the persisted state of a scheduled task
+type taskState struct {
+	LastRun    time.Time `json:"last_run"`              // Scheduled time of the last run that finished
+	LastResult string    `json:"last_result,omitempty"` // "success" or the error of the last completed run
+}
+
+// loadState reads the scheduler state file. A missing file yields an empty state.
+func loadState(path string) (map[string]*taskState, error) {
+	state := make(map[string]*taskState)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scheduler state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse scheduler state %s: %w", path, err)
+	}
+
+	return state, nil
+}
+
+// saveState atomically replaces the scheduler state file
+func saveState(path string, state map[string]*taskState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal scheduler state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write scheduler state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write scheduler state: %w", err)
+	}
+
+	return nil
+}
@@ -183,6 +183,39 @@ func (p *LocalProvider) List(ctx context.Context, prefix string) ([]FileInfo, er
 	return files, nil
 }
 
+// OrphanedSidecars returns the metadata files whose object is gone
+func (p *LocalProvider) OrphanedSidecars(ctx context.Context) ([]string, error) {
+	if p.basePath == "" {
+		return nil, fmt.Errorf("local storage provider not initialized")
+	}
+
+	var orphans []string
+	err := filepath.Walk(p.basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".metadata") {
+			return nil
+		}
+
+		if _, err := os.Stat(strings.TrimSuffix(path, ".metadata")); !os.IsNotExist(err) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(p.basePath, path)
+		if err != nil {
+			return err
+		}
+		orphans = append(orphans, relPath)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata files: %w", err)
+	}
+
+	return orphans, nil
+}
+
 // GetInfo returns metadata about the file at the given path
 func (p *LocalProvider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
 	if p.basePath == "" {
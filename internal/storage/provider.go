@@ -64,4 +64,12 @@ type Provider interface {
 	
 	// Type returns the storage provider type
 	Type() StorageType
+}
+
+// SidecarProvider is implemented by providers that keep object metadata in
+// files of their own next to the objects
+type SidecarProvider interface {
+	// OrphanedSidecars returns the paths of the sidecar files whose object
+	// is gone
+	OrphanedSidecars(ctx context.Context) ([]string, error)
 } 
\ No newline at end of file